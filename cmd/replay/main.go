@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The Matrix.org Foundation C.I.C.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Replays the inbound signaling of a conference captured by the SFU (see `conference.capture` in the config)
+// and logs what the conference sends back. The media and the peer connection events are not captured, see
+// `conference.Replay` for what the replay reproduces.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/config"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/id"
+)
+
+func main() {
+	var (
+		capturePath    = flag.String("capture", "", "capture file path")
+		configFilePath = flag.String("config", "", "configuration file path of the SFU (optional)")
+		realtime       = flag.Bool("realtime", false, "replay the messages with the captured delays")
+	)
+	flag.Parse()
+
+	logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true, ForceColors: true})
+	logrus.SetLevel(logrus.DebugLevel)
+
+	if *capturePath == "" {
+		logrus.Fatal("the capture file path is required")
+	}
+
+	// The same conference configuration as during the capture gives the same behaviour.
+	conferenceConfig := conference.Config{HeartbeatConfig: conference.Heartbeat{Timeout: 30, Interval: 30}}
+	webrtcConfig := webrtc_ext.Config{}
+	if *configFilePath != "" {
+		sfuConfig, err := config.LoadConfigFromPath(*configFilePath)
+		if err != nil {
+			logrus.WithError(err).Fatal("could not load config")
+		}
+
+		conferenceConfig, webrtcConfig = sfuConfig.Conference, sfuConfig.WebRTC
+	}
+
+	file, err := os.Open(*capturePath)
+	if err != nil {
+		logrus.WithError(err).Fatal("could not open capture")
+	}
+	defer file.Close()
+
+	capture, err := conference.ReadCapture(file)
+	if err != nil {
+		logrus.WithError(err).Fatal("could not read capture")
+	}
+
+	connectionFactory, err := webrtc_ext.NewPeerConnectionFactory(webrtcConfig)
+	if err != nil {
+		logrus.WithError(err).Fatal("could not create peer connection factory")
+	}
+
+	signaler := conference.NewReplaySignaler(id.DeviceID(capture.Header.DeviceID))
+	signaler.OnSend = func(msg signaling.MatrixMessage) {
+		logrus.WithFields(logrus.Fields{
+			"user_id":   msg.Recipient.UserID,
+			"device_id": msg.Recipient.DeviceID,
+		}).Infof("sent %T", msg.Message)
+	}
+
+	logrus.WithField("conf_id", capture.Header.ConferenceID).Infof("replaying %d messages", len(capture.Messages))
+
+	done, err := conference.Replay(capture, conferenceConfig, connectionFactory, signaler, *realtime)
+	if err != nil {
+		logrus.WithError(err).Fatal("could not replay capture")
+	}
+
+	<-done
+	logrus.Info("replay finished")
+}
@@ -22,17 +22,25 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/matrix-org/waterfall/pkg/admin"
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
 	"github.com/matrix-org/waterfall/pkg/config"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/profiling"
 	"github.com/matrix-org/waterfall/pkg/routing"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/event"
 )
 
+// How long to wait for the conferences to end on shutdown unless configured otherwise.
+const defaultShutdownTimeout = 10 * time.Second
+
 func main() {
 	// Parse command line flags.
 	var (
@@ -93,16 +101,19 @@ func main() {
 		deferred_functions = append(deferred_functions, telemetry_cleanup)
 	}
 
-	// Handle signal interruptions.
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		for _, function := range deferred_functions {
-			function()
+	// Set up the metrics (only exported over OTLP).
+	if config.Telemetry.OTLP.Host != "" {
+		if meterProvider, err := telemetry.SetupMetrics(config.Telemetry); err != nil {
+			logrus.WithError(err).Warn("could not set up metrics")
+		} else {
+			metrics_cleanup := func() {
+				if err := meterProvider.Shutdown(context.Background()); err != nil {
+					logrus.WithError(err).Error("could not shutdown metrics")
+				}
+			}
+			deferred_functions = append(deferred_functions, metrics_cleanup)
 		}
-		os.Exit(0)
-	}()
+	}
 
 	// Create matrix client.
 	matrixClient := signaling.NewMatrixClient(config.Matrix)
@@ -119,7 +130,65 @@ func main() {
 	defer close(matrixEvents)
 
 	// Start a router that will receive events from the matrix client and route them to the appropriate conference.
-	routing.StartRouter(matrixClient, connectionFactory, matrixEvents, config.Conference)
+	// Create a webhook notifier (if configured). It's stopped once the conferences ended,
+	// so that the last events are delivered.
+	webhooks := webhook.NewNotifier(config.Webhook)
+	deferred_functions = append(deferred_functions, webhooks.Stop)
+
+	budget := bandwidth.NewBudget(config.Egress, metrics.ForwardedBytesTotal)
+	router := routing.StartRouter(
+		matrixClient,
+		connectionFactory,
+		matrixEvents,
+		config.Conference,
+		config.Authorization,
+		budget,
+		webhooks,
+	)
+
+	// Start serving the metrics and the capacity (if configured).
+	if config.Metrics.Address != "" {
+		if err := metrics.StartServer(config.Metrics, budget); err != nil {
+			logrus.WithError(err).Fatal("could not start metrics endpoint")
+		}
+	}
+
+	// Handle signal interruptions: hang up all participants, wait for the conferences to end
+	// and only then flush the telemetry. The second signal exits immediately.
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		go func() {
+			<-c
+			logrus.Warn("exiting without waiting for the conferences to end")
+			os.Exit(1)
+		}()
+
+		timeout := defaultShutdownTimeout
+		if config.ShutdownTimeout > 0 {
+			timeout = time.Duration(config.ShutdownTimeout) * time.Second
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if err := router.Shutdown(ctx); err != nil {
+			logrus.WithError(err).Warn("not all conferences ended in time")
+		}
+
+		for _, function := range deferred_functions {
+			function()
+		}
+		os.Exit(0)
+	}()
+
+	// Start the admin API (if configured).
+	if config.Admin.Address != "" {
+		if err := admin.StartServer(config.Admin, router, budget); err != nil {
+			logrus.WithError(err).Fatal("could not start admin API")
+		}
+	}
 
 	// Start matrix client sync. This function will block until the sync fails.
 	if err := matrixClient.RunSync(func(e *event.Event) { matrixEvents <- e }); err != nil {
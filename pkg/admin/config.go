@@ -0,0 +1,9 @@
+package admin
+
+// Configuration of the admin API.
+type Config struct {
+	// The address to listen on, e.g. "127.0.0.1:8090". The API is disabled if not set.
+	Address string `yaml:"address"`
+	// The token that the requests must bear in the `Authorization` header.
+	Token string `yaml:"token"`
+}
@@ -0,0 +1,234 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	conf "github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	published "github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/routing"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/id"
+)
+
+// The part of the Router that the admin API uses.
+type Conferences interface {
+	ConferenceIDs() []string
+	WithConference(conferenceID string, request conf.AdminRequest) error
+}
+
+var errNotFound = errors.New("not found")
+
+// Timeouts of the requests, so that the slow clients can't hold the connections forever.
+const (
+	readHeaderTimeout = 10 * time.Second
+	writeTimeout      = 30 * time.Second
+)
+
+// The maximum size of a request body, the requests are tiny JSON objects.
+const maxRequestSize = 64 << 10
+
+type server struct {
+	token       string
+	conferences Conferences
+}
+
+// Starts the admin API in the background. The API allows to inspect the running conferences and
+// to act on them:
+//
+//	GET    /admin/conferences                  - snapshots of all conferences
+//	GET    /admin/conferences/{id}             - snapshot of a given conference
+//	DELETE /admin/conferences/{id}             - end a given conference
+//	POST   /admin/conferences/{id}/kick        - kick a participant: {"user_id", "device_id"}
+//	POST   /admin/conferences/{id}/force_layer - force a layer: {"user_id", "device_id", "track_id", "rid"}
+//
+// The capacity of the SFU (if given) is served on `/capacity` without the token, so that the load
+// balancers could check the headroom even if the metrics are disabled.
+func StartServer(config Config, conferences Conferences, capacity http.Handler) error {
+	if config.Token == "" {
+		return fmt.Errorf("admin.token must be set to enable the admin API")
+	}
+
+	server := &server{token: config.Token, conferences: conferences}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/conferences", server.authenticated(server.handleConferences))
+	mux.HandleFunc("/admin/conferences/", server.authenticated(server.handleConference))
+	if capacity != nil {
+		mux.Handle("/capacity", capacity)
+	}
+
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", config.Address, err)
+	}
+
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: readHeaderTimeout, WriteTimeout: writeTimeout}
+
+	go func() {
+		logrus.WithField("address", config.Address).Info("starting admin API")
+		if err := httpServer.Serve(listener); err != nil {
+			logrus.WithError(err).Error("admin API stopped")
+		}
+	}()
+
+	return nil
+}
+
+func (s *server) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (s *server) handleConferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	snapshots := []conf.ConferenceSnapshot{}
+	for _, conferenceID := range s.conferences.ConferenceIDs() {
+		if snapshot, err := s.snapshot(conferenceID); err == nil {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, snapshots)
+}
+
+func (s *server) handleConference(w http.ResponseWriter, r *http.Request) {
+	conferenceID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/conferences/"), "/")
+
+	var err error
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		var snapshot conf.ConferenceSnapshot
+		if snapshot, err = s.snapshot(conferenceID); err == nil {
+			writeJSON(w, http.StatusOK, snapshot)
+			return
+		}
+
+	case action == "" && r.Method == http.MethodDelete:
+		err = s.conferences.WithConference(conferenceID, func(c *conf.Conference) { c.End(conf.CallHangupConferenceEnded) })
+
+	case action == "kick" && r.Method == http.MethodPost:
+		err = s.kick(conferenceID, w, r)
+
+	case action == "force_layer" && r.Method == http.MethodPost:
+		err = s.forceLayer(conferenceID, w, r)
+
+	default:
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+
+	var tooLarge *http.MaxBytesError
+
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, routing.ErrConferenceNotFound),
+		errors.Is(err, conf.ErrParticipantNotFound),
+		errors.Is(err, participant.ErrTrackNotFound),
+		errors.Is(err, published.ErrSubscriptionNotFound),
+		errors.Is(err, errNotFound):
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
+
+func (s *server) snapshot(conferenceID string) (conf.ConferenceSnapshot, error) {
+	var snapshot conf.ConferenceSnapshot
+	err := s.conferences.WithConference(conferenceID, func(c *conf.Conference) {
+		snapshot = c.Snapshot()
+	})
+
+	return snapshot, err
+}
+
+type participantRequest struct {
+	UserID   id.UserID   `json:"user_id"`
+	DeviceID id.DeviceID `json:"device_id,omitempty"`
+}
+
+func (s *server) kick(conferenceID string, w http.ResponseWriter, r *http.Request) error {
+	var request participantRequest
+	if err := decodeRequest(w, r, &request); err != nil {
+		return err
+	}
+
+	var kicked int
+	if err := s.conferences.WithConference(conferenceID, func(c *conf.Conference) {
+		kicked = c.KickParticipants(request.UserID, request.DeviceID)
+	}); err != nil {
+		return err
+	}
+
+	if kicked == 0 {
+		return fmt.Errorf("%w: %s", conf.ErrParticipantNotFound, request.UserID)
+	}
+
+	return nil
+}
+
+type forceLayerRequest struct {
+	participantRequest
+	TrackID string `json:"track_id"`
+	// The RID of the layer, empty to lift the restriction.
+	RID string `json:"rid"`
+}
+
+func (s *server) forceLayer(conferenceID string, w http.ResponseWriter, r *http.Request) error {
+	var request forceLayerRequest
+	if err := decodeRequest(w, r, &request); err != nil {
+		return err
+	}
+
+	var forceErr error
+	if err := s.conferences.WithConference(conferenceID, func(c *conf.Conference) {
+		forceErr = c.ForceLayer(request.UserID, request.DeviceID, request.TrackID, request.RID)
+	}); err != nil {
+		return err
+	}
+
+	return forceErr
+}
+
+// Decodes the JSON body of a request, the bodies over `maxRequestSize` are rejected.
+func decodeRequest(w http.ResponseWriter, r *http.Request, request interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(request); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logrus.WithError(err).Warn("failed to write admin API response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
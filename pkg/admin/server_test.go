@@ -0,0 +1,108 @@
+package admin //nolint:testpackage
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	conf "github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	published "github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/routing"
+)
+
+type noConferences struct{}
+
+func (noConferences) ConferenceIDs() []string { return nil }
+
+func (noConferences) WithConference(string, conf.AdminRequest) error {
+	return routing.ErrConferenceNotFound
+}
+
+func TestServer(t *testing.T) {
+	server := &server{token: "secret", conferences: noConferences{}}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		token   string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{"missing token", http.MethodGet, "/admin/conferences", "", server.handleConferences, http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/admin/conferences", "wrong", server.handleConferences, http.StatusUnauthorized},
+		{"list", http.MethodGet, "/admin/conferences", "secret", server.handleConferences, http.StatusOK},
+		{"no conference", http.MethodGet, "/admin/conferences/x", "secret", server.handleConference, http.StatusNotFound},
+		{"no action", http.MethodPost, "/admin/conferences/x/y", "secret", server.handleConference, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		if test.token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		recorder := httptest.NewRecorder()
+		server.authenticated(test.handler)(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, recorder.Code)
+		}
+	}
+}
+
+type failingConferences struct{ err error }
+
+func (failingConferences) ConferenceIDs() []string { return nil }
+
+func (c failingConferences) WithConference(string, conf.AdminRequest) error {
+	return c.err
+}
+
+func TestServerNotFound(t *testing.T) {
+	for _, err := range []error{
+		fmt.Errorf("%w: @bob:server", conf.ErrParticipantNotFound),
+		fmt.Errorf("%w: track", participant.ErrTrackNotFound),
+		fmt.Errorf("%w: @bob:server", published.ErrSubscriptionNotFound),
+	} {
+		server := &server{token: "secret", conferences: failingConferences{err}}
+
+		request := httptest.NewRequest(http.MethodDelete, "/admin/conferences/x", nil)
+		recorder := httptest.NewRecorder()
+		server.handleConference(recorder, request)
+
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("%v: expected status %d, got %d", err, http.StatusNotFound, recorder.Code)
+		}
+	}
+}
+
+func TestServerRejectsLargeBodies(t *testing.T) {
+	server := &server{token: "secret", conferences: noConferences{}}
+
+	body := `{"user_id": "` + strings.Repeat("a", maxRequestSize) + `"}`
+	request := httptest.NewRequest(http.MethodPost, "/admin/conferences/x/kick", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	server.handleConference(recorder, request)
+
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, got %d", http.StatusRequestEntityTooLarge, recorder.Code)
+	}
+}
+
+func TestStartServerFailsToBind(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	config := Config{Address: listener.Addr().String(), Token: "secret"}
+	if err := StartServer(config, noConferences{}, nil); err == nil {
+		t.Fatal("expected the server to fail on the address in use")
+	}
+}
@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Characters that are not safe to use in a file name.
+var unsafeFileNameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// A file that is rotated once it grows over a given size: `<name>.jsonl` is renamed to `<name>.1.jsonl`,
+// the previous `<name>.1.jsonl` to `<name>.2.jsonl` and so on, the oldest ones are removed. The file is
+// never rotated if the maximum size is zero.
+type rotatingFile struct {
+	directory string
+	name      string
+	maxSize   int64
+	maxFiles  int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(directory string, name string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", directory, err)
+	}
+
+	file := &rotatingFile{
+		directory: directory,
+		name:      unsafeFileNameCharacters.ReplaceAllString(name, "_"),
+		maxSize:   maxSize,
+		maxFiles:  maxFiles,
+	}
+
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Appends a line to the file, rotating the file first if the line does not fit into it.
+func (f *rotatingFile) WriteLine(line []byte) error {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(line))+1 > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	written, err := f.file.Write(append(line, '\n'))
+	f.size += int64(written)
+	return err
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
+
+// Returns the path of the current file (0) or of a rotated one.
+func (f *rotatingFile) path(index int) string {
+	if index == 0 {
+		return filepath.Join(f.directory, f.name+".jsonl")
+	}
+
+	return filepath.Join(f.directory, fmt.Sprintf("%s.%d.jsonl", f.name, index))
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path(0), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", f.path(0), err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open %s: %w", f.path(0), err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	// The oldest file is simply overwritten by the rename.
+	for index := f.maxFiles - 1; index >= 0; index-- {
+		if err := os.Rename(f.path(index), f.path(index+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate %s: %w", f.path(0), err)
+		}
+	}
+
+	return f.open()
+}
@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// The number of records waiting to be written. Further records are dropped while the queue is full.
+	queueSize = 1024
+	// Rotation defaults.
+	defaultMaxFileSize = 100 // in megabytes
+	defaultMaxFiles    = 5
+)
+
+// Configuration of the audit log.
+type Config struct {
+	// The directory that the logs are written to, one file per conference. Disabled if not set.
+	Directory string `yaml:"directory"`
+	// Keeps the SDPs, they are replaced with a placeholder by default.
+	KeepSDP bool `yaml:"keepSdp"`
+	// Keeps the IP addresses (in the candidates, SDPs etc), they are replaced with a placeholder by default.
+	KeepIPs bool `yaml:"keepIps"`
+	// The size after which the file is rotated (in megabytes, 100 if not set).
+	MaxFileSize int `yaml:"maxFileSize"`
+	// The number of the rotated files to keep (5 if not set).
+	MaxFiles int `yaml:"maxFiles"`
+}
+
+// What the record is about.
+type Kind string
+
+const (
+	// A Matrix message (to-device event) received from a participant.
+	KindMatrix Kind = "matrix"
+	// A message from the peer connection of a participant.
+	KindPeer Kind = "peer"
+	// A state transition of the conference (e.g. a participant joined or a track was removed).
+	KindState Kind = "state"
+)
+
+// A single line of the audit log.
+type Record struct {
+	Time time.Time `json:"time"`
+	Kind Kind      `json:"kind"`
+	// The type of the message or the transition, e.g. "m.call.invite".
+	Type string `json:"type"`
+	// The participant that the record is about, if any.
+	UserID   string `json:"user_id,omitempty"`
+	DeviceID string `json:"device_id,omitempty"`
+	Data     any    `json:"data,omitempty"`
+}
+
+// The timeline of a single conference written as JSON lines in the background, so that the disk never
+// blocks the conference. Safe to use from any go-routine. A nil log ignores all records.
+type Log struct {
+	config Config
+	writer *Writer[Record]
+}
+
+// Opens (or creates) the audit log of a given conference. Returns nil if the audit log is not configured.
+func Open(config Config, conferenceID string) (*Log, error) {
+	if config.Directory == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	maxFileSize, maxFiles := config.MaxFileSize, config.MaxFiles
+	if maxFileSize <= 0 {
+		maxFileSize = defaultMaxFileSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultMaxFiles
+	}
+
+	log := &Log{config: config}
+	writer, err := OpenWriter(WriterConfig[Record]{
+		Directory: config.Directory,
+		Name:      conferenceID,
+		MaxSize:   int64(maxFileSize) << 20,
+		MaxFiles:  maxFiles,
+		QueueSize: queueSize,
+		Label:     "audit",
+		Encode:    log.encode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.writer = writer
+	return log, nil
+}
+
+// Queues a record. The data is marshalled to JSON (and redacted) in the background,
+// so it must not be modified afterwards.
+func (l *Log) Record(kind Kind, recordType string, userID string, deviceID string, data any) {
+	if l == nil {
+		return
+	}
+
+	record := Record{
+		Time:     time.Now(),
+		Kind:     kind,
+		Type:     recordType,
+		UserID:   userID,
+		DeviceID: deviceID,
+		Data:     data,
+	}
+
+	if err := l.writer.Write(record); err != nil {
+		logrus.WithError(err).WithField("type", recordType).Warn("dropping audit record")
+	}
+}
+
+// Writes the remaining records and closes the file.
+func (l *Log) Close() {
+	if l == nil {
+		return
+	}
+
+	if err := l.writer.Close(); err != nil {
+		logrus.WithError(err).Warn("failed to close audit log")
+	}
+}
+
+// Redacts and marshals a record, called on the writer's go-routine.
+func (l *Log) encode(record Record) ([]byte, error) {
+	if record.Data != nil {
+		data, err := redact(record.Data, !l.config.KeepSDP, !l.config.KeepIPs)
+		if err != nil {
+			logrus.WithError(err).WithField("type", record.Type).Warn("failed to marshal audit record")
+			data = nil
+		}
+
+		record.Data = data
+	}
+
+	return json.Marshal(record)
+}
@@ -0,0 +1,122 @@
+package audit //nolint:testpackage
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	data := map[string]any{
+		"offer": map[string]any{
+			"type": "offer",
+			"sdp":  "v=0\r\nc=IN IP4 192.168.1.2\r\n",
+		},
+		"candidates": []any{
+			"candidate:1 1 udp 2122260223 10.0.0.1 54321 typ host",
+			"candidate:2 1 udp 2122260223 fe80::1 54321 typ host",
+		},
+		"fingerprint": "sha-256 AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89",
+	}
+
+	value, err := redact(data, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, _ := json.Marshal(value)
+	for _, leaked := range []string{"192.168.1.2", "10.0.0.1", "fe80::1", "v=0"} {
+		if strings.Contains(string(encoded), leaked) {
+			t.Errorf("%q is not redacted: %s", leaked, encoded)
+		}
+	}
+
+	if !strings.Contains(string(encoded), "AB:CD:EF") {
+		t.Errorf("the fingerprint must not be redacted: %s", encoded)
+	}
+}
+
+func TestRedactIPAddresses(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.1":                     "[redacted]",
+		"10.0.0.1:54321":               "[redacted]:54321",
+		"ip 192.168.1.2:3478":          "ip [redacted]:3478",
+		"raddr 10.0.0.1.":              "raddr [redacted].",
+		"a:10.0.0.1":                   "a:[redacted]",
+		"[fe80::1]:3478":               "[[redacted]]:3478",
+		"sha-256 AB:CD:EF:01:23:45":    "sha-256 AB:CD:EF:01:23:45",
+		"candidate:1 1 udp 2122260223": "candidate:1 1 udp 2122260223",
+	}
+
+	for text, expected := range cases {
+		if actual := redactIPAddresses(text); actual != expected {
+			t.Errorf("%q: expected %q, got %q", text, expected, actual)
+		}
+	}
+}
+
+func TestLogRedactsByDefault(t *testing.T) {
+	directory := t.TempDir()
+
+	log, err := Open(Config{Directory: directory}, "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.Record(KindMatrix, "m.call.invite", "@user:example.org", "DEVICE", map[string]any{
+		"offer": map[string]any{"sdp": "v=0\r\nc=IN IP4 192.168.1.2\r\n"},
+	})
+	log.Close()
+
+	content, err := os.ReadFile(filepath.Join(directory, "conf.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(content), "v=0") || !strings.Contains(string(content), redacted) {
+		t.Errorf("the SDP is not redacted: %s", content)
+	}
+}
+
+func TestLogRotates(t *testing.T) {
+	directory := t.TempDir()
+
+	log, err := Open(Config{Directory: directory, MaxFiles: 2}, "!room:example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only a single record fits into a file.
+	log.writer.file.maxSize = 200
+
+	for i := 0; i < 7; i++ {
+		log.Record(KindState, "participant.joined", "@user:example.org", "DEVICE", nil)
+	}
+	log.Close()
+
+	files, _ := filepath.Glob(filepath.Join(directory, "*.jsonl"))
+	if len(files) != 3 {
+		t.Fatalf("expected the current and 2 rotated files, got %v", files)
+	}
+
+	file, err := os.Open(filepath.Join(directory, "_room_example.org.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid record: %v", err)
+		}
+
+		if record.Kind != KindState || record.UserID != "@user:example.org" {
+			t.Errorf("unexpected record: %+v", record)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"encoding/json"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// The placeholder that replaces the redacted values.
+const redacted = "[redacted]"
+
+// Everything that may be an IPv4 or an IPv6 address (possibly with a port or glued to other values by
+// a colon), the matches are checked with `redactCandidate`.
+var ipCandidate = regexp.MustCompile(`[0-9A-Fa-f:.]*[.:][0-9A-Fa-f:.]*`)
+
+// Converts the data into its JSON representation with the SDPs and/or the IP addresses replaced.
+func redact(data any, redactSDP bool, redactIPs bool) (any, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+
+	if !redactSDP && !redactIPs {
+		return value, nil
+	}
+
+	return redactValue(value, redactSDP, redactIPs), nil
+}
+
+func redactValue(value any, redactSDP bool, redactIPs bool) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, inner := range value {
+			if redactSDP && strings.EqualFold(key, "sdp") {
+				value[key] = redacted
+				continue
+			}
+
+			value[key] = redactValue(inner, redactSDP, redactIPs)
+		}
+
+		return value
+	case []any:
+		for i, inner := range value {
+			value[i] = redactValue(inner, redactSDP, redactIPs)
+		}
+
+		return value
+	case string:
+		if redactIPs {
+			return redactIPAddresses(value)
+		}
+
+		return value
+	default:
+		return value
+	}
+}
+
+func redactIPAddresses(text string) string {
+	return ipCandidate.ReplaceAllStringFunc(text, redactCandidate)
+}
+
+// Replaces the IP addresses in a match of `ipCandidate`, e.g. "10.0.0.1", "10.0.0.1:54321" or "a:10.0.0.1".
+func redactCandidate(match string) string {
+	if net.ParseIP(match) != nil {
+		return redacted
+	}
+
+	// The trailing punctuation (e.g. the end of a sentence) is not a part of the address.
+	candidate := strings.TrimRight(match, ".:")
+	suffix := match[len(candidate):]
+	if net.ParseIP(candidate) != nil {
+		return redacted + suffix
+	}
+
+	if host, port, err := net.SplitHostPort(candidate); err == nil && net.ParseIP(host) != nil {
+		return redacted + ":" + port + suffix
+	}
+
+	// IPv4 addresses glued to other values by a colon.
+	parts := strings.Split(candidate, ":")
+	for i, part := range parts {
+		if address := strings.TrimRight(part, "."); net.ParseIP(address) != nil {
+			parts[i] = redacted + part[len(address):]
+		}
+	}
+
+	return strings.Join(parts, ":") + suffix
+}
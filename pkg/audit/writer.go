@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/matrix-org/waterfall/pkg/worker"
+	"github.com/sirupsen/logrus"
+)
+
+// Configuration of a `Writer`.
+type WriterConfig[T any] struct {
+	// The directory that the file is written to, created if it does not exist.
+	Directory string
+	// The name of the file without the `.jsonl` extension, the characters that are not safe are replaced.
+	Name string
+	// The size after which the file is rotated (in bytes), never rotated if zero.
+	MaxSize int64
+	// The number of the rotated files to keep.
+	MaxFiles int
+	// The number of values waiting to be written. Further values are dropped while the queue is full.
+	QueueSize int
+	// Identifies the dropped values in the metrics.
+	Label string
+	// Converts a value into a single line.
+	Encode func(T) ([]byte, error)
+}
+
+// Writes the values to a file as JSON lines in the background, so that the disk never blocks the
+// caller. The values are written in the order they are queued. Safe to use from any go-routine.
+type Writer[T any] struct {
+	file   *rotatingFile
+	worker *worker.Worker[T]
+	encode func(T) ([]byte, error)
+	label  string
+}
+
+// Opens (or creates) the file and starts writing the queued values to it.
+func OpenWriter[T any](config WriterConfig[T]) (*Writer[T], error) {
+	file, err := openRotatingFile(config.Directory, config.Name, config.MaxSize, config.MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &Writer[T]{file: file, encode: config.Encode, label: config.Label}
+	writer.worker = worker.StartWorker(worker.Config[T]{
+		ChannelSize: config.QueueSize,
+		Timeout:     time.Hour,
+		OnTimeout:   func() {},
+		OnTask: func(value T) {
+			line, err := writer.encode(value)
+			if err != nil {
+				logrus.WithError(err).WithField("file", file.name).Warn("failed to encode line")
+				return
+			}
+
+			if err := file.WriteLine(line); err != nil {
+				logrus.WithError(err).WithField("file", file.name).Warn("failed to write line")
+			}
+		},
+	})
+
+	return writer, nil
+}
+
+// Queues a value. Never blocks, returns an error if the value is dropped since the queue is full.
+func (w *Writer[T]) Write(value T) error {
+	err := w.worker.Send(value)
+	if errors.Is(err, worker.ErrWorkerTooBusy) {
+		metrics.WorkerDrops.With(w.label).Inc()
+	}
+
+	return err
+}
+
+// Writes the remaining values and closes the file.
+func (w *Writer[T]) Close() error {
+	w.worker.Stop()
+	<-w.worker.Done()
+
+	return w.file.Close()
+}
@@ -0,0 +1,162 @@
+package bandwidth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrBudgetExceeded = errors.New("egress bandwidth budget exceeded")
+
+const (
+	// How often the egress bitrate is re-measured (at most).
+	measurementInterval = time.Second
+	// The share of the budget from which on the new subscriptions are steered to the lowest layers
+	// unless configured otherwise.
+	defaultSteeringThreshold = 0.8
+)
+
+// Configuration of the egress bandwidth budget.
+type Config struct {
+	// The total bitrate that the SFU may send to the subscribers (in kbit/s). No limit if not set.
+	Budget int `yaml:"budget"`
+	// The share of the budget (from 0 to 1) from which on the new video subscriptions get the lowest
+	// layer only. 0.8 if not set.
+	SteeringThreshold float64 `yaml:"steeringThreshold"`
+}
+
+// How much of the budget is used.
+type State int
+
+const (
+	// There is enough bandwidth for new subscriptions.
+	StateAvailable State = iota
+	// The budget is nearly used up, the new video subscriptions are steered to the lowest layers.
+	StateNearLimit
+	// The budget is used up, no new video subscriptions are accepted.
+	StateExhausted
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAvailable:
+		return "available"
+	case StateNearLimit:
+		return "near_limit"
+	case StateExhausted:
+		return "exhausted"
+	default:
+		return "unknown"
+	}
+}
+
+// Measures the egress bitrate of the SFU and compares it with the configured budget.
+// Safe to use from any go-routine.
+type Budget struct {
+	// The budget in bit/s, 0 if not limited.
+	budget            uint64
+	steeringThreshold float64
+
+	// Returns the total number of bytes sent to the subscribers so far.
+	sentBytes func() uint64
+	now       func() time.Time
+
+	mutex         sync.Mutex
+	lastSentBytes uint64
+	lastMeasured  time.Time
+	// Smoothed egress bitrate in bit/s.
+	bitrate float64
+}
+
+// Creates a new budget that measures the egress bitrate based on the total number of sent bytes.
+func NewBudget(config Config, sentBytes func() uint64) *Budget {
+	threshold := config.SteeringThreshold
+	if threshold <= 0 || threshold > 1 {
+		threshold = defaultSteeringThreshold
+	}
+
+	return &Budget{
+		budget:            uint64(config.Budget) * 1000,
+		steeringThreshold: threshold,
+		sentBytes:         sentBytes,
+		now:               time.Now,
+		lastSentBytes:     sentBytes(),
+		lastMeasured:      time.Now(),
+	}
+}
+
+// Returns the current egress bitrate in bit/s.
+func (b *Budget) Bitrate() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	if elapsed := now.Sub(b.lastMeasured); elapsed >= measurementInterval {
+		sent := b.sentBytes()
+		current := float64(sent-b.lastSentBytes) * 8 / elapsed.Seconds()
+
+		// Smoothen the spikes (e.g. key frames), but follow the trend quickly.
+		b.bitrate = (b.bitrate + current) / 2
+		b.lastSentBytes = sent
+		b.lastMeasured = now
+	}
+
+	return uint64(b.bitrate)
+}
+
+// Returns the configured budget in bit/s, 0 if not limited.
+func (b *Budget) Limit() uint64 {
+	return b.budget
+}
+
+// Returns the bitrate (bit/s) that the SFU can still send before the budget is used up.
+// Returns 0 if the budget is not limited.
+func (b *Budget) Headroom() uint64 {
+	if bitrate := b.Bitrate(); b.budget > bitrate {
+		return b.budget - bitrate
+	}
+
+	return 0
+}
+
+// Returns how much of the budget is used.
+func (b *Budget) State() State {
+	if b.budget == 0 {
+		return StateAvailable
+	}
+
+	switch bitrate := float64(b.Bitrate()); {
+	case bitrate >= float64(b.budget):
+		return StateExhausted
+	case bitrate >= float64(b.budget)*b.steeringThreshold:
+		return StateNearLimit
+	default:
+		return StateAvailable
+	}
+}
+
+// Advertises the headroom, so that the load balancers or the other SFUs could pick another
+// instance if this one is busy. Responds with 503 once the budget is used up.
+func (b *Budget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	state := b.State()
+
+	response := struct {
+		State    string `json:"state"`
+		Bitrate  uint64 `json:"bitrate"`
+		Budget   uint64 `json:"budget,omitempty"`
+		Headroom uint64 `json:"headroom,omitempty"`
+	}{state.String(), b.Bitrate(), b.budget, b.Headroom()}
+
+	w.Header().Set("Content-Type", "application/json")
+	if state == StateExhausted {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logrus.WithError(err).Warn("failed to write capacity response")
+	}
+}
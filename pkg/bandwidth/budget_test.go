@@ -0,0 +1,46 @@
+package bandwidth //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetState(t *testing.T) {
+	var sent uint64
+	now := time.Now()
+
+	budget := NewBudget(Config{Budget: 1000}, func() uint64 { return sent })
+	budget.now = func() time.Time { return now }
+	budget.lastMeasured = now
+
+	// Sends a given bitrate (bit/s) for a second and returns the new state of the budget.
+	send := func(bitrate uint64) State {
+		sent += bitrate / 8
+		now = now.Add(time.Second)
+		return budget.State()
+	}
+
+	cases := []struct {
+		bitrate  uint64
+		expected State
+	}{
+		{200_000, StateAvailable},
+		{1_500_000, StateNearLimit},
+		{2_200_000, StateExhausted},
+		{300_000, StateNearLimit},
+		{0, StateAvailable},
+	}
+
+	for i, c := range cases {
+		if state := send(c.bitrate); state != c.expected {
+			t.Errorf("case %d: expected %s, got %s (bitrate %d)", i, c.expected, state, budget.Bitrate())
+		}
+	}
+}
+
+func TestUnlimitedBudget(t *testing.T) {
+	budget := NewBudget(Config{}, func() uint64 { return 1 << 40 })
+	if state := budget.State(); state != StateAvailable {
+		t.Errorf("expected the unlimited budget to be available, got %s", state)
+	}
+}
@@ -0,0 +1,136 @@
+package conference
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	published "github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrParticipantNotFound = errors.New("participant not found")
+
+// A request of the admin API. It's executed by the main loop of the conference, so that it could access
+// the state of the conference safely. The methods below must only be called from such requests.
+type AdminRequest func(*Conference)
+
+const (
+	// The hangup reason that the participants get when the admin ends the conference.
+	CallHangupConferenceEnded event.CallHangupReason = "conference_ended"
+	// The hangup reason that the participants get when the SFU shuts down.
+	CallHangupShutdown event.CallHangupReason = "sfu_shutdown"
+)
+
+type ConferenceSnapshot struct {
+	ID           string                `json:"id"`
+	Participants []ParticipantSnapshot `json:"participants"`
+}
+
+type ParticipantSnapshot struct {
+	UserID          id.UserID            `json:"user_id"`
+	DeviceID        id.DeviceID          `json:"device_id"`
+	Role            string               `json:"role"`
+	PublishedTracks []published.Snapshot `json:"published_tracks"`
+}
+
+// Returns the current state of the conference: participants, their tracks and subscriptions.
+func (c *Conference) Snapshot() ConferenceSnapshot {
+	snapshot := ConferenceSnapshot{ID: c.id, Participants: []ParticipantSnapshot{}}
+
+	c.tracker.ForEachParticipant(func(id participant.ID, p *participant.Participant) {
+		snapshot.Participants = append(snapshot.Participants, ParticipantSnapshot{
+			UserID:          id.UserID,
+			DeviceID:        id.DeviceID,
+			Role:            p.Role.String(),
+			PublishedTracks: c.tracker.PublishedTrackSnapshots(id),
+		})
+	})
+
+	sort.Slice(snapshot.Participants, func(i, j int) bool {
+		a, b := snapshot.Participants[i], snapshot.Participants[j]
+		return a.UserID < b.UserID || (a.UserID == b.UserID && a.DeviceID < b.DeviceID)
+	})
+
+	return snapshot
+}
+
+// Removes the participants of a given user (or only a given device if set) from the conference.
+// Returns the number of removed participants.
+func (c *Conference) KickParticipants(userID id.UserID, deviceID id.DeviceID) int {
+	kicked := []*participant.Participant{}
+	c.tracker.ForEachParticipant(func(id participant.ID, participant *participant.Participant) {
+		if id.UserID == userID && (deviceID == "" || id.DeviceID == deviceID) {
+			kicked = append(kicked, participant)
+		}
+	})
+
+	for _, participant := range kicked {
+		c.newLogger(participant.ID).Info("Participant kicked")
+		c.removeParticipant(participant.ID, CallHangupKicked)
+		c.matrixWorker.sendSignalingMessage(participant.AsMatrixRecipient(), signaling.Hangup{Reason: CallHangupKicked})
+	}
+
+	return len(kicked)
+}
+
+// Hangs up all participants with a given reason, the conference ends once the request is processed.
+func (c *Conference) End(reason event.CallHangupReason) {
+	c.logger.WithField("reason", reason).Info("Ending the conference")
+
+	participants := []*participant.Participant{}
+	c.tracker.ForEachParticipant(func(_ participant.ID, participant *participant.Participant) {
+		participants = append(participants, participant)
+	})
+
+	for _, participant := range participants {
+		c.removeParticipant(participant.ID, reason)
+		c.matrixWorker.sendSignalingMessage(
+			participant.AsMatrixRecipient(),
+			signaling.Hangup{Reason: reason},
+		)
+	}
+
+	// The invites that wait for the power levels would be admitted to the ended conference otherwise.
+	pending := c.powerLevels.pending
+	c.powerLevels.pending = nil
+
+	for _, msg := range pending {
+		if invite, ok := msg.Content.(*event.CallInviteEventContent); ok {
+			c.newLogger(msg.Sender).Info("Rejecting invite that waits for the power levels")
+			c.rejectParticipant(msg.Sender, invite.SenderSessionID, reason)
+		}
+	}
+}
+
+// Forces the subscriptions of a given user (or only a given device if set) to a given track to use the
+// layer with a given RID. An empty RID lifts the restriction.
+func (c *Conference) ForceLayer(userID id.UserID, deviceID id.DeviceID, trackID string, rid string) error {
+	var forced int
+	var err error
+
+	c.tracker.ForEachParticipant(func(id participant.ID, _ *participant.Participant) {
+		if id.UserID != userID || (deviceID != "" && id.DeviceID != deviceID) || err != nil {
+			return
+		}
+
+		if err = c.tracker.ForceSubscriptionLayer(id, trackID, rid); err == nil {
+			forced++
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if forced == 0 {
+		return fmt.Errorf("%w: %s", ErrParticipantNotFound, userID)
+	}
+
+	c.logger.WithFields(logrus.Fields{"user_id": userID, "track": trackID, "rid": rid}).Info("Layer forced")
+	return nil
+}
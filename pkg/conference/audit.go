@@ -0,0 +1,71 @@
+package conference
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/matrix-org/waterfall/pkg/audit"
+	"github.com/matrix-org/waterfall/pkg/channel"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"maunium.net/go/mautrix/event"
+)
+
+// Records a Matrix message received from a participant in the audit log.
+func (c *Conference) auditMatrixMessage(msg MatrixMessage) {
+	eventType, ok := matrixEventType(msg.Content)
+	if !ok {
+		eventType = fmt.Sprintf("%T", msg.Content)
+	}
+
+	c.audit.Record(audit.KindMatrix, eventType, msg.Sender.UserID.String(), msg.Sender.DeviceID.String(), msg.Content)
+}
+
+// Records a message from the peer connection of a participant in the audit log.
+func (c *Conference) auditPeerMessage(message channel.Message[participant.ID, peer.MessageContent]) {
+	var data any
+	switch msg := message.Content.(type) {
+	case peer.LeftTheCall:
+		data = map[string]string{"reason": string(msg.Reason)}
+	case peer.NewTrackPublished:
+		data = map[string]string{
+			"track_id":  msg.RemoteTrack.ID(),
+			"stream_id": msg.RemoteTrack.StreamID(),
+			"kind":      msg.RemoteTrack.Kind().String(),
+			"rid":       msg.RemoteTrack.RID(),
+		}
+	case peer.NewICECandidate:
+		if msg.Candidate != nil {
+			data = msg.Candidate.ToJSON()
+		}
+	case peer.RenegotiationRequired:
+		data = msg.Offer
+	case peer.DataChannelMessage:
+		// The messages are JSON, so that their content (e.g. the SDP) could be redacted.
+		if json.Valid([]byte(msg.Message)) {
+			data = json.RawMessage(msg.Message)
+		} else {
+			data = msg.Message
+		}
+	}
+
+	messageType := strings.TrimPrefix(fmt.Sprintf("%T", message.Content), "peer.")
+	c.audit.Record(audit.KindPeer, messageType, message.Sender.UserID.String(), message.Sender.DeviceID.String(), data)
+}
+
+// Returns the type of the to-device event that a given Matrix message was received as.
+func matrixEventType(content MessageContent) (string, bool) {
+	switch content.(type) {
+	case *event.CallInviteEventContent:
+		return event.ToDeviceCallInvite.Type, true
+	case *event.CallCandidatesEventContent:
+		return event.ToDeviceCallCandidates.Type, true
+	case *event.CallSelectAnswerEventContent:
+		return event.ToDeviceCallSelectAnswer.Type, true
+	case *event.CallHangupEventContent:
+		return event.ToDeviceCallHangup.Type, true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,57 @@
+package conference
+
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	published "github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/id"
+)
+
+// Subscribes the participant to all tracks if the auto-subscribe mode applies to it. The subscriptions
+// are made by the subscription rules, so that the tracks published later are subscribed too. The rules
+// are set once, so that the participant may replace them with its own rules if it wants to.
+func (c *Conference) applyAutoSubscribe(p *participant.Participant) {
+	if p.SubscriptionRules != nil || !c.isAutoSubscribed(p.ID.UserID) {
+		return
+	}
+
+	rules := []participant.SubscriptionRule{
+		{Kind: webrtc.RTPCodecTypeAudio},
+		{
+			Kind: webrtc.RTPCodecTypeVideo,
+			Requirements: published.SubscriptionRequirements{
+				Width:  c.config.AutoSubscribe.Width,
+				Height: c.config.AutoSubscribe.Height,
+			},
+		},
+	}
+
+	p.Logger.Info("Auto-subscribing to all tracks")
+	subscriptions, err := c.tracker.SetSubscriptionRules(p.ID, rules)
+	if err != nil {
+		p.Logger.WithError(err).Error("Failed to auto-subscribe")
+		return
+	}
+
+	c.applySubscriptionRules(subscriptions)
+}
+
+// Returns `true` if the auto-subscribe mode applies to a given user in this conference.
+func (c *Conference) isAutoSubscribed(userID id.UserID) bool {
+	config := c.config.AutoSubscribe
+	return config.Enabled || containsUser(config.Users, userID) || containsRoom(config.Rooms, c.powerLevels.roomID)
+}
+
+func containsRoom(rooms []id.RoomID, roomID id.RoomID) bool {
+	if roomID == "" {
+		return false
+	}
+
+	for _, room := range rooms {
+		if room == roomID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,198 @@
+package conference
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/audit"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrInvalidCapture = errors.New("invalid capture")
+
+// The number of messages waiting to be written. Further messages are dropped while the queue is full.
+const captureQueueSize = 4096
+
+// Configuration of the capture mode that records the inbound signaling of the conferences, so that
+// it could be replayed later (see `Replay`).
+type CaptureConfig struct {
+	// The directory that the captures are written to, one file per conference. Disabled if not set.
+	Directory string `yaml:"directory"`
+}
+
+// Where a captured message came from.
+type CaptureSource string
+
+const (
+	// A to-device message received over Matrix.
+	CaptureSourceMatrix CaptureSource = "matrix"
+	// A message received over the data channel of a participant.
+	CaptureSourceDataChannel CaptureSource = "data_channel"
+)
+
+// The first line of a capture.
+type CaptureHeader struct {
+	ConferenceID string `json:"conference_id"`
+	RoomID       string `json:"room_id,omitempty"`
+	// The device of the SFU, the clients refer to it when selecting the answer.
+	DeviceID  string    `json:"device_id"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// A single inbound message of a capture.
+type CapturedMessage struct {
+	// Time since the beginning of the capture.
+	Offset   time.Duration `json:"offset"`
+	Source   CaptureSource `json:"source"`
+	UserID   string        `json:"user_id"`
+	DeviceID string        `json:"device_id"`
+	CallID   string        `json:"call_id"`
+	// The type and the content of the to-device event, if it's a Matrix message.
+	EventType string          `json:"event_type,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	// The message, if it's a data channel message.
+	Message string `json:"message,omitempty"`
+}
+
+// The inbound signaling of a single conference.
+type Capture struct {
+	Header   CaptureHeader
+	Messages []CapturedMessage
+}
+
+// Writes the inbound messages of a conference to a file as JSON lines. The messages are written in the
+// background in the order the conference processes them. The capture is incomplete (and the replay
+// not reliable) if messages were dropped since the disk could not keep up, which is logged.
+type captureRecorder struct {
+	writer    *audit.Writer[any]
+	startedAt time.Time
+}
+
+// Creates a new capture file of a given conference. Returns nil if the capture mode is not enabled.
+func newCaptureRecorder(config CaptureConfig, header CaptureHeader) (*captureRecorder, error) {
+	if config.Directory == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	// A capture is never rotated, since the replay needs all of it.
+	writer, err := audit.OpenWriter(audit.WriterConfig[any]{
+		Directory: config.Directory,
+		Name:      fmt.Sprintf("%s-%s.capture", header.ConferenceID, header.StartedAt.UTC().Format("20060102T150405.000")),
+		QueueSize: captureQueueSize,
+		Label:     "capture",
+		Encode:    func(value any) ([]byte, error) { return json.Marshal(value) },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture: %w", err)
+	}
+
+	recorder := &captureRecorder{writer: writer, startedAt: header.StartedAt}
+	recorder.write(header)
+	return recorder, nil
+}
+
+// Records a Matrix message handled by the conference.
+func (r *captureRecorder) recordMatrixMessage(msg MatrixMessage) {
+	if r == nil {
+		return
+	}
+
+	eventType, ok := matrixEventType(msg.Content)
+	if !ok {
+		return
+	}
+
+	content, err := json.Marshal(msg.Content)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to capture matrix message")
+		return
+	}
+
+	r.record(msg.Sender, CapturedMessage{Source: CaptureSourceMatrix, EventType: eventType, Content: content})
+}
+
+// Records a message received over the data channel of a participant.
+func (r *captureRecorder) recordDataChannelMessage(sender participant.ID, message string) {
+	if r == nil {
+		return
+	}
+
+	r.record(sender, CapturedMessage{Source: CaptureSourceDataChannel, Message: message})
+}
+
+func (r *captureRecorder) record(sender participant.ID, msg CapturedMessage) {
+	msg.Offset = time.Since(r.startedAt)
+	msg.UserID = sender.UserID.String()
+	msg.DeviceID = sender.DeviceID.String()
+	msg.CallID = sender.CallID
+	r.write(msg)
+}
+
+func (r *captureRecorder) write(value any) {
+	if err := r.writer.Write(value); err != nil {
+		logrus.WithError(err).Warn("Dropping captured message, the capture is incomplete")
+	}
+}
+
+func (r *captureRecorder) close() {
+	if r == nil {
+		return
+	}
+
+	if err := r.writer.Close(); err != nil {
+		logrus.WithError(err).Warn("Failed to close capture")
+	}
+}
+
+// Reads a capture written in the capture mode.
+func ReadCapture(reader io.Reader) (*Capture, error) {
+	scanner := bufio.NewScanner(reader)
+	// The SDPs may be long.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("%w: no header", ErrInvalidCapture)
+	}
+
+	capture := &Capture{}
+	if err := json.Unmarshal(scanner.Bytes(), &capture.Header); err != nil {
+		return nil, fmt.Errorf("%w: header: %s", ErrInvalidCapture, err)
+	}
+
+	for scanner.Scan() {
+		var msg CapturedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, fmt.Errorf("%w: message %d: %s", ErrInvalidCapture, len(capture.Messages)+1, err)
+		}
+
+		capture.Messages = append(capture.Messages, msg)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read capture: %w", err)
+	}
+
+	return capture, nil
+}
+
+// Returns the sender of a captured message.
+func (m CapturedMessage) sender() participant.ID {
+	return participant.ID{UserID: id.UserID(m.UserID), DeviceID: id.DeviceID(m.DeviceID), CallID: m.CallID}
+}
+
+// Parses the content of a captured Matrix message into the form that the conference expects.
+func (m CapturedMessage) matrixMessage() (MatrixMessage, error) {
+	content := event.Content{VeryRaw: m.Content}
+	if err := content.ParseRaw(event.Type{Type: m.EventType, Class: event.ToDeviceEventType}); err != nil {
+		return MatrixMessage{}, fmt.Errorf("%w: %s: %s", ErrInvalidCapture, m.EventType, err)
+	}
+
+	return MatrixMessage{Sender: m.sender(), Content: content.Parsed}, nil
+}
@@ -0,0 +1,595 @@
+package conferencetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/transport/vnet"
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// A participant of the conference that uses Pion in place of a browser.
+type Client struct {
+	UserID   id.UserID
+	DeviceID id.DeviceID
+
+	harness        *Harness
+	callID         string
+	streamID       string
+	peerConnection *webrtc.PeerConnection
+	dataChannel    *webrtc.DataChannel
+	published      []*publishedTrack
+
+	connected chan struct{}
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	mutex     sync.Mutex
+	metadata  event.CallSDPStreamMetadata
+	results   []conference.FocusCallTrackSubscriptionResultEventContent
+	rejected  []conference.FocusCallTrackPublishResultEventContent
+	received  map[string]*ReceivedTrack
+	hangup    *event.CallHangupReason
+	lastError error
+}
+
+// A track that the client receives from the SFU.
+type ReceivedTrack struct {
+	mutex   sync.Mutex
+	kind    webrtc.RTPCodecType
+	packets int
+	// The simulcast layer of the latest video frame, see `TrackSpec`.
+	layer string
+}
+
+// Returns the number of RTP packets received so far.
+func (t *ReceivedTrack) Packets() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.packets
+}
+
+// Returns the simulcast layer (RID) that the latest video packet belongs to.
+func (t *ReceivedTrack) Layer() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.layer
+}
+
+func newClient(
+	harness *Harness,
+	userID id.UserID,
+	deviceID id.DeviceID,
+	network *vnet.Net,
+	tracks []TrackSpec,
+) (*Client, error) {
+	api, err := newClientAPI(network)
+	if err != nil {
+		return nil, err
+	}
+
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create peer connection: %w", err)
+	}
+
+	client := &Client{
+		UserID:         userID,
+		DeviceID:       deviceID,
+		harness:        harness,
+		callID:         fmt.Sprintf("call-%s", deviceID),
+		streamID:       fmt.Sprintf("stream-%s", deviceID),
+		peerConnection: peerConnection,
+		connected:      make(chan struct{}),
+		stop:           make(chan struct{}),
+		received:       make(map[string]*ReceivedTrack),
+	}
+
+	var connectedOnce sync.Once
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			connectedOnce.Do(func() { close(client.connected) })
+		}
+	})
+	peerConnection.OnTrack(client.onTrack)
+
+	if client.dataChannel, err = peerConnection.CreateDataChannel("data", nil); err != nil {
+		return nil, fmt.Errorf("failed to create data channel: %w", err)
+	}
+	client.dataChannel.OnMessage(client.onDataChannelMessage)
+
+	for _, spec := range tracks {
+		track, err := publishTrack(peerConnection, client.streamID, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		client.published = append(client.published, track)
+	}
+
+	return client, nil
+}
+
+// Creates the WebRTC API of a client that behaves like a browser: it uses the default codecs and
+// interceptors and announces the RTP header extensions for simulcast.
+func newClientAPI(network *vnet.Net) (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("failed to register default codecs: %w", err)
+	}
+
+	for _, extension := range []string{
+		"urn:ietf:params:rtp-hdrext:sdes:mid",
+		"urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id",
+	} {
+		if err := mediaEngine.RegisterHeaderExtension(
+			webrtc.RTPHeaderExtensionCapability{URI: extension},
+			webrtc.RTPCodecTypeVideo,
+		); err != nil {
+			return nil, fmt.Errorf("failed to register simulcast extension: %w", err)
+		}
+	}
+
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetVNet(network)
+
+	return webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+		webrtc.WithSettingEngine(settingEngine),
+	), nil
+}
+
+// Creates the `m.call.invite` with the offer of the client. The offer contains all ICE candidates.
+func (c *Client) invite() (*event.CallInviteEventContent, error) {
+	offer, err := c.peerConnection.CreateOffer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	gatheringComplete := webrtc.GatheringCompletePromise(c.peerConnection)
+	if err := c.peerConnection.SetLocalDescription(offer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+	<-gatheringComplete
+
+	return &event.CallInviteEventContent{
+		BaseCallEventContent: c.baseEventContent(),
+		Lifetime:             30000,
+		Offer: event.CallData{
+			Type: event.CallDataTypeOffer,
+			SDP:  c.peerConnection.LocalDescription().SDP,
+		},
+		SDPStreamMetadata: c.streamMetadata(),
+	}, nil
+}
+
+func (c *Client) baseEventContent() event.BaseCallEventContent {
+	return event.BaseCallEventContent{
+		CallID:          c.callID,
+		ConfID:          conferenceID,
+		DeviceID:        c.DeviceID,
+		SenderSessionID: id.SessionID(fmt.Sprintf("session-%s", c.DeviceID)),
+		Version:         "1",
+	}
+}
+
+// The metadata of the tracks that the client publishes.
+func (c *Client) streamMetadata() event.CallSDPStreamMetadata {
+	tracks := make(event.CallSDPStreamMetadataTracks)
+	for _, track := range c.published {
+		width, height := track.spec.maxResolution()
+		tracks[track.spec.ID] = event.CallSDPStreamMetadataTrack{
+			Kind:   track.spec.Kind.String(),
+			Width:  width,
+			Height: height,
+		}
+	}
+
+	return event.CallSDPStreamMetadata{
+		c.streamID: event.CallSDPStreamMetadataObject{
+			UserID:   c.UserID,
+			DeviceID: c.DeviceID,
+			Purpose:  event.Usermedia,
+			Tracks:   tracks,
+		},
+	}
+}
+
+// Waits until the peer connection of the client is connected and starts publishing the tracks.
+func (c *Client) WaitForConnection(timeout time.Duration) {
+	c.harness.t.Helper()
+
+	select {
+	case <-c.connected:
+	case <-time.After(timeout):
+		c.harness.t.Fatalf("%s did not connect: %v", c.DeviceID, c.error())
+	}
+
+	for _, track := range c.published {
+		if err := track.start(c.peerConnection, c.stop); err != nil {
+			c.harness.t.Fatalf("failed to publish %s: %v", track.spec.ID, err)
+		}
+	}
+}
+
+// Returns the metadata of the tracks that the client can subscribe to (nil if none received yet).
+func (c *Client) Metadata() event.CallSDPStreamMetadata {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.metadata
+}
+
+// Checks whether the metadata from the SFU lists a given track.
+func (c *Client) HasTrack(trackID string) bool {
+	for _, stream := range c.Metadata() {
+		if _, ok := stream.Tracks[trackID]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Subscribes the client to a published track, the width and height select the simulcast layer.
+func (c *Client) Subscribe(publisher *Client, trackID string, width, height int) {
+	c.harness.t.Helper()
+
+	c.sendOverDataChannel(event.FocusCallTrackSubscription, conference.TrackSubscriptionEventContent{
+		Subscribe: []conference.TrackSubscriptionDescription{{
+			FocusTrackDescription: event.FocusTrackDescription{
+				StreamID: publisher.streamID,
+				TrackID:  trackID,
+				Width:    width,
+				Height:   height,
+			},
+		}},
+	})
+}
+
+// Replaces the subscription rules of the client, so that it's subscribed to all tracks that match them.
+func (c *Client) SetSubscriptionRules(rules ...conference.SubscriptionRuleDescription) {
+	c.harness.t.Helper()
+
+	c.sendOverDataChannel(event.FocusCallTrackSubscription, conference.TrackSubscriptionEventContent{
+		Rules: &rules,
+	})
+}
+
+// Sends the metadata of the published streams again, as the clients do when e.g. muting a track.
+func (c *Client) SendMetadata() {
+	c.harness.t.Helper()
+
+	c.sendOverDataChannel(event.FocusCallSDPStreamMetadataChanged, event.FocusCallSDPStreamMetadataChangedEventContent{
+		SDPStreamMetadata: c.streamMetadata(),
+	})
+}
+
+// Pauses the simulcast layer of a published track (all layers if `rid` is empty), e.g. to simulate a stall.
+func (c *Client) Pause(trackID, rid string) {
+	c.publishedTrack(trackID).setPaused(rid, true)
+}
+
+// Resumes the layer paused by `Pause`.
+func (c *Client) Resume(trackID, rid string) {
+	c.publishedTrack(trackID).setPaused(rid, false)
+}
+
+// Leaves the conference with `m.call.hangup`.
+func (c *Client) Hangup() {
+	c.harness.t.Helper()
+
+	c.harness.sendMatrixMessage(c, &event.CallHangupEventContent{
+		BaseCallEventContent: c.baseEventContent(),
+		Reason:               event.CallHangupUserHangup,
+	})
+}
+
+// Waits until the client receives at least `count` packets of a given track.
+func (c *Client) WaitForPackets(trackID string, count int, timeout time.Duration) *ReceivedTrack {
+	c.harness.t.Helper()
+
+	var track *ReceivedTrack
+	Eventually(c.harness.t, timeout, func() bool {
+		track = c.ReceivedTrack(trackID)
+		return track != nil && track.Packets() >= count
+	}, "%s did not receive %d packets of %s", c.DeviceID, count, trackID)
+
+	return track
+}
+
+// Waits until the client receives the video of a given track from a given simulcast layer.
+func (c *Client) WaitForLayer(trackID, rid string, timeout time.Duration) {
+	c.harness.t.Helper()
+
+	Eventually(c.harness.t, timeout, func() bool {
+		track := c.ReceivedTrack(trackID)
+		return track != nil && track.Layer() == rid
+	}, "%s did not receive the layer %q of %s", c.DeviceID, rid, trackID)
+}
+
+// Waits for the subscription result of a given track that matches the condition and returns it.
+func (c *Client) WaitForSubscriptionResult(
+	trackID string,
+	timeout time.Duration,
+	matches func(conference.FocusCallTrackSubscriptionResultEventContent) bool,
+) conference.FocusCallTrackSubscriptionResultEventContent {
+	c.harness.t.Helper()
+
+	var found conference.FocusCallTrackSubscriptionResultEventContent
+	Eventually(c.harness.t, timeout, func() bool {
+		for _, result := range c.SubscriptionResults() {
+			if result.TrackID == trackID && matches(result) {
+				found = result
+				return true
+			}
+		}
+
+		return false
+	}, "%s did not get the expected subscription result for %s: %+v", c.DeviceID, trackID, c.SubscriptionResults())
+
+	return found
+}
+
+// Returns all subscription results that the client received so far.
+func (c *Client) SubscriptionResults() []conference.FocusCallTrackSubscriptionResultEventContent {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]conference.FocusCallTrackSubscriptionResultEventContent{}, c.results...)
+}
+
+// Returns the results of the published tracks that the SFU rejected so far.
+func (c *Client) PublishResults() []conference.FocusCallTrackPublishResultEventContent {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]conference.FocusCallTrackPublishResultEventContent{}, c.rejected...)
+}
+
+// Returns the track that the client receives from the SFU, nil if there is no such track (yet).
+func (c *Client) ReceivedTrack(trackID string) *ReceivedTrack {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.received[trackID]
+}
+
+// Returns the reason of the hangup if the SFU hung up on the client.
+func (c *Client) HangupReason() (event.CallHangupReason, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.hangup == nil {
+		return "", false
+	}
+
+	return *c.hangup, true
+}
+
+func (c *Client) publishedTrack(trackID string) *publishedTrack {
+	c.harness.t.Helper()
+
+	for _, track := range c.published {
+		if track.spec.ID == trackID {
+			return track
+		}
+	}
+
+	c.harness.t.Fatalf("%s does not publish %s", c.DeviceID, trackID)
+	return nil
+}
+
+// Handles the messages that the SFU sends over Matrix.
+func (c *Client) processMatrixMessage(msg interface{}) {
+	switch msg := msg.(type) {
+	case signaling.SdpAnswer:
+		answer := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: msg.SDP}
+		if err := c.peerConnection.SetRemoteDescription(answer); err != nil {
+			c.setError(fmt.Errorf("failed to set answer: %w", err))
+		}
+
+	case signaling.IceCandidates:
+		for _, candidate := range msg.Candidates {
+			mid, index := candidate.SDPMID, uint16(candidate.SDPMLineIndex)
+			if err := c.peerConnection.AddICECandidate(webrtc.ICECandidateInit{
+				Candidate:     candidate.Candidate,
+				SDPMid:        &mid,
+				SDPMLineIndex: &index,
+			}); err != nil {
+				c.setError(fmt.Errorf("failed to add ICE candidate: %w", err))
+			}
+		}
+
+	case signaling.Hangup:
+		c.mutex.Lock()
+		reason := msg.Reason
+		c.hangup = &reason
+		c.mutex.Unlock()
+	}
+}
+
+// Handles the messages that the SFU sends over the data channel.
+func (c *Client) onDataChannelMessage(msg webrtc.DataChannelMessage) {
+	var focusEvent struct {
+		Type    string          `json:"type"`
+		Content json.RawMessage `json:"content"`
+	}
+
+	if err := json.Unmarshal(msg.Data, &focusEvent); err != nil {
+		c.setError(fmt.Errorf("invalid data channel message: %w", err))
+		return
+	}
+
+	switch focusEvent.Type {
+	case event.FocusCallPing.Type:
+		c.sendOverDataChannel(event.FocusCallPong, struct{}{})
+
+	case event.FocusCallSDPStreamMetadataChanged.Type:
+		var content event.FocusCallSDPStreamMetadataChangedEventContent
+		if err := json.Unmarshal(focusEvent.Content, &content); err != nil {
+			c.setError(fmt.Errorf("invalid metadata: %w", err))
+			return
+		}
+
+		c.setMetadata(content.SDPStreamMetadata)
+
+	case event.FocusCallNegotiate.Type:
+		var content event.FocusCallNegotiateEventContent
+		if err := json.Unmarshal(focusEvent.Content, &content); err != nil {
+			c.setError(fmt.Errorf("invalid negotiation: %w", err))
+			return
+		}
+
+		c.setMetadata(content.SDPStreamMetadata)
+		if content.Description.Type == event.CallDataTypeOffer {
+			c.answer(content.Description.SDP)
+		}
+
+	case conference.FocusCallTrackSubscriptionResult.Type:
+		var content conference.FocusCallTrackSubscriptionResultEventContent
+		if err := json.Unmarshal(focusEvent.Content, &content); err != nil {
+			c.setError(fmt.Errorf("invalid subscription result: %w", err))
+			return
+		}
+
+		c.mutex.Lock()
+		c.results = append(c.results, content)
+		c.mutex.Unlock()
+
+	case conference.FocusCallTrackPublishResult.Type:
+		var content conference.FocusCallTrackPublishResultEventContent
+		if err := json.Unmarshal(focusEvent.Content, &content); err != nil {
+			c.setError(fmt.Errorf("invalid publish result: %w", err))
+			return
+		}
+
+		c.mutex.Lock()
+		c.rejected = append(c.rejected, content)
+		c.mutex.Unlock()
+	}
+}
+
+// Answers the renegotiation offer of the SFU.
+func (c *Client) answer(sdp string) {
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
+	if err := c.peerConnection.SetRemoteDescription(offer); err != nil {
+		c.setError(fmt.Errorf("failed to set offer: %w", err))
+		return
+	}
+
+	answer, err := c.peerConnection.CreateAnswer(nil)
+	if err != nil {
+		c.setError(fmt.Errorf("failed to create answer: %w", err))
+		return
+	}
+
+	if err := c.peerConnection.SetLocalDescription(answer); err != nil {
+		c.setError(fmt.Errorf("failed to set answer: %w", err))
+		return
+	}
+
+	c.sendOverDataChannel(event.FocusCallNegotiate, event.FocusCallNegotiateEventContent{
+		Description: event.CallData{
+			Type: event.CallDataTypeAnswer,
+			SDP:  answer.SDP,
+		},
+		SDPStreamMetadata: c.streamMetadata(),
+	})
+}
+
+// Counts the packets of a track that the SFU forwards to the client.
+func (c *Client) onTrack(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+	track := &ReceivedTrack{kind: remote.Kind()}
+
+	c.mutex.Lock()
+	c.received[remote.ID()] = track
+	c.mutex.Unlock()
+
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.setError(fmt.Errorf("failed to read %s: %w", remote.ID(), err))
+			}
+
+			return
+		}
+
+		track.mutex.Lock()
+		track.packets++
+		if track.kind == webrtc.RTPCodecTypeVideo {
+			vp8Packet := codecs.VP8Packet{}
+			if frame, err := vp8Packet.Unmarshal(packet.Payload); err == nil {
+				if layer, ok := frameLayer(frame); ok {
+					track.layer = layer
+				}
+			}
+		}
+		track.mutex.Unlock()
+	}
+}
+
+func (c *Client) sendOverDataChannel(eventType event.Type, content interface{}) {
+	message, err := json.Marshal(map[string]interface{}{
+		"type":    eventType.Type,
+		"content": content,
+	})
+	if err != nil {
+		c.setError(fmt.Errorf("failed to marshal %s: %w", eventType.Type, err))
+		return
+	}
+
+	if err := c.dataChannel.SendText(string(message)); err != nil {
+		c.setError(fmt.Errorf("failed to send %s: %w", eventType.Type, err))
+	}
+}
+
+func (c *Client) setMetadata(metadata event.CallSDPStreamMetadata) {
+	if metadata == nil {
+		metadata = make(event.CallSDPStreamMetadata)
+	}
+
+	c.mutex.Lock()
+	c.metadata = metadata
+	c.mutex.Unlock()
+}
+
+func (c *Client) setError(err error) {
+	c.mutex.Lock()
+	c.lastError = err
+	c.mutex.Unlock()
+}
+
+// Returns the latest error of the client, which is useful to explain why an expectation failed.
+func (c *Client) error() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.lastError
+}
+
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		_ = c.peerConnection.Close()
+	})
+}
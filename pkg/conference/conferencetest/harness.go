@@ -0,0 +1,291 @@
+// Package conferencetest runs a conference in-process against real WebRTC clients, so that the tests can
+// check the whole path of the media and the signaling: the clients join over a fake Matrix signaler,
+// connect over a virtual network, publish synthetic tracks and observe what the SFU sends them.
+package conferencetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
+	"github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/logging"
+	"github.com/pion/transport/vnet"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	conferenceID = "conference"
+	roomID       = id.RoomID("!room:example.org")
+	sfuDeviceID  = id.DeviceID("SFU")
+	sfuIP        = "10.0.0.1"
+)
+
+// How long the helpers wait for the expected events by default.
+const DefaultTimeout = 10 * time.Second
+
+// A conference along with the clients that take part in it.
+type Harness struct {
+	t      testing.TB
+	config conference.Config
+
+	router   *vnet.Router
+	factory  *webrtc_ext.PeerConnectionFactory
+	signaler *conference.ReplaySignaler
+
+	matrixEvents  chan conference.MatrixMessage
+	adminRequests chan conference.AdminRequest
+
+	mutex   sync.Mutex
+	clients map[id.DeviceID]*Client
+	nextIP  int
+	done    <-chan struct{}
+}
+
+// Creates a harness for a conference with a given configuration. The conference starts once the first
+// client joins. All resources are released when the test ends.
+func New(t testing.TB, config conference.Config) *Harness {
+	t.Helper()
+
+	router, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "10.0.0.0/24",
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	})
+	if err != nil {
+		t.Fatalf("failed to create the virtual network: %v", err)
+	}
+
+	sfuNet := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{sfuIP}})
+	if err := router.AddNet(sfuNet); err != nil {
+		t.Fatalf("failed to connect the SFU to the virtual network: %v", err)
+	}
+
+	if err := router.Start(); err != nil {
+		t.Fatalf("failed to start the virtual network: %v", err)
+	}
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{
+		EnableSimulcast: true,
+		VirtualNetwork:  sfuNet,
+	})
+	if err != nil {
+		t.Fatalf("failed to create the peer connection factory: %v", err)
+	}
+
+	if config.HeartbeatConfig.Timeout == 0 {
+		config.HeartbeatConfig = conference.Heartbeat{Timeout: 30, Interval: 5}
+	}
+
+	harness := &Harness{
+		t:             t,
+		config:        config,
+		router:        router,
+		factory:       factory,
+		signaler:      conference.NewReplaySignaler(sfuDeviceID),
+		matrixEvents:  make(chan conference.MatrixMessage),
+		adminRequests: make(chan conference.AdminRequest),
+		clients:       make(map[id.DeviceID]*Client),
+		nextIP:        2,
+	}
+	harness.signaler.OnSend = harness.deliver
+
+	t.Cleanup(harness.close)
+
+	return harness
+}
+
+// Connects a new client with a given set of tracks to the conference. Returns once the client is connected
+// and got the metadata of the conference over the data channel.
+func (h *Harness) Join(userID id.UserID, deviceID id.DeviceID, tracks ...TrackSpec) *Client {
+	h.t.Helper()
+
+	client := h.Invite(userID, deviceID, tracks...)
+	client.WaitForConnection(DefaultTimeout)
+	Eventually(h.t, DefaultTimeout, func() bool { return client.Metadata() != nil }, "no metadata for %s", deviceID)
+
+	return client
+}
+
+// Sends the invite of a new client with a given set of tracks to the conference. Unlike `Join`, it does
+// not wait for the client to be admitted.
+func (h *Harness) Invite(userID id.UserID, deviceID id.DeviceID, tracks ...TrackSpec) *Client {
+	h.t.Helper()
+
+	h.mutex.Lock()
+	ip := fmt.Sprintf("10.0.0.%d", h.nextIP)
+	h.nextIP++
+	h.mutex.Unlock()
+
+	clientNet := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{ip}})
+	if err := h.router.AddNet(clientNet); err != nil {
+		h.t.Fatalf("failed to connect %s to the virtual network: %v", deviceID, err)
+	}
+
+	client, err := newClient(h, userID, deviceID, clientNet, tracks)
+	if err != nil {
+		h.t.Fatalf("failed to create %s: %v", deviceID, err)
+	}
+
+	// The client must be known before the SFU answers.
+	h.mutex.Lock()
+	h.clients[deviceID] = client
+	started := h.done != nil
+	h.mutex.Unlock()
+
+	invite, err := client.invite()
+	if err != nil {
+		h.t.Fatalf("failed to create the invite of %s: %v", deviceID, err)
+	}
+
+	if started {
+		h.sendMatrixMessage(client, invite)
+	} else {
+		done, err := conference.StartConference(
+			conferenceID,
+			roomID,
+			h.config,
+			h.factory,
+			h.signaler,
+			h.matrixEvents,
+			h.adminRequests,
+			userID,
+			invite,
+			bandwidth.NewBudget(bandwidth.Config{}, func() uint64 { return 0 }),
+			nil,
+		)
+		if err != nil {
+			h.t.Fatalf("failed to start the conference: %v", err)
+		}
+
+		h.mutex.Lock()
+		h.done = done
+		h.mutex.Unlock()
+	}
+
+	return client
+}
+
+// Sets the function that the SFU gets the power levels of the room from, they are not available otherwise.
+// Must be called before the first client joins.
+func (h *Harness) SetPowerLevels(get func(id.RoomID) (*event.PowerLevelsEventContent, error)) {
+	h.signaler.OnGetPowerLevels = get
+}
+
+// Returns a channel that is closed once the conference ends.
+func (h *Harness) Done() <-chan struct{} {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.done
+}
+
+// Waits until the conference ends.
+func (h *Harness) WaitForEnd(timeout time.Duration) {
+	h.t.Helper()
+
+	select {
+	case <-h.Done():
+	case <-time.After(timeout):
+		h.t.Fatal("the conference did not end")
+	}
+}
+
+// Ends the conference and waits until it's over.
+func (h *Harness) End() {
+	h.t.Helper()
+
+	done := h.Done()
+	if done == nil {
+		return
+	}
+
+	select {
+	case h.adminRequests <- func(c *conference.Conference) { c.End(conference.CallHangupConferenceEnded) }:
+	case <-done:
+	}
+
+	h.WaitForEnd(DefaultTimeout)
+}
+
+// Returns the messages that the SFU sent over Matrix so far.
+func (h *Harness) SentMatrixMessages() []signaling.MatrixMessage {
+	return h.signaler.Sent()
+}
+
+func (h *Harness) sendMatrixMessage(client *Client, content interface{}) {
+	h.t.Helper()
+
+	msg := conference.MatrixMessage{
+		Sender:  participant.ID{UserID: client.UserID, DeviceID: client.DeviceID, CallID: client.callID},
+		Content: content,
+	}
+
+	select {
+	case h.matrixEvents <- msg:
+	case <-h.Done():
+		h.t.Fatalf("the conference ended before %T of %s was delivered", content, client.DeviceID)
+	case <-time.After(DefaultTimeout):
+		h.t.Fatalf("the conference did not take %T of %s", content, client.DeviceID)
+	}
+}
+
+// Delivers a message that the SFU sent over Matrix to the client.
+func (h *Harness) deliver(msg signaling.MatrixMessage) {
+	h.mutex.Lock()
+	client := h.clients[msg.Recipient.DeviceID]
+	h.mutex.Unlock()
+
+	if client == nil {
+		return
+	}
+
+	client.processMatrixMessage(msg.Message)
+}
+
+func (h *Harness) close() {
+	h.mutex.Lock()
+	done := h.done
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mutex.Unlock()
+
+	// The conference may still be running if the test failed halfway.
+	if done != nil {
+		select {
+		case h.adminRequests <- func(c *conference.Conference) { c.End(conference.CallHangupConferenceEnded) }:
+			<-done
+		case <-done:
+		case <-time.After(DefaultTimeout):
+		}
+	}
+
+	for _, client := range clients {
+		client.close()
+	}
+
+	if err := h.router.Stop(); err != nil {
+		h.t.Logf("failed to stop the virtual network: %v", err)
+	}
+}
+
+// Polls the condition until it's true or the timeout expires.
+func Eventually(t testing.TB, timeout time.Duration, condition func() bool, format string, args ...interface{}) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
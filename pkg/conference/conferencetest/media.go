@@ -0,0 +1,322 @@
+package conferencetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// Simulcast layers in the order of browsers, along with the resolution of their synthetic frames.
+const (
+	LayerLow    = "q"
+	LayerMedium = "h"
+	LayerHigh   = "f"
+)
+
+var layerResolutions = map[string][2]int{
+	LayerLow:    {320, 180},
+	LayerMedium: {640, 360},
+	LayerHigh:   {1280, 720},
+	"":          {640, 360},
+}
+
+const (
+	audioFrameDuration = 20 * time.Millisecond
+	videoFrameDuration = 50 * time.Millisecond
+	// The publishers send a key frame every so many frames, so that the subscribers don't need to wait long.
+	keyFrameInterval = 10
+	// 90 kHz clock of the video divided by 20 frames per second.
+	videoTimestampIncrement = 4500
+)
+
+// A track that a client publishes.
+type TrackSpec struct {
+	ID   string
+	Kind webrtc.RTPCodecType
+	// Simulcast layers of the video track, none for a track without simulcast.
+	RIDs []string
+}
+
+// An Opus track.
+func AudioTrack(id string) TrackSpec {
+	return TrackSpec{ID: id, Kind: webrtc.RTPCodecTypeAudio}
+}
+
+// A VP8 track, with simulcast if the layers (e.g. `LayerLow`, `LayerHigh`) are given.
+func VideoTrack(id string, rids ...string) TrackSpec {
+	return TrackSpec{ID: id, Kind: webrtc.RTPCodecTypeVideo, RIDs: rids}
+}
+
+// The resolution of the best layer of the video track.
+func (s TrackSpec) maxResolution() (int, int) {
+	if s.Kind != webrtc.RTPCodecTypeVideo {
+		return 0, 0
+	}
+
+	var best [2]int
+	for _, rid := range s.layers() {
+		if resolution := layerResolutions[rid]; resolution[0] > best[0] {
+			best = resolution
+		}
+	}
+
+	return best[0], best[1]
+}
+
+func (s TrackSpec) layers() []string {
+	if len(s.RIDs) == 0 {
+		return []string{""}
+	}
+
+	return s.RIDs
+}
+
+// The synthetic media of a published track.
+type publishedTrack struct {
+	spec   TrackSpec
+	sender *webrtc.RTPSender
+	audio  *webrtc.TrackLocalStaticSample
+	video  map[string]*webrtc.TrackLocalStaticRTP
+
+	mutex  sync.Mutex
+	paused map[string]bool
+}
+
+func publishTrack(peerConnection *webrtc.PeerConnection, streamID string, spec TrackSpec) (*publishedTrack, error) {
+	track := &publishedTrack{spec: spec, paused: make(map[string]bool)}
+
+	if spec.Kind == webrtc.RTPCodecTypeAudio {
+		audio, err := webrtc.NewTrackLocalStaticSample(
+			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+			spec.ID,
+			streamID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", spec.ID, err)
+		}
+
+		track.audio = audio
+		if track.sender, err = peerConnection.AddTrack(audio); err != nil {
+			return nil, fmt.Errorf("failed to add %s: %w", spec.ID, err)
+		}
+
+		return track, nil
+	}
+
+	track.video = make(map[string]*webrtc.TrackLocalStaticRTP)
+	for _, rid := range spec.layers() {
+		var options []func(*webrtc.TrackLocalStaticRTP)
+		if rid != "" {
+			options = append(options, webrtc.WithRTPStreamID(rid))
+		}
+
+		video, err := webrtc.NewTrackLocalStaticRTP(
+			webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+			spec.ID,
+			streamID,
+			options...,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", spec.ID, err)
+		}
+
+		track.video[rid] = video
+		if track.sender == nil {
+			track.sender, err = peerConnection.AddTrack(video)
+		} else {
+			err = track.sender.AddEncoding(video)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s (%q): %w", spec.ID, rid, err)
+		}
+	}
+
+	return track, nil
+}
+
+// Starts sending the media until the channel is closed. Must be called once the connection is established.
+func (t *publishedTrack) start(peerConnection *webrtc.PeerConnection, stop <-chan struct{}) error {
+	// Read the RTCP, so that the interceptors (e.g. NACK responder) process it.
+	go func() {
+		for {
+			if _, _, err := t.sender.ReadRTCP(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if t.audio != nil {
+		go t.sendAudio(stop)
+		return nil
+	}
+
+	// Pion does not set the header extensions that identify the simulcast layers, so we do it ourselves.
+	var mid string
+	for _, transceiver := range peerConnection.GetTransceivers() {
+		if transceiver.Sender() == t.sender {
+			mid = transceiver.Mid()
+		}
+	}
+
+	extensions := make(map[string]int)
+	for _, extension := range t.sender.GetParameters().HeaderExtensions {
+		extensions[extension.URI] = extension.ID
+	}
+
+	midID := extensions["urn:ietf:params:rtp-hdrext:sdes:mid"]
+	ridID := extensions["urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id"]
+	if len(t.spec.RIDs) != 0 && (mid == "" || midID == 0 || ridID == 0) {
+		return errors.New("simulcast header extensions are not negotiated")
+	}
+
+	for rid, video := range t.video {
+		go t.sendVideo(video, rid, mid, midID, ridID, stop)
+	}
+
+	return nil
+}
+
+func (t *publishedTrack) sendAudio(stop <-chan struct{}) {
+	ticker := time.NewTicker(audioFrameDuration)
+	defer ticker.Stop()
+
+	// A silent Opus frame.
+	sample := media.Sample{Data: []byte{0xf8, 0xff, 0xfe}, Duration: audioFrameDuration}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if t.isPaused("") {
+				continue
+			}
+
+			if err := t.audio.WriteSample(sample); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (t *publishedTrack) sendVideo(
+	video *webrtc.TrackLocalStaticRTP,
+	rid, mid string,
+	midID, ridID int,
+	stop <-chan struct{},
+) {
+	ticker := time.NewTicker(videoFrameDuration)
+	defer ticker.Stop()
+
+	var (
+		frame     int
+		timestamp uint32
+		sequence  uint16
+	)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		timestamp += videoTimestampIncrement
+		if t.isPaused(rid) {
+			// The first frame after the pause is a key frame, like after a real stall.
+			frame = 0
+			continue
+		}
+
+		packet := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         true,
+				SequenceNumber: sequence,
+				Timestamp:      timestamp,
+			},
+			Payload: vp8Frame(rid, frame%keyFrameInterval == 0),
+		}
+
+		if rid != "" {
+			if err := packet.Header.SetExtension(uint8(midID), []byte(mid)); err != nil {
+				return
+			}
+
+			if err := packet.Header.SetExtension(uint8(ridID), []byte(rid)); err != nil {
+				return
+			}
+		}
+
+		if err := video.WriteRTP(packet); err != nil {
+			return
+		}
+
+		frame++
+		sequence++
+	}
+}
+
+// Pauses or resumes a given layer, or all layers if `rid` is empty.
+func (t *publishedTrack) setPaused(rid string, paused bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if rid == "" {
+		for _, layer := range append(t.spec.layers(), "") {
+			t.paused[layer] = paused
+		}
+
+		return
+	}
+
+	t.paused[rid] = paused
+}
+
+func (t *publishedTrack) isPaused(rid string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.paused[rid]
+}
+
+// The offset of the layer in the synthetic VP8 frames (right after the resolution of the key frame).
+const frameLayerOffset = 10
+
+// Creates a single-packet VP8 frame (RFC 7741) that the SFU recognizes as a key frame with the resolution
+// of the layer (RFC 6386, section 9.1). The frame carries the layer, so that the subscriber can tell
+// which layer it gets. The frame would not decode, but the SFU does not need to decode it.
+func vp8Frame(rid string, keyFrame bool) []byte {
+	// The payload descriptor with the start of the partition, then the frame tag, which is
+	// followed by the start code and the resolution for the key frames.
+	payload := []byte{0x10, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if keyFrame {
+		resolution := layerResolutions[rid]
+		payload[1] = 0x10
+		copy(payload[4:], []byte{0x9d, 0x01, 0x2a})
+		payload[7], payload[8] = byte(resolution[0]), byte(resolution[0]>>8)
+		payload[9], payload[10] = byte(resolution[1]), byte(resolution[1]>>8)
+	}
+
+	return append(append(payload, byte(len(rid))), rid...)
+}
+
+// Extracts the layer from the synthetic VP8 frame (without the payload descriptor).
+func frameLayer(frame []byte) (string, bool) {
+	if len(frame) <= frameLayerOffset {
+		return "", false
+	}
+
+	length := int(frame[frameLayerOffset])
+	if len(frame) < frameLayerOffset+1+length {
+		return "", false
+	}
+
+	return string(frame[frameLayerOffset+1 : frameLayerOffset+1+length]), true
+}
@@ -0,0 +1,299 @@
+package conferencetest //nolint:testpackage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type subscriptionResult = conference.FocusCallTrackSubscriptionResultEventContent
+
+func TestJoin(t *testing.T) {
+	harness := New(t, conference.Config{})
+
+	alice := harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"), VideoTrack("alice-video"))
+	bob := harness.Join("@bob:example.org", "BOB")
+
+	Eventually(t, DefaultTimeout, func() bool {
+		return bob.HasTrack("alice-audio") && bob.HasTrack("alice-video")
+	}, "bob does not see the tracks of alice: %+v", bob.Metadata())
+
+	if alice.HasTrack("alice-audio") {
+		t.Error("alice must not see her own tracks")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	harness := New(t, conference.Config{})
+
+	alice := harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"), VideoTrack("alice-video"))
+	bob := harness.Join("@bob:example.org", "BOB")
+	Eventually(t, DefaultTimeout, func() bool { return bob.HasTrack("alice-video") }, "no tracks of alice")
+
+	bob.Subscribe(alice, "alice-audio", 0, 0)
+	bob.Subscribe(alice, "alice-video", 640, 360)
+
+	for _, trackID := range []string{"alice-audio", "alice-video"} {
+		bob.WaitForSubscriptionResult(trackID, DefaultTimeout, func(result subscriptionResult) bool {
+			return result.Result == conference.SubscriptionResultSubscribed
+		})
+		bob.WaitForPackets(trackID, 20, DefaultTimeout)
+	}
+}
+
+func TestSubscriptionRules(t *testing.T) {
+	harness := New(t, conference.Config{})
+
+	harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"), VideoTrack("alice-video"))
+	bob := harness.Join("@bob:example.org", "BOB")
+	Eventually(t, DefaultTimeout, func() bool { return bob.HasTrack("alice-video") }, "no tracks of alice")
+
+	// The rule applies to the existing tracks.
+	bob.SetSubscriptionRules(conference.SubscriptionRuleDescription{Kind: "audio"})
+	bob.WaitForSubscriptionResult("alice-audio", DefaultTimeout, func(result subscriptionResult) bool {
+		return result.Result == conference.SubscriptionResultSubscribed
+	})
+	bob.WaitForPackets("alice-audio", 20, DefaultTimeout)
+
+	// And to the tracks published later.
+	harness.Join("@carol:example.org", "CAROL", AudioTrack("carol-audio"))
+	bob.WaitForSubscriptionResult("carol-audio", DefaultTimeout, func(result subscriptionResult) bool {
+		return result.Result == conference.SubscriptionResultSubscribed
+	})
+
+	for _, result := range bob.SubscriptionResults() {
+		if result.TrackID == "alice-video" {
+			t.Errorf("the video does not match the rule: %+v", result)
+		}
+	}
+}
+
+func TestSubscriptionRulesLimits(t *testing.T) {
+	harness := New(t, conference.Config{Limits: conference.Limits{MaxSubscriptions: 1}})
+
+	alice := harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"))
+	bob := harness.Join("@bob:example.org", "BOB", AudioTrack("bob-audio"))
+	carol := harness.Join("@carol:example.org", "CAROL")
+	Eventually(t, DefaultTimeout, func() bool {
+		return carol.HasTrack("alice-audio") && carol.HasTrack("bob-audio")
+	}, "no tracks of alice and bob")
+
+	// The rules are subject to the same limits as the explicit requests.
+	carol.SetSubscriptionRules(conference.SubscriptionRuleDescription{})
+	Eventually(t, DefaultTimeout, func() bool { return len(carol.SubscriptionResults()) == 2 }, "no results")
+
+	results := make(map[string]string)
+	for _, result := range carol.SubscriptionResults() {
+		results[result.Result] = result.Reason
+	}
+
+	reason, failed := results[conference.SubscriptionResultFailed]
+	if _, subscribed := results[conference.SubscriptionResultSubscribed]; !subscribed || !failed {
+		t.Fatalf("expected one subscription and one failure, got %+v", carol.SubscriptionResults())
+	}
+
+	if reason != conference.SubscriptionReasonLimitExceeded {
+		t.Errorf("unexpected reason of the failure: %s", reason)
+	}
+
+	// The rules are matched again on the metadata changes, but the same failure is not sent again.
+	for i := 0; i < 3; i++ {
+		alice.SendMetadata()
+		bob.SendMetadata()
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if results := carol.SubscriptionResults(); len(results) != 2 {
+		t.Errorf("expected no further results, got %+v", results)
+	}
+}
+
+func TestPublishedTracksLimit(t *testing.T) {
+	harness := New(t, conference.Config{Limits: conference.Limits{MaxPublishedTracks: 1}})
+
+	alice := harness.Join(
+		"@alice:example.org",
+		"ALICE",
+		VideoTrack("alice-camera", LayerLow, LayerHigh),
+		VideoTrack("alice-screen", LayerLow, LayerHigh),
+	)
+	bob := harness.Join("@bob:example.org", "BOB")
+	Eventually(t, DefaultTimeout, func() bool {
+		return bob.HasTrack("alice-camera") || bob.HasTrack("alice-screen")
+	}, "no tracks of alice")
+
+	accepted, rejected := "alice-camera", "alice-screen"
+	if !bob.HasTrack(accepted) {
+		accepted, rejected = rejected, accepted
+	}
+
+	// Once the high layer of the accepted track is forwarded, all layers of both tracks have arrived.
+	bob.Subscribe(alice, accepted, 1280, 720)
+	bob.WaitForLayer(accepted, LayerHigh, DefaultTimeout)
+
+	// The publisher is told about the rejection once, not once per layer.
+	results := alice.PublishResults()
+	if len(results) != 1 || results[0].TrackID != rejected || results[0].Reason != conference.PublishReasonLimitExceeded {
+		t.Fatalf("expected a single rejection of %s, got %+v", rejected, results)
+	}
+
+	if bob.HasTrack(rejected) {
+		t.Errorf("the rejected track must not be announced")
+	}
+}
+
+func TestLayerSwitch(t *testing.T) {
+	harness := New(t, conference.Config{})
+
+	alice := harness.Join("@alice:example.org", "ALICE", VideoTrack("alice-video", LayerLow, LayerMedium, LayerHigh))
+	bob := harness.Join("@bob:example.org", "BOB")
+	Eventually(t, DefaultTimeout, func() bool { return bob.HasTrack("alice-video") }, "no tracks of alice")
+
+	bob.Subscribe(alice, "alice-video", 320, 180)
+	bob.WaitForLayer("alice-video", LayerLow, DefaultTimeout)
+
+	bob.Subscribe(alice, "alice-video", 1280, 720)
+	bob.WaitForLayer("alice-video", LayerHigh, DefaultTimeout)
+}
+
+func TestStall(t *testing.T) {
+	harness := New(t, conference.Config{})
+
+	alice := harness.Join("@alice:example.org", "ALICE", VideoTrack("alice-video", LayerLow, LayerHigh))
+	bob := harness.Join("@bob:example.org", "BOB")
+	Eventually(t, DefaultTimeout, func() bool { return bob.HasTrack("alice-video") }, "no tracks of alice")
+
+	bob.Subscribe(alice, "alice-video", 1280, 720)
+	bob.WaitForLayer("alice-video", LayerHigh, DefaultTimeout)
+
+	// The SFU switches to the layer that still works and back once the stalled one recovers.
+	alice.Pause("alice-video", LayerHigh)
+	bob.WaitForSubscriptionResult("alice-video", DefaultTimeout, func(result subscriptionResult) bool {
+		return result.Reason == conference.SubscriptionReasonPublisherStalled && result.RID == LayerLow
+	})
+	bob.WaitForLayer("alice-video", LayerLow, DefaultTimeout)
+
+	alice.Resume("alice-video", LayerHigh)
+	bob.WaitForSubscriptionResult("alice-video", DefaultTimeout, func(result subscriptionResult) bool {
+		return result.Reason == conference.SubscriptionReasonPublisherRecovered && result.RID == LayerHigh
+	})
+	bob.WaitForLayer("alice-video", LayerHigh, DefaultTimeout)
+}
+
+func TestHangup(t *testing.T) {
+	harness := New(t, conference.Config{})
+
+	alice := harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"))
+	bob := harness.Join("@bob:example.org", "BOB", AudioTrack("bob-audio"))
+	Eventually(t, DefaultTimeout, func() bool { return alice.HasTrack("bob-audio") }, "no tracks of bob")
+
+	// The tracks of the participant disappear when it leaves.
+	bob.Hangup()
+	Eventually(t, DefaultTimeout, func() bool { return !alice.HasTrack("bob-audio") }, "bob's tracks are still there")
+
+	// The conference ends with the last participant.
+	alice.Hangup()
+	harness.WaitForEnd(5 * time.Second)
+}
+
+func TestViewer(t *testing.T) {
+	harness := New(t, conference.Config{Roles: conference.Roles{Viewers: []id.UserID{"@bob:example.org"}}})
+
+	alice := harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"))
+	bob := harness.Join("@bob:example.org", "BOB", AudioTrack("bob-audio"))
+
+	// The viewer gets the tracks of the others, but its own media is rejected.
+	Eventually(t, DefaultTimeout, func() bool { return bob.HasTrack("alice-audio") }, "no tracks of alice")
+	time.Sleep(time.Second)
+
+	if alice.HasTrack("bob-audio") {
+		t.Error("the viewer must not publish")
+	}
+
+	// The SFU does not want to receive anything from the viewer.
+	answer := bob.peerConnection.RemoteDescription().SDP
+	if strings.Contains(answer, "a=sendrecv") || strings.Contains(answer, "a=recvonly") {
+		t.Errorf("the answer to the viewer accepts its media:\n%s", answer)
+	}
+}
+
+func TestPowerLevelsUnavailable(t *testing.T) {
+	harness := New(t, conference.Config{Roles: conference.Roles{
+		PowerLevels: &conference.PowerLevelRoles{Publisher: 0, Moderator: 50},
+	}})
+
+	// The participants wait for the power levels and get the default role once they are known to be missing.
+	harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"))
+	bob := harness.Join("@bob:example.org", "BOB")
+	Eventually(t, DefaultTimeout, func() bool { return bob.HasTrack("alice-audio") }, "no tracks of alice")
+}
+
+func TestEndRejectsInvitesWaitingForPowerLevels(t *testing.T) {
+	harness := New(t, conference.Config{Roles: conference.Roles{
+		Publishers:  []id.UserID{"@alice:example.org"},
+		PowerLevels: &conference.PowerLevelRoles{Publisher: 0, Moderator: 50},
+	}})
+
+	// The power levels are not known until the test ends.
+	fetching, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	harness.SetPowerLevels(func(id.RoomID) (*event.PowerLevelsEventContent, error) {
+		fetching <- struct{}{}
+		<-release
+		return &event.PowerLevelsEventContent{}, nil
+	})
+
+	// The role of alice is configured, the one of bob depends on the power levels.
+	harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"))
+	bob := harness.Invite("@bob:example.org", "BOB")
+
+	select {
+	case <-fetching:
+	case <-time.After(DefaultTimeout):
+		t.Fatal("the power levels were never fetched")
+	}
+
+	// The conference ends although bob is still waiting, and bob is told so.
+	harness.End()
+
+	reason, hungUp := bob.HangupReason()
+	if !hungUp || reason != conference.CallHangupConferenceEnded {
+		t.Errorf("expected bob to be hung up with %s, got %q (%v)", conference.CallHangupConferenceEnded, reason, hungUp)
+	}
+}
+
+func TestAutoSubscribe(t *testing.T) {
+	// The mode is enabled for the room of the conference only.
+	harness := New(t, conference.Config{AutoSubscribe: conference.AutoSubscribe{Rooms: []id.RoomID{roomID}}})
+
+	harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"), VideoTrack("alice-video"))
+	bob := harness.Join("@bob:example.org", "BOB")
+
+	// Bob gets the tracks without subscribing to them.
+	bob.WaitForPackets("alice-audio", 20, DefaultTimeout)
+	bob.WaitForPackets("alice-video", 20, DefaultTimeout)
+}
+
+func TestAutoSubscribeUsers(t *testing.T) {
+	harness := New(t, conference.Config{AutoSubscribe: conference.AutoSubscribe{
+		Users: []id.UserID{"@recorder:example.org"},
+		Rooms: []id.RoomID{"!other:example.org"},
+	}})
+
+	harness.Join("@alice:example.org", "ALICE", AudioTrack("alice-audio"))
+	recorder := harness.Join("@recorder:example.org", "RECORDER")
+	bob := harness.Join("@bob:example.org", "BOB")
+	Eventually(t, DefaultTimeout, func() bool { return bob.HasTrack("alice-audio") }, "no tracks of alice")
+
+	// Only the listed user is subscribed.
+	recorder.WaitForPackets("alice-audio", 20, DefaultTimeout)
+
+	time.Sleep(500 * time.Millisecond)
+	if bob.ReceivedTrack("alice-audio") != nil {
+		t.Error("expected bob not to be subscribed")
+	}
+}
@@ -1,5 +1,10 @@
 package conference
 
+import (
+	"github.com/matrix-org/waterfall/pkg/audit"
+	"maunium.net/go/mautrix/id"
+)
+
 type Heartbeat struct {
 	// Timeout for WebRTC connections. If the client doesn't respond to an
 	// `m.call.ping` with an `m.call.pong` for this amount of time, the
@@ -13,4 +18,68 @@ type Heartbeat struct {
 // Configuration for the group conferences (calls).
 type Config struct {
 	HeartbeatConfig Heartbeat `yaml:"heartbeat"`
+	// Minimal interval between two key frame requests sent to the same publisher (simulcast layer).
+	// All requests within this interval are coalesced into a single one. (in milliseconds, 500 if not set)
+	KeyFrameRequestInterval int `yaml:"keyFrameRequestInterval"`
+	// Users that are allowed to mute or kick other participants.
+	Moderators []id.UserID `yaml:"moderators"`
+	// Policy that assigns the roles to the participants when they join.
+	Roles Roles `yaml:"roles"`
+	// Subscribes the thin clients to all tracks automatically.
+	AutoSubscribe AutoSubscribe `yaml:"autoSubscribe"`
+	// Limits of the resources of the SFU, the conferences and the participants.
+	Limits Limits `yaml:"limits"`
+	// Audit log of the conferences.
+	Audit audit.Config `yaml:"audit"`
+	// Records the inbound signaling of the conferences for the replay.
+	Capture CaptureConfig `yaml:"capture"`
+}
+
+// Admission control, zero means no limit.
+type Limits struct {
+	// Maximum number of conferences that the SFU runs at the same time.
+	MaxConferences int `yaml:"maxConferences"`
+	// Maximum number of participants in a single conference.
+	MaxParticipants int `yaml:"maxParticipants"`
+	// Maximum number of tracks that a single participant may publish.
+	MaxPublishedTracks int `yaml:"maxPublishedTracks"`
+	// Maximum number of tracks that a single participant may be subscribed to.
+	MaxSubscriptions int `yaml:"maxSubscriptions"`
+}
+
+// The roles of the users who are not listed in `Moderators` are taken from the lists below, then from
+// the power levels of the Matrix room (if configured and the membership of the participants is checked in
+// the room), and finally from the default.
+type Roles struct {
+	// Role of the participants that nothing else applies to: "publisher" (if not set) or "viewer".
+	Default string `yaml:"default"`
+	// Users that are allowed to publish tracks.
+	Publishers []id.UserID `yaml:"publishers"`
+	// Users that are only allowed to receive tracks.
+	Viewers []id.UserID `yaml:"viewers"`
+	// Assigns the roles according to the power levels of the users in the room, if set. The room is only
+	// known if `requireCallMembership` is set, the room named by the invite is not trusted otherwise.
+	PowerLevels *PowerLevelRoles `yaml:"powerLevels"`
+}
+
+// Minimal power levels in the room for the roles, the users below the publisher level are viewers.
+type PowerLevelRoles struct {
+	Publisher int `yaml:"publisher"`
+	Moderator int `yaml:"moderator"`
+}
+
+// Configuration of the auto-subscribe mode for the clients that don't implement the track subscription
+// protocol (e.g. SIP bridges or recorders). Such participants are subscribed to all audio tracks and
+// to all video tracks at the default resolution as soon as the tracks are published.
+type AutoSubscribe struct {
+	// Enables the auto-subscribe mode for all participants.
+	Enabled bool `yaml:"enabled"`
+	// Users that get the auto-subscribe mode even if it's not enabled for all participants.
+	Users []id.UserID `yaml:"users"`
+	// Rooms whose conferences subscribe all participants automatically. The room is only known if
+	// `requireCallMembership` is set.
+	Rooms []id.RoomID `yaml:"rooms"`
+	// The default resolution of the video, the lowest simulcast layer is used if not set.
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
 }
@@ -0,0 +1,97 @@
+package conference
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// The hangup reason that the participants get when the conference has no room for them.
+	CallHangupConferenceFull event.CallHangupReason = "conference_full"
+	// The hangup reason that the participants get when the SFU can't start one more conference.
+	CallHangupServerFull event.CallHangupReason = "server_full"
+)
+
+var ErrConferenceFull = errors.New("conference is full")
+
+// Sent over the data channel to a publisher whose track is not forwarded to anyone, so that the client
+// could stop sending it (and show it to the user).
+var FocusCallTrackPublishResult = event.Type{Type: "m.call.track_publish_result", Class: event.FocusEventType}
+
+type FocusCallTrackPublishResultEventContent struct {
+	StreamID string `json:"stream_id"`
+	TrackID  string `json:"track_id"`
+	// Always `PublishResultRejected` for now.
+	Result string `json:"result"`
+	// Why the track was rejected, one of the `PublishReason*` values.
+	Reason string `json:"reason"`
+}
+
+const (
+	PublishResultRejected      = "rejected"
+	PublishReasonLimitExceeded = "limit_exceeded"
+)
+
+// Checks if one more participant may join the conference.
+func (c *Conference) checkParticipantsLimit() error {
+	if c.config.Limits.MaxParticipants == 0 {
+		return nil
+	}
+
+	participants := 0
+	c.tracker.ForEachParticipant(func(participant.ID, *participant.Participant) { participants++ })
+
+	if participants >= c.config.Limits.MaxParticipants {
+		metrics.AdmissionRejections.With("participants").Inc()
+		return fmt.Errorf("%w: %d participants", ErrConferenceFull, c.config.Limits.MaxParticipants)
+	}
+
+	return nil
+}
+
+// Informs the publisher that its track is rejected.
+func (c *Conference) rejectPublishedTrack(publisherID participant.ID, info webrtc_ext.TrackInfo, reason string) {
+	p := c.getParticipant(publisherID)
+	if p == nil {
+		return
+	}
+
+	resultEvent := event.Event{
+		Type: FocusCallTrackPublishResult,
+		Content: event.Content{
+			Parsed: FocusCallTrackPublishResultEventContent{
+				StreamID: info.StreamID,
+				TrackID:  info.TrackID,
+				Result:   PublishResultRejected,
+				Reason:   reason,
+			},
+		},
+	}
+
+	if err := p.SendOverDataChannel(resultEvent); err != nil {
+		p.Logger.WithError(err).Debug("Failed to send track publish result")
+	}
+}
+
+// Informs the participant that it could not join the conference.
+func (c *Conference) rejectParticipant(
+	participantID participant.ID,
+	sessionID id.SessionID,
+	reason event.CallHangupReason,
+) {
+	recipient := signaling.MatrixRecipient{
+		UserID:          participantID.UserID,
+		DeviceID:        participantID.DeviceID,
+		CallID:          participantID.CallID,
+		RemoteSessionID: sessionID,
+	}
+
+	c.matrixWorker.sendSignalingMessage(recipient, signaling.Hangup{Reason: reason})
+}
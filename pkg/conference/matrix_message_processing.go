@@ -5,6 +5,7 @@ import (
 
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/pion/webrtc/v3"
@@ -22,6 +23,13 @@ type MatrixMessage struct {
 
 // New participant tries to join the conference.
 func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.CallInviteEventContent) error {
+	// The role may depend on the power levels, which are fetched without holding up the conference.
+	if c.awaitPowerLevels(id.UserID) {
+		c.newLogger(id).Debug("Waiting for the power levels")
+		c.powerLevels.pending = append(c.powerLevels.pending, MatrixMessage{Sender: id, Content: inviteEvent})
+		return nil
+	}
+
 	logger := c.newLogger(id)
 	logger.Info("Incoming participant")
 	c.telemetry.AddEvent(
@@ -37,7 +45,7 @@ func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.Call
 		if participant.RemoteSessionID == inviteEvent.SenderSessionID {
 			c.logger.Errorf("Found existing participant with equal DeviceID and SessionID")
 		} else {
-			c.removeParticipant(id)
+			c.removeParticipant(id, CallHangupSessionReplaced)
 		}
 	}
 
@@ -54,9 +62,25 @@ func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.Call
 		}
 		sdpAnswer = answer
 	} else {
+		if err := c.checkParticipantsLimit(); err != nil {
+			logger.WithError(err).Warn("Rejecting participant")
+			c.rejectParticipant(id, inviteEvent.SenderSessionID, CallHangupConferenceFull)
+			return err
+		}
+
 		messageSink := channel.NewSink(id, c.peerMessages)
 
-		peerConnection, answer, err := peer.NewPeer(c.connectionFactory, inviteEvent.Offer.SDP, messageSink, logger)
+		// The viewers are receive-only, so their media is rejected right in the negotiation.
+		role := c.resolveRole(id.UserID)
+
+		peerConnection, answer, err := peer.NewPeer(
+			c.connectionFactory,
+			inviteEvent.Offer.SDP,
+			role.CanPublish(),
+			func() { metrics.WorkerDrops.With("rtx").Inc() },
+			messageSink,
+			logger,
+		)
 		if err != nil {
 			logger.WithError(err).Errorf("Failed to process SDP offer")
 			c.telemetry.AddError(err)
@@ -72,7 +96,7 @@ func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.Call
 			Interval:  time.Duration(c.config.HeartbeatConfig.Interval) * time.Second,
 			Timeout:   time.Duration(c.config.HeartbeatConfig.Timeout) * time.Second,
 			SendPing:  func() bool { return p.SendOverDataChannel(pingEvent) == nil },
-			OnTimeout: func() { messageSink.Send(peer.LeftTheCall{event.CallHangupKeepAliveTimeout}) },
+			OnTimeout: func() { messageSink.Send(peer.LeftTheCall{Reason: event.CallHangupKeepAliveTimeout}) },
 		}
 
 		participantTelemetry := c.telemetry.CreateChild(
@@ -88,6 +112,7 @@ func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.Call
 			RemoteSessionID: inviteEvent.SenderSessionID,
 			Pong:            heartbeat.Start(),
 			Telemetry:       participantTelemetry,
+			Role:            role,
 		}
 
 		c.tracker.AddParticipant(p)
@@ -95,7 +120,7 @@ func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.Call
 	}
 
 	// Update streams metadata.
-	c.updateMetadata(inviteEvent.SDPStreamMetadata)
+	c.updateMetadata(p, inviteEvent.SDPStreamMetadata)
 
 	// Send the answer back to the remote peer.
 	p.Logger.WithField("sdpAnswer", sdpAnswer.SDP).Debug("Sending SDP answer")
@@ -145,7 +170,7 @@ func (c *Conference) onSelectAnswer(id participant.ID, ev *event.CallSelectAnswe
 				"device_id": ev.SelectedPartyID,
 				"user_id":   id,
 			}).Errorf("Call was answered on a different device, kicking this peer")
-			c.removeParticipant(id)
+			c.removeParticipant(id, CallHangupAnsweredElsewhere)
 		}
 	}
 }
@@ -155,6 +180,11 @@ func (c *Conference) onHangup(id participant.ID, ev *event.CallHangupEventConten
 	if participant := c.getParticipant(id); participant != nil {
 		participant.Logger.WithField("reason", ev.Reason).Info("Received remote hangup")
 		participant.Telemetry.AddEvent("Received remote hangup", attribute.String("reason", string(ev.Reason)))
-		c.removeParticipant(id)
+
+		reason := ev.Reason
+		if reason == "" {
+			reason = event.CallHangupUserHangup
+		}
+		c.removeParticipant(id, reason)
 	}
 }
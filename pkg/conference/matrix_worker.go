@@ -1,8 +1,10 @@
 package conference
 
 import (
+	"errors"
 	"time"
 
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/worker"
 	"github.com/sirupsen/logrus"
@@ -21,6 +23,7 @@ func newMatrixWorker(handler signaling.MatrixSignaler) *matrixWorker {
 		OnTimeout:   func() {},
 		OnTask: func(msg signaling.MatrixMessage) {
 			if err := handler.SendMessage(msg); err != nil {
+				metrics.ToDeviceFailures.Inc()
 				logrus.Errorf("Failed to send matrix message: %v", err)
 			}
 		},
@@ -34,8 +37,20 @@ func newMatrixWorker(handler signaling.MatrixSignaler) *matrixWorker {
 	return matrixWorker
 }
 
+// How long to wait for the queued messages to be sent when the worker stops.
+const matrixWorkerFlushTimeout = 5 * time.Second
+
+// Stops the worker and waits until the queued messages are sent, so that the hangups that we sent
+// right before the conference ended are delivered. The wait is bounded, since the conference is
+// not considered done (and the router waits for it) until the worker is stopped.
 func (w *matrixWorker) stop() {
 	w.worker.Stop()
+
+	select {
+	case <-w.worker.Done():
+	case <-time.After(matrixWorkerFlushTimeout):
+		logrus.Warn("Timed out while sending the remaining matrix messages")
+	}
 }
 
 func (w *matrixWorker) sendSignalingMessage(recipient signaling.MatrixRecipient, content interface{}) {
@@ -45,6 +60,10 @@ func (w *matrixWorker) sendSignalingMessage(recipient signaling.MatrixRecipient,
 	}
 
 	if err := w.worker.Send(msg); err != nil {
+		if errors.Is(err, worker.ErrWorkerTooBusy) {
+			metrics.WorkerDrops.With("matrix").Inc()
+		}
+
 		logrus.Errorf("Really bad, dropping matrix message since the matrix queue is full! Home server down? %s", err)
 	}
 }
@@ -0,0 +1,68 @@
+package conference
+
+import (
+	"encoding/json"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Sent over the data channel by a moderator to mute (or unmute) the audio of a participant for
+// everyone. The SFU then stops forwarding the participant's audio and sends the same event to
+// all participants, so that they could reflect the change in the UI.
+var FocusCallMuteParticipant = event.Type{Type: "m.call.mute_participant", Class: event.FocusEventType}
+
+type FocusCallMuteParticipantEventContent struct {
+	// The user whose audio is muted.
+	UserID id.UserID `json:"user_id"`
+	// The device whose audio is muted, all devices of the user if empty.
+	DeviceID id.DeviceID `json:"device_id,omitempty"`
+	// Whether the audio is muted or unmuted.
+	Muted bool `json:"muted"`
+}
+
+func (c *Conference) processMuteParticipantMessage(p *participant.Participant, focusEvent event.Event) {
+	var msg FocusCallMuteParticipantEventContent
+	if err := json.Unmarshal(focusEvent.Content.VeryRaw, &msg); err != nil {
+		p.Logger.WithError(err).Error("Failed to unmarshal mute participant message")
+		return
+	}
+
+	if !p.Role.CanModerate() {
+		p.Logger.WithField("target", msg.UserID).Warn("Ignoring mute request from a non-moderator")
+		return
+	}
+
+	var found bool
+	c.tracker.ForEachParticipant(func(id participant.ID, _ *participant.Participant) {
+		if id.UserID != msg.UserID || (msg.DeviceID != "" && id.DeviceID != msg.DeviceID) {
+			return
+		}
+
+		found = true
+		if err := c.tracker.SetAudioMutedByModerator(id, msg.Muted); err != nil {
+			c.logger.WithError(err).Errorf("Failed to mute %s", id)
+		}
+	})
+
+	if !found {
+		p.Logger.WithField("target", msg.UserID).Warn("Participant to mute not found")
+		return
+	}
+
+	c.logger.WithFields(logrus.Fields{
+		"moderator": p.ID.UserID,
+		"target":    msg.UserID,
+		"muted":     msg.Muted,
+	}).Info("Participant's audio muted by moderator")
+
+	// Let everyone know, including the muted participant.
+	muteEvent := event.Event{Type: FocusCallMuteParticipant, Content: event.Content{Parsed: msg}}
+	c.tracker.ForEachParticipant(func(id participant.ID, participant *participant.Participant) {
+		if err := participant.SendOverDataChannel(muteEvent); err != nil {
+			c.logger.WithError(err).Errorf("Failed to send mute event to %s", id)
+		}
+	})
+}
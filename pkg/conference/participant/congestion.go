@@ -0,0 +1,105 @@
+package participant
+
+import (
+	"sort"
+
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// The packet loss above which we consider the subscriber's downlink congested.
+	congestionPacketLoss = 0.1
+	// The packet loss below which we consider the subscriber's downlink stable.
+	stablePacketLoss = 0.02
+	// The number of consecutive stable checks before we try to restore a degraded subscription.
+	stableChecksBeforeRestore = 5
+)
+
+// Adapts the video subscriptions of each participant to the packet loss on its downlink. When the
+// downlink is congested, the subscription with the lowest priority is switched to a lower layer.
+// When the downlink has been stable for a while, the degraded subscription with the highest
+// priority is switched one layer up, unless the SFU is close to its egress bandwidth budget.
+// Should be called periodically.
+func (t *Tracker) AdaptSubscriptions() {
+	for participantID, participant := range t.participants {
+		t.adaptSubscriptionsOf(participantID, participant)
+	}
+
+	for _, published := range t.publishedTracks {
+		published.UpdateTemporalLayers()
+	}
+}
+
+func (t *Tracker) adaptSubscriptionsOf(participantID ID, participant *Participant) {
+	type candidate struct {
+		track   *track.PublishedTrack[ID]
+		quality track.SubscriptionQuality
+	}
+
+	candidates := []candidate{}
+	maxPacketLoss := 0.0
+
+	for _, published := range t.publishedTracks {
+		if published.Owner() == participantID || published.Info().Kind != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+
+		if quality, found := published.SubscriptionQuality(participantID); found {
+			candidates = append(candidates, candidate{published, quality})
+			if quality.PacketLoss > maxPacketLoss {
+				maxPacketLoss = quality.PacketLoss
+			}
+		}
+	}
+
+	switch {
+	case maxPacketLoss > congestionPacketLoss:
+		participant.stableDownlinkChecks = 0
+
+		// Degrade the least important subscription that still can be degraded.
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].quality.Priority < candidates[j].quality.Priority
+		})
+
+		for _, candidate := range candidates {
+			if candidate.track.DegradeSubscription(participantID) {
+				participant.Logger.WithField("packet_loss", maxPacketLoss).Info("Downlink congested")
+				break
+			}
+		}
+
+	case maxPacketLoss < stablePacketLoss:
+		participant.stableDownlinkChecks++
+		if participant.stableDownlinkChecks < stableChecksBeforeRestore {
+			return
+		}
+
+		// Don't add to the load of the SFU while it's close to its egress budget.
+		if t.budget.State() != bandwidth.StateAvailable {
+			return
+		}
+
+		participant.stableDownlinkChecks = 0
+
+		// Restore the most important degraded subscription.
+		var highest *candidate
+		for i := range candidates {
+			if !candidates[i].quality.Degraded {
+				continue
+			}
+
+			if highest == nil || candidates[i].quality.Priority > highest.quality.Priority {
+				highest = &candidates[i]
+			}
+		}
+
+		if highest != nil {
+			highest.track.RestoreSubscription(participantID)
+		}
+
+	default:
+		participant.stableDownlinkChecks = 0
+	}
+}
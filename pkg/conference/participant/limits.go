@@ -0,0 +1,99 @@
+package participant
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	ErrTooManyPublishedTracks = errors.New("too many published tracks")
+	ErrTooManySubscriptions   = errors.New("too many subscriptions")
+	// Another simulcast layer of a track that was already rejected.
+	ErrPublishedTrackRejected = errors.New("published track was rejected")
+)
+
+// Limits of the resources that a single participant may use, zero means no limit.
+type Limits struct {
+	// Maximum number of tracks that a participant may publish.
+	MaxPublishedTracks int
+	// Maximum number of tracks that a participant may be subscribed to.
+	MaxSubscriptions int
+}
+
+// Checks if a given participant may publish one more track. The simulcast layers of a track arrive one
+// by one, so the rejection is remembered and the further layers of the track are rejected right away.
+func (t *Tracker) checkPublishedTracksLimit(participant *Participant, trackID track.TrackID) error {
+	if _, rejected := participant.rejectedTracks[trackID]; rejected {
+		return fmt.Errorf("%w: %s", ErrPublishedTrackRejected, trackID)
+	}
+
+	if t.limits.MaxPublishedTracks == 0 {
+		return nil
+	}
+
+	published := 0
+	for _, track := range t.publishedTracks {
+		if track.Owner() == participant.ID {
+			published++
+		}
+	}
+
+	if published >= t.limits.MaxPublishedTracks {
+		if participant.rejectedTracks == nil {
+			participant.rejectedTracks = make(map[track.TrackID]struct{})
+		}
+
+		participant.rejectedTracks[trackID] = struct{}{}
+		metrics.AdmissionRejections.With("published_tracks").Inc()
+		return fmt.Errorf("%w: %d", ErrTooManyPublishedTracks, t.limits.MaxPublishedTracks)
+	}
+
+	return nil
+}
+
+// Checks if a given participant may subscribe to one more track.
+func (t *Tracker) checkSubscriptionsLimit(participantID ID) error {
+	if t.limits.MaxSubscriptions == 0 {
+		return nil
+	}
+
+	if t.subscriptionCount(participantID) >= t.limits.MaxSubscriptions {
+		metrics.AdmissionRejections.With("subscriptions").Inc()
+		return fmt.Errorf("%w: %d", ErrTooManySubscriptions, t.limits.MaxSubscriptions)
+	}
+
+	return nil
+}
+
+// Returns the number of tracks that a given participant is subscribed to.
+func (t *Tracker) subscriptionCount(participantID ID) int {
+	subscribed := 0
+	for _, track := range t.publishedTracks {
+		if track.IsSubscribed(participantID) {
+			subscribed++
+		}
+	}
+
+	return subscribed
+}
+
+// Checks if the SFU can afford one more subscription to a track of a given kind and returns the state
+// of the egress bandwidth budget. The audio is cheap and important, so it's never rejected.
+func (t *Tracker) checkEgressBudget(kind webrtc.RTPCodecType) (bandwidth.State, error) {
+	if kind != webrtc.RTPCodecTypeVideo {
+		return bandwidth.StateAvailable, nil
+	}
+
+	state := t.budget.State()
+	if state == bandwidth.StateExhausted {
+		metrics.AdmissionRejections.With("egress_bandwidth").Inc()
+		return state, fmt.Errorf("%w: %d bit/s", bandwidth.ErrBudgetExceeded, t.budget.Limit())
+	}
+
+	return state, nil
+}
@@ -0,0 +1,33 @@
+package participant
+
+import (
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Records the OpenTelemetry metrics of each participant: the forwarding rates and the queue depths
+// of its subscriptions, the packet loss on its downlink and the round-trip time. Should be called
+// periodically, the forwarding rates are counted since the previous call.
+func (t *Tracker) RecordMetrics() {
+	for participantID, participant := range t.participants {
+		for _, published := range t.publishedTracks {
+			stats, found := published.SubscriptionStats(participantID)
+			if !found {
+				continue
+			}
+
+			kind := attribute.String("kind", published.Info().Kind.String())
+			participant.Telemetry.AddCount(telemetry.ForwardedPackets, int64(stats.Packets), kind)
+			participant.Telemetry.AddCount(telemetry.ForwardedBytes, int64(stats.Bytes), kind)
+			participant.Telemetry.RecordInt(telemetry.QueueDepth, int64(stats.QueueDepth), kind)
+
+			if quality, found := published.SubscriptionQuality(participantID); found {
+				participant.Telemetry.RecordFloat(telemetry.PacketLoss, quality.PacketLoss, kind)
+			}
+		}
+
+		if rtt, found := participant.Peer.RoundTripTime(); found {
+			participant.Telemetry.RecordFloat(telemetry.RoundTripTime, rtt.Seconds())
+		}
+	}
+}
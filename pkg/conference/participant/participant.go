@@ -27,6 +27,22 @@ type Participant struct {
 	Peer            *peer.Peer[ID]
 	RemoteSessionID id.SessionID
 	Pong            chan<- Pong
+	// What the participant is allowed to do, assigned when the participant joins.
+	Role Role
+	// Set if a moderator muted the participant's audio for everyone.
+	AudioMutedByModerator bool
+	// Set once the participant joined the call, i.e. once its peer connection is established.
+	Joined bool
+	// Rules that subscribe the participant to the matching tracks, including those published later.
+	SubscriptionRules []SubscriptionRule
+	// Tracks that the participant explicitly unsubscribed from, the rules don't subscribe to them again.
+	unsubscribedTracks map[string]struct{}
+	// Tracks that the rules failed to subscribe the participant to, see `RecordRuleSubscriptionFailure`.
+	ruleFailures map[string]ruleFailure
+	// Tracks of the participant that were rejected by the limit, their simulcast layers are ignored.
+	rejectedTracks map[string]struct{}
+	// Number of consecutive checks without a significant packet loss on the participant's downlink.
+	stableDownlinkChecks int
 
 	Logger    *logrus.Entry
 	Telemetry *telemetry.Telemetry
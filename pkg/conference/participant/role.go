@@ -0,0 +1,69 @@
+package participant
+
+import "fmt"
+
+// The role of a participant defines what the participant is allowed to do in the conference.
+type Role int
+
+const (
+	// Can publish tracks and subscribe to the tracks of others.
+	RolePublisher Role = iota
+	// Can only subscribe to the tracks of others (receive-only).
+	RoleViewer
+	// Can do everything that a publisher can and moderate others (mute or kick them).
+	RoleModerator
+)
+
+func (r Role) String() string {
+	switch r {
+	case RolePublisher:
+		return "publisher"
+	case RoleViewer:
+		return "viewer"
+	case RoleModerator:
+		return "moderator"
+	default:
+		return fmt.Sprintf("role-%d", int(r))
+	}
+}
+
+// Parses the name of the role as written in the configuration, an empty name means a publisher.
+func ParseRole(name string) (Role, error) {
+	switch name {
+	case "", "publisher":
+		return RolePublisher, nil
+	case "viewer":
+		return RoleViewer, nil
+	case "moderator":
+		return RoleModerator, nil
+	default:
+		return RolePublisher, fmt.Errorf("unknown role: %s", name)
+	}
+}
+
+func (r Role) CanPublish() bool {
+	return r == RolePublisher || r == RoleModerator
+}
+
+func (r Role) CanModerate() bool {
+	return r == RoleModerator
+}
+
+// Returns true if a participant with the role may act on (e.g. kick) a participant with another role.
+func (r Role) Outranks(other Role) bool {
+	return r.rank() > other.rank()
+}
+
+// The moderators rank above the publishers, who rank above the viewers.
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 0
+	case RolePublisher:
+		return 1
+	case RoleModerator:
+		return 2
+	default:
+		return -1
+	}
+}
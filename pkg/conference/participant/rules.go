@@ -0,0 +1,155 @@
+package participant
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
+	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// A rule that subscribes the participant to all tracks that match it, e.g. "all audio tracks" or
+// "all tracks of a given user". The fields that are not set match any track.
+type SubscriptionRule struct {
+	// The owner of the tracks.
+	UserID   id.UserID
+	DeviceID id.DeviceID
+	// The kind of the tracks, zero matches both audio and video.
+	Kind webrtc.RTPCodecType
+	// Purpose of the stream that the tracks belong to.
+	Purpose event.CallSDPStreamMetadataPurpose
+	// What the participant wants to get from the matching tracks.
+	Requirements track.SubscriptionRequirements
+}
+
+func (r SubscriptionRule) matches(owner ID, published *track.PublishedTrack[ID]) bool {
+	info := published.Info()
+
+	switch {
+	case r.UserID != "" && r.UserID != owner.UserID:
+		return false
+	case r.DeviceID != "" && r.DeviceID != owner.DeviceID:
+		return false
+	case r.Kind != 0 && r.Kind != info.Kind:
+		return false
+	case r.Purpose != "" && string(r.Purpose) != published.Metadata().Purpose:
+		return false
+	}
+
+	return true
+}
+
+// A subscription that the rules of a participant ask for and that does not exist yet.
+type RuleSubscription struct {
+	SubscriberID ID
+	Info         webrtc_ext.TrackInfo
+	Requirements track.SubscriptionRequirements
+}
+
+// Replaces the subscription rules of a given participant and returns the subscriptions to the existing
+// tracks that the new rules ask for. The subscriptions made by the previous rules are kept.
+func (t *Tracker) SetSubscriptionRules(participantID ID, rules []SubscriptionRule) ([]RuleSubscription, error) {
+	participant := t.participants[participantID]
+	if participant == nil {
+		return nil, fmt.Errorf("participant %s does not exist", participantID)
+	}
+
+	participant.SubscriptionRules = rules
+	// The new rules are applied from scratch, so the participant learns the results of all of them.
+	participant.ruleFailures = nil
+
+	var subscriptions []RuleSubscription
+	for _, published := range t.publishedTracks {
+		if subscription, found := t.matchSubscriptionRules(participant, published); found {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	return subscriptions, nil
+}
+
+// Returns the subscriptions to a given track that the rules of the participants ask for. Called when
+// a new track is published and when the metadata of a track changes, since the purpose of the stream
+// may not be known before.
+func (t *Tracker) RuleSubscriptionsTo(trackID track.TrackID) []RuleSubscription {
+	published := t.publishedTracks[trackID]
+	if published == nil {
+		return nil
+	}
+
+	var subscriptions []RuleSubscription
+	for _, participant := range t.participants {
+		if subscription, found := t.matchSubscriptionRules(participant, published); found {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	return subscriptions
+}
+
+// A subscription by the rules that failed. The state that the failure depended on is remembered, so that
+// the subscription is not attempted again (and the subscriber is not informed again) until it changes.
+type ruleFailure struct {
+	err error
+	// The number of the subscriptions of the participant at the time of the failure.
+	subscriptions int
+}
+
+// Remembers that the rules failed to subscribe a given participant to a given track.
+func (t *Tracker) RecordRuleSubscriptionFailure(participantID ID, trackID track.TrackID, err error) {
+	participant := t.participants[participantID]
+	if participant == nil {
+		return
+	}
+
+	if participant.ruleFailures == nil {
+		participant.ruleFailures = make(map[track.TrackID]ruleFailure)
+	}
+
+	participant.ruleFailures[trackID] = ruleFailure{err, t.subscriptionCount(participantID)}
+}
+
+// Returns true if the reason of the failure may be gone, i.e. if the participant has fewer subscriptions
+// than at the time of the failure or if the SFU is within its bandwidth budget again.
+func (t *Tracker) mayRetry(participantID ID, failure ruleFailure) bool {
+	switch {
+	case errors.Is(failure.err, ErrTooManySubscriptions):
+		return t.subscriptionCount(participantID) < failure.subscriptions
+	case errors.Is(failure.err, bandwidth.ErrBudgetExceeded):
+		return t.budget.State() != bandwidth.StateExhausted
+	default:
+		return false
+	}
+}
+
+// Finds the first rule of the participant that matches the track. The existing subscriptions are left
+// intact, so the explicit subscription requests take precedence.
+func (t *Tracker) matchSubscriptionRules(
+	participant *Participant,
+	published *track.PublishedTrack[ID],
+) (RuleSubscription, bool) {
+	if published.Owner() == participant.ID || published.IsSubscribed(participant.ID) {
+		return RuleSubscription{}, false
+	}
+
+	trackID := published.Info().TrackID
+	if _, unsubscribed := participant.unsubscribedTracks[trackID]; unsubscribed {
+		return RuleSubscription{}, false
+	}
+
+	if failure, failed := participant.ruleFailures[trackID]; failed && !t.mayRetry(participant.ID, failure) {
+		return RuleSubscription{}, false
+	}
+
+	for _, rule := range participant.SubscriptionRules {
+		if rule.matches(published.Owner(), published) {
+			return RuleSubscription{participant.ID, published.Info(), rule.Requirements}, true
+		}
+	}
+
+	return RuleSubscription{}, false
+}
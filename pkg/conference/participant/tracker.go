@@ -1,40 +1,125 @@
 package participant
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
+	"github.com/matrix-org/waterfall/pkg/conference/publisher"
 	"github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/webrtc/v3"
 )
 
+var ErrTrackNotFound = errors.New("track does not exist")
+
 type TrackStoppedMessage struct {
 	TrackID track.TrackID
 	OwnerID ID
 }
 
+// Sent when the simulcast layers that the owner of the track should send change.
+type TrackLayersMessage struct {
+	Info    webrtc_ext.TrackInfo
+	OwnerID ID
+	// RIDs of the layers mapped to whether the layer should be sent.
+	Layers map[string]bool
+}
+
+// Sent when the resolution of a published track observed in the bitstream changes.
+type TrackResolutionMessage struct {
+	Info    webrtc_ext.TrackInfo
+	OwnerID ID
+}
+
+// Sent when the SFU switches a subscription to a different layer on its own.
+type SubscriptionLayerMessage struct {
+	Info         webrtc_ext.TrackInfo
+	SubscriberID ID
+	Layer        track.SubscriptionLayer
+	Reason       track.LayerChangeReason
+}
+
+// Sent when a published track stalls (stops receiving packets unexpectedly) or recovers.
+type TrackStatusMessage struct {
+	Info    webrtc_ext.TrackInfo
+	OwnerID ID
+	Status  publisher.Status
+}
+
 // Tracks participants and their corresponding tracks.
 // These are grouped together as the field in this structure must be kept synchronized.
 type Tracker struct {
 	participants    map[ID]*Participant
 	publishedTracks map[track.TrackID]*track.PublishedTrack[ID]
 
-	publishedTrackStopped chan<- TrackStoppedMessage
-	conferenceEnded       <-chan struct{}
+	publishedTrackStopped    chan<- TrackStoppedMessage
+	publishedTrackStatus     chan<- TrackStatusMessage
+	publishedTrackLayers     chan<- TrackLayersMessage
+	publishedTrackResolution chan<- TrackResolutionMessage
+	subscriptionLayer        chan<- SubscriptionLayerMessage
+	conferenceEnded          <-chan struct{}
+
+	// Minimal interval between two key frame requests sent to a single publisher.
+	keyFrameRequestInterval time.Duration
+	// Limits of the resources that a single participant may use.
+	limits Limits
+	// Egress bandwidth budget of the SFU (shared by all conferences).
+	budget *bandwidth.Budget
 }
 
-func NewParticipantTracker(conferenceEnded <-chan struct{}) (*Tracker, <-chan TrackStoppedMessage) {
+// Channels that inform the conference about the changes of the published tracks.
+type TrackerEvents struct {
+	PublishedTrackStopped    <-chan TrackStoppedMessage
+	PublishedTrackStatus     <-chan TrackStatusMessage
+	PublishedTrackLayers     <-chan TrackLayersMessage
+	PublishedTrackResolution <-chan TrackResolutionMessage
+	SubscriptionLayer        <-chan SubscriptionLayerMessage
+}
+
+func NewParticipantTracker(
+	conferenceEnded <-chan struct{},
+	keyFrameRequestInterval time.Duration,
+	limits Limits,
+	budget *bandwidth.Budget,
+) (*Tracker, TrackerEvents) {
 	publishedTrackStopped := make(chan TrackStoppedMessage)
-	return &Tracker{
-		participants:          make(map[ID]*Participant),
-		publishedTracks:       make(map[track.TrackID]*track.PublishedTrack[ID]),
-		publishedTrackStopped: publishedTrackStopped,
-		conferenceEnded:       conferenceEnded,
-	}, publishedTrackStopped
+	publishedTrackStatus := make(chan TrackStatusMessage)
+	publishedTrackLayers := make(chan TrackLayersMessage)
+	publishedTrackResolution := make(chan TrackResolutionMessage)
+	subscriptionLayer := make(chan SubscriptionLayerMessage)
+
+	tracker := &Tracker{
+		participants:             make(map[ID]*Participant),
+		publishedTracks:          make(map[track.TrackID]*track.PublishedTrack[ID]),
+		publishedTrackStopped:    publishedTrackStopped,
+		publishedTrackStatus:     publishedTrackStatus,
+		publishedTrackLayers:     publishedTrackLayers,
+		publishedTrackResolution: publishedTrackResolution,
+		subscriptionLayer:        subscriptionLayer,
+		conferenceEnded:          conferenceEnded,
+		keyFrameRequestInterval:  keyFrameRequestInterval,
+		limits:                   limits,
+		budget:                   budget,
+	}
+
+	return tracker, TrackerEvents{
+		publishedTrackStopped,
+		publishedTrackStatus,
+		publishedTrackLayers,
+		publishedTrackResolution,
+		subscriptionLayer,
+	}
 }
 
 // Adds a new participant in the list.
 func (t *Tracker) AddParticipant(participant *Participant) {
+	if _, found := t.participants[participant.ID]; !found {
+		metrics.Participants.Inc()
+	}
+
 	t.participants[participant.ID] = participant
 }
 
@@ -68,6 +153,7 @@ func (t *Tracker) RemoveParticipant(participantID ID) map[string]bool {
 	participant.Peer.Terminate()
 	close(participant.Pong)
 	delete(t.participants, participantID)
+	metrics.Participants.Dec()
 
 	// Remove the participant's tracks from all participants who might have subscribed to them.
 	streamIdentifiers := make(map[string]bool)
@@ -93,6 +179,7 @@ func (t *Tracker) RemoveParticipant(participantID ID) map[string]bool {
 func (t *Tracker) AddPublishedTrack(
 	participantID ID,
 	remoteTrack *webrtc.TrackRemote,
+	simulcast webrtc_ext.SimulcastLayout,
 	metadata track.TrackMetadata,
 ) error {
 	participant := t.participants[participantID]
@@ -109,10 +196,16 @@ func (t *Tracker) AddPublishedTrack(
 		return nil
 	}
 
+	if err := t.checkPublishedTracksLimit(participant, remoteTrack.ID()); err != nil {
+		return err
+	}
+
 	published, err := track.NewPublishedTrack(
 		participantID,
 		participant.Peer.RequestKeyFrame,
+		t.keyFrameRequestInterval,
 		remoteTrack,
+		simulcast,
 		metadata,
 		participant.Logger,
 		participant.Telemetry.ChildBuilder(),
@@ -121,22 +214,83 @@ func (t *Tracker) AddPublishedTrack(
 		return err
 	}
 
-	// Wait for the track to complete and inform the conference about it.
+	metrics.PublishedTracks.With(remoteTrack.Kind().String()).Inc()
+
+	// Forward the status changes of the track to the conference until the track is complete, then
+	// inform the conference about it. Stop the go-routine if the conference stopped.
 	go func() {
-		// Wait for the track to complete.
-		<-published.Done()
+		for {
+			select {
+			case status := <-published.StatusChanges():
+				select {
+				case t.publishedTrackStatus <- TrackStatusMessage{published.Info(), participantID, status}:
+				case <-t.conferenceEnded:
+					return
+				}
 
-		// Inform the conference that the track is gone. Or stop the go-routine if the conference stopped.
-		select {
-		case t.publishedTrackStopped <- TrackStoppedMessage{remoteTrack.ID(), participantID}:
-		case <-t.conferenceEnded:
+			case <-published.RequestedLayersChanged():
+				layers := published.RequestedLayers()
+				select {
+				case t.publishedTrackLayers <- TrackLayersMessage{published.Info(), participantID, layers}:
+				case <-t.conferenceEnded:
+					return
+				}
+
+			case <-published.ResolutionChanged():
+				select {
+				case t.publishedTrackResolution <- TrackResolutionMessage{published.Info(), participantID}:
+				case <-t.conferenceEnded:
+					return
+				}
+
+			case <-published.SubscriptionLayerChanged():
+				for _, change := range published.SubscriptionLayerChanges() {
+					msg := SubscriptionLayerMessage{published.Info(), change.SubscriberID, change.Layer, change.Reason}
+					select {
+					case t.subscriptionLayer <- msg:
+					case <-t.conferenceEnded:
+						return
+					}
+				}
+
+			case <-published.Done():
+				select {
+				case t.publishedTrackStopped <- TrackStoppedMessage{remoteTrack.ID(), participantID}:
+				case <-t.conferenceEnded:
+				}
+				return
+			}
 		}
 	}()
 
+	// Tracks published by a participant muted by a moderator must stay muted.
+	if participant.AudioMutedByModerator && published.Info().Kind == webrtc.RTPCodecTypeAudio {
+		if err := published.SetMutedByModerator(true); err != nil {
+			return err
+		}
+	}
+
 	t.publishedTracks[remoteTrack.ID()] = published
 	return nil
 }
 
+// Iterates over published tracks of a given participant and calls a closure upon the layers
+// that the participant is asked to send for each simulcast track.
+func (t *Tracker) ForEachRequestedLayers(
+	participantID ID,
+	fn func(webrtc_ext.TrackInfo, map[string]bool),
+) {
+	for _, track := range t.publishedTracks {
+		if track.Owner() != participantID {
+			continue
+		}
+
+		if layers := track.RequestedLayers(); layers != nil {
+			fn(track.Info(), layers)
+		}
+	}
+}
+
 // Iterates over published tracks and calls a closure upon each track info.
 func (t *Tracker) ForEachPublishedTrackInfo(fn func(ID, webrtc_ext.TrackInfo)) {
 	for _, track := range t.publishedTracks {
@@ -144,6 +298,55 @@ func (t *Tracker) ForEachPublishedTrackInfo(fn func(ID, webrtc_ext.TrackInfo)) {
 	}
 }
 
+// Returns the owner and the info of a given published track, if it exists.
+func (t *Tracker) GetPublishedTrackInfo(id track.TrackID) (ID, webrtc_ext.TrackInfo, bool) {
+	if track, found := t.publishedTracks[id]; found {
+		return track.Owner(), track.Info(), true
+	}
+
+	return ID{}, webrtc_ext.TrackInfo{}, false
+}
+
+// Returns the snapshots of the tracks published by a given participant.
+func (t *Tracker) PublishedTrackSnapshots(participantID ID) []track.Snapshot {
+	snapshots := []track.Snapshot{}
+	for _, published := range t.publishedTracks {
+		if published.Owner() == participantID {
+			snapshots = append(snapshots, published.Snapshot())
+		}
+	}
+
+	return snapshots
+}
+
+// Forces the subscription of a given participant to a given track to use a given layer.
+func (t *Tracker) ForceSubscriptionLayer(participantID ID, trackID track.TrackID, rid string) error {
+	published := t.publishedTracks[trackID]
+	if published == nil {
+		return fmt.Errorf("%w: %s", ErrTrackNotFound, trackID)
+	}
+
+	return published.ForceSubscriptionLayer(participantID, rid)
+}
+
+// Returns the resolution of a given track as observed in the bitstream, zeros if it's not known.
+func (t *Tracker) GetPublishedTrackResolution(id track.TrackID) (int, int) {
+	if track, found := t.publishedTracks[id]; found {
+		return track.Resolution()
+	}
+
+	return 0, 0
+}
+
+// Returns the layer that a given participant gets from a given track if it's subscribed to it.
+func (t *Tracker) GetSubscriptionLayer(participantID ID, trackID track.TrackID) (track.SubscriptionLayer, bool) {
+	if track, found := t.publishedTracks[trackID]; found {
+		return track.SubscriptionLayer(participantID)
+	}
+
+	return track.SubscriptionLayer{}, false
+}
+
 // Updates metadata associated with a given track.
 func (t *Tracker) UpdatePublishedTrackMetadata(id track.TrackID, metadata track.TrackMetadata) {
 	if track, found := t.publishedTracks[id]; found {
@@ -157,6 +360,12 @@ func (t *Tracker) RemovePublishedTrack(id track.TrackID) {
 	if publishedTrack, found := t.publishedTracks[id]; found {
 		publishedTrack.Stop()
 		delete(t.publishedTracks, id)
+		metrics.PublishedTracks.With(publishedTrack.Info().Kind.String()).Dec()
+	}
+
+	for _, participant := range t.participants {
+		delete(participant.unsubscribedTracks, id)
+		delete(participant.ruleFailures, id)
 	}
 }
 
@@ -164,7 +373,7 @@ func (t *Tracker) RemovePublishedTrack(id track.TrackID) {
 func (t *Tracker) Subscribe(
 	participantID ID,
 	trackID track.TrackID,
-	desiredWidth, desiredHeight int,
+	requirements track.SubscriptionRequirements,
 ) error {
 	// Check if the participant exists that wants to subscribe exists.
 	participant := t.participants[participantID]
@@ -175,25 +384,80 @@ func (t *Tracker) Subscribe(
 	// Check if the track that we want to subscribe exists.
 	published := t.publishedTracks[trackID]
 	if published == nil {
-		return fmt.Errorf("track %s does not exist", trackID)
+		return fmt.Errorf("%w: %s", ErrTrackNotFound, trackID)
+	}
+
+	// Only the new subscriptions are subject to the limits, the existing ones may change their requirements.
+	isNew := !published.IsSubscribed(participantID)
+	budgetState := bandwidth.StateAvailable
+	if isNew {
+		if err := t.checkSubscriptionsLimit(participantID); err != nil {
+			return err
+		}
+
+		state, err := t.checkEgressBudget(published.Info().Kind)
+		if err != nil {
+			return err
+		}
+
+		budgetState = state
 	}
 
+	// The explicit request overrides the previous explicit unsubscription.
+	delete(participant.unsubscribedTracks, trackID)
+	delete(participant.ruleFailures, trackID)
+
 	// Subscribe to the track.
 	if err := published.Subscribe(
 		participantID,
 		participant.Peer,
-		desiredWidth,
-		desiredHeight,
+		requirements,
 		participant.Logger,
 	); err != nil {
 		return err
 	}
 
+	// Steer the new subscription to the lowest layer while the SFU is close to its budget.
+	if budgetState == bandwidth.StateNearLimit {
+		published.LimitSubscription(participantID)
+	}
+
+	return nil
+}
+
+// Mutes or unmutes all audio tracks of a given participant for everyone on behalf of a moderator.
+func (t *Tracker) SetAudioMutedByModerator(participantID ID, muted bool) error {
+	participant := t.participants[participantID]
+	if participant == nil {
+		return fmt.Errorf("participant %s does not exist", participantID)
+	}
+
+	participant.AudioMutedByModerator = muted
+
+	for _, published := range t.publishedTracks {
+		if published.Owner() != participantID || published.Info().Kind != webrtc.RTPCodecTypeAudio {
+			continue
+		}
+
+		if err := published.SetMutedByModerator(muted); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // Unsubscribes a given `participantID` from the track.
+// The subscription rules of the participant won't subscribe it to the track again.
 func (t *Tracker) Unsubscribe(participantID ID, trackID track.TrackID) {
+	if participant := t.participants[participantID]; participant != nil {
+		if participant.unsubscribedTracks == nil {
+			participant.unsubscribedTracks = make(map[track.TrackID]struct{})
+		}
+
+		participant.unsubscribedTracks[trackID] = struct{}{}
+	}
+
 	if published := t.publishedTracks[trackID]; published != nil {
 		published.Unsubscribe(participantID)
 	}
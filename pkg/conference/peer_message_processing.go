@@ -1,10 +1,15 @@
 package conference
 
 import (
+	"encoding/json"
+	"errors"
+
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	published "github.com/matrix-org/waterfall/pkg/conference/track"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webhook"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"go.opentelemetry.io/otel/attribute"
 	"maunium.net/go/mautrix/event"
 )
@@ -14,6 +19,10 @@ func (c *Conference) processJoinedTheCallMessage(sender participant.ID, message
 
 	if p := c.getParticipant(sender); p != nil {
 		p.Telemetry.AddEvent("joined the call")
+		if !p.Joined {
+			p.Joined = true
+			c.notifyParticipant(webhook.ParticipantJoined, sender, "")
+		}
 		return
 	}
 }
@@ -25,24 +34,57 @@ func (c *Conference) processLeftTheCallMessage(sender participant.ID, msg peer.L
 	}
 
 	p.Logger.Infof("Left the call: %s", msg.Reason)
-	c.removeParticipant(p.ID)
+	c.removeParticipant(p.ID, msg.Reason)
 	c.matrixWorker.sendSignalingMessage(p.AsMatrixRecipient(), signaling.Hangup{Reason: msg.Reason})
 }
 
 func (c *Conference) processNewTrackPublishedMessage(sender participant.ID, msg peer.NewTrackPublished) {
 	id := msg.RemoteTrack.ID()
+
+	// Viewers are receive-only, so we ignore the tracks that they try to publish.
+	if p := c.getParticipant(sender); p != nil && !p.Role.CanPublish() {
+		p.Logger.WithField("role", p.Role).Warnf("Rejecting track %s from a participant who can't publish", id)
+		p.Telemetry.AddEvent("rejected published track", attribute.String("track_id", id))
+		return
+	}
+
 	c.newLogger(sender).Infof("Published new track: %s (%v)", id, msg.RemoteTrack.RID())
 
 	// Find metadata for a given track.
 	trackMetadata := streamIntoTrackMetadata(c.streamsMetadata)[id]
 
+	// The simulcast layers of a track arrive one by one, only the first one is a new track.
+	_, _, known := c.tracker.GetPublishedTrackInfo(id)
+
 	// If a new track has been published, we inform everyone about new track available.
-	c.tracker.AddPublishedTrack(sender, msg.RemoteTrack, trackMetadata)
+	if err := c.tracker.AddPublishedTrack(sender, msg.RemoteTrack, msg.Simulcast, trackMetadata); err != nil {
+		switch {
+		case errors.Is(err, participant.ErrPublishedTrackRejected):
+			// The publisher was informed when the first layer of the track was rejected.
+		case errors.Is(err, participant.ErrTooManyPublishedTracks):
+			c.newLogger(sender).WithError(err).Warnf("Rejecting published track %s", id)
+			c.rejectPublishedTrack(sender, webrtc_ext.TrackInfoFromTrack(msg.RemoteTrack), PublishReasonLimitExceeded)
+		default:
+			c.newLogger(sender).WithError(err).Warnf("Failed to add published track %s", id)
+		}
+
+		return
+	}
+
+	if !known {
+		c.notifyTrack(webhook.TrackPublished, sender, webrtc_ext.TrackInfoFromTrack(msg.RemoteTrack))
+		c.applySubscriptionRules(c.tracker.RuleSubscriptionsTo(id))
+	}
+
 	c.resendMetadataToAllExcept(sender)
 }
 
 func (c *Conference) processPublishedTrackFailedMessage(sender participant.ID, trackID published.TrackID) {
 	c.newLogger(sender).Infof("Failed published track: %s", trackID)
+	if owner, info, found := c.tracker.GetPublishedTrackInfo(trackID); found {
+		c.notifyTrack(webhook.TrackRemoved, owner, info)
+	}
+
 	c.tracker.RemovePublishedTrack(trackID)
 	c.resendMetadataToAllExcept(sender)
 }
@@ -112,6 +154,8 @@ func (c *Conference) processRenegotiationRequiredMessage(sender participant.ID,
 }
 
 func (c *Conference) processDataChannelMessage(sender participant.ID, msg peer.DataChannelMessage) {
+	c.capture.recordDataChannelMessage(sender, msg.Message)
+
 	p := c.getParticipant(sender)
 	if p == nil {
 		return
@@ -129,8 +173,7 @@ func (c *Conference) processDataChannelMessage(sender participant.ID, msg peer.D
 	// focusEvent.Content.ParseRaw(focusEvent.Type) but it throws an error.
 	switch focusEvent.Type.Type {
 	case event.FocusCallTrackSubscription.Type:
-		focusEvent.Content.ParseRaw(event.FocusCallTrackSubscription)
-		c.processTrackSubscriptionMessage(p, *focusEvent.Content.AsFocusCallTrackSubscription())
+		c.processTrackSubscriptionMessage(p, focusEvent)
 	case event.FocusCallNegotiate.Type:
 		focusEvent.Content.ParseRaw(event.FocusCallNegotiate)
 		c.processNegotiateMessage(p, *focusEvent.Content.AsFocusCallNegotiate())
@@ -140,6 +183,10 @@ func (c *Conference) processDataChannelMessage(sender participant.ID, msg peer.D
 	case event.FocusCallSDPStreamMetadataChanged.Type:
 		focusEvent.Content.ParseRaw(event.FocusCallSDPStreamMetadataChanged)
 		c.processMetadataMessage(p.ID, *focusEvent.Content.AsFocusCallSDPStreamMetadataChanged())
+	case FocusCallMuteParticipant.Type:
+		c.processMuteParticipantMessage(p, focusEvent)
+	case FocusCallKickParticipant.Type:
+		c.processKickParticipantMessage(p, focusEvent)
 	default:
 		p.Logger.WithField("type", focusEvent.Type.Type).Warn("Received data channel message of unknown type")
 	}
@@ -164,31 +211,60 @@ func (c *Conference) processDataChannelAvailableMessage(sender participant.ID, m
 	if err := p.SendOverDataChannel(metadataEvent); err != nil {
 		p.Logger.Errorf("Failed to send SDP stream metadata: %v", err)
 	}
+
+	// The layers might have been paused before the data channel was available.
+	c.tracker.ForEachRequestedLayers(p.ID, func(info webrtc_ext.TrackInfo, layers map[string]bool) {
+		c.sendRequestedLayers(p, info, layers)
+	})
+
+	// The subscriptions require renegotiation, which happens over the data channel.
+	c.applyAutoSubscribe(p)
 }
 
 // Handle the `FocusEvent` from the DataChannel message.
 func (c *Conference) processTrackSubscriptionMessage(
 	p *participant.Participant,
-	msg event.FocusCallTrackSubscriptionEventContent,
+	focusEvent event.Event,
 ) {
 	p.Logger.Debug("Received track subscription request over DC")
 
-	// Let's first handle the unsubscribe commands.
+	// We parse it on our own, since the subscription may contain our extensions.
+	var msg TrackSubscriptionEventContent
+	if err := json.Unmarshal(focusEvent.Content.VeryRaw, &msg); err != nil {
+		p.Logger.WithError(err).Error("Failed to unmarshal track subscription message")
+		return
+	}
+
+	// The rules go first, so that the explicit requests below take precedence over them.
+	if msg.Rules != nil {
+		c.processSubscriptionRules(p, *msg.Rules)
+	}
+
+	// Now let's handle the unsubscribe commands.
 	for _, track := range msg.Unsubscribe {
 		c.tracker.Unsubscribe(p.ID, track.TrackID)
+
+		info := webrtc_ext.TrackInfo{StreamID: track.StreamID, TrackID: track.TrackID}
+		c.sendSubscriptionResult(p, info, SubscriptionResultUnsubscribed, "", published.SubscriptionLayer{})
 	}
 
 	// Now let's handle the subscribe commands.
 	for _, track := range msg.Subscribe {
-		if err := c.tracker.Subscribe(p.ID, track.TrackID, track.Width, track.Height); err != nil {
+		info := webrtc_ext.TrackInfo{StreamID: track.StreamID, TrackID: track.TrackID}
+
+		if err := c.tracker.Subscribe(p.ID, track.TrackID, track.requirements()); err != nil {
 			p.Logger.Errorf("Failed to subscribe to track %s: %v", track.TrackID, err)
+			c.sendSubscriptionFailure(p, info, err)
 			continue
 		}
+
+		layer, _ := c.tracker.GetSubscriptionLayer(p.ID, track.TrackID)
+		c.sendSubscriptionResult(p, info, SubscriptionResultSubscribed, "", layer)
 	}
 }
 
 func (c *Conference) processNegotiateMessage(p *participant.Participant, msg event.FocusCallNegotiateEventContent) {
-	c.updateMetadata(msg.SDPStreamMetadata)
+	c.updateMetadata(p, msg.SDPStreamMetadata)
 
 	switch msg.Description.Type {
 	case event.CallDataTypeOffer:
@@ -250,6 +326,11 @@ func (c *Conference) processMetadataMessage(
 	sender participant.ID,
 	msg event.FocusCallSDPStreamMetadataChangedEventContent,
 ) {
-	c.updateMetadata(msg.SDPStreamMetadata)
+	p := c.getParticipant(sender)
+	if p == nil {
+		return
+	}
+
+	c.updateMetadata(p, msg.SDPStreamMetadata)
 	c.resendMetadataToAllExcept(sender)
 }
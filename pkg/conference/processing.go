@@ -1,12 +1,19 @@
 package conference
 
 import (
+	"time"
+
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"maunium.net/go/mautrix/event"
 )
 
+// How often the OpenTelemetry metrics of the participants are recorded.
+const metricsRecordingInterval = 10 * time.Second
+
 // Listen on messages from incoming channels and process them.
 // This is essentially the main loop of the conference.
 // If this function returns, the conference is over.
@@ -14,7 +21,19 @@ func (c *Conference) processMessages(signalDone chan struct{}) {
 	// When the main loop of the conference ends, clean up the resources.
 	defer close(signalDone)
 	defer c.matrixWorker.stop()
+	defer c.audit.Close()
+	defer c.capture.close()
 	defer c.telemetry.End()
+	defer metrics.Conferences.Dec()
+	defer c.notify(webhook.Event{Type: webhook.ConferenceEnded})
+
+	// Periodically adapt the subscriptions to the network conditions of the subscribers.
+	adaptationTicker := time.NewTicker(subscriptionAdaptationInterval)
+	defer adaptationTicker.Stop()
+
+	// Periodically record the metrics of the participants.
+	metricsTicker := time.NewTicker(metricsRecordingInterval)
+	defer metricsTicker.Stop()
 
 	for {
 		select {
@@ -24,10 +43,26 @@ func (c *Conference) processMessages(signalDone chan struct{}) {
 			c.processMatrixMessage(msg)
 		case msg := <-c.publishedTrackStopped:
 			c.processPublishedTrackFailedMessage(msg.OwnerID, msg.TrackID)
+		case msg := <-c.publishedTrackStatus:
+			c.processPublishedTrackStatusMessage(msg)
+		case msg := <-c.publishedTrackLayers:
+			c.processPublishedTrackLayersMessage(msg)
+		case msg := <-c.publishedTrackResolution:
+			c.processPublishedTrackResolutionMessage(msg)
+		case msg := <-c.subscriptionLayer:
+			c.processSubscriptionLayerMessage(msg)
+		case <-adaptationTicker.C:
+			c.tracker.AdaptSubscriptions()
+		case <-metricsTicker.C:
+			c.tracker.RecordMetrics()
+		case request := <-c.adminRequests:
+			request(c)
+		case result := <-c.powerLevels.fetched:
+			c.processPowerLevels(result)
 		}
 
-		// If there are no more participants, stop the conference.
-		if !c.tracker.HasParticipants() {
+		// If there are no more participants (nor the ones waiting to join), stop the conference.
+		if !c.tracker.HasParticipants() && len(c.powerLevels.pending) == 0 {
 			c.logger.Info("No more participants, stopping the conference")
 			return
 		}
@@ -36,6 +71,8 @@ func (c *Conference) processMessages(signalDone chan struct{}) {
 
 // Process a message from a local peer.
 func (c *Conference) processPeerMessage(message channel.Message[participant.ID, peer.MessageContent]) {
+	c.auditPeerMessage(message)
+
 	// Since Go does not support ADTs, we have to use a switch statement to
 	// determine the actual type of the message.
 	switch msg := message.Content.(type) {
@@ -61,6 +98,18 @@ func (c *Conference) processPeerMessage(message channel.Message[participant.ID,
 }
 
 func (c *Conference) processMatrixMessage(msg MatrixMessage) {
+	c.auditMatrixMessage(msg)
+	c.capture.recordMatrixMessage(msg)
+	c.handleMatrixMessage(msg)
+}
+
+func (c *Conference) handleMatrixMessage(msg MatrixMessage) {
+	// Keep the order of the messages of the participants who wait for the power levels.
+	if c.powerLevels.isPending(msg.Sender) {
+		c.powerLevels.pending = append(c.powerLevels.pending, msg)
+		return
+	}
+
 	switch ev := msg.Content.(type) {
 	case *event.CallInviteEventContent:
 		c.onNewParticipant(msg.Sender, ev)
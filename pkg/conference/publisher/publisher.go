@@ -126,6 +126,12 @@ func (p *Publisher) IsStalled() bool {
 	return p.observer.stalled.Load()
 }
 
+// Gives the publisher a new chance to deliver packets before it's reported as stalled again,
+// e.g. when the publisher that had been stalled for a reason (muted) is expected to resume.
+func (p *Publisher) ResetStallTimer() {
+	p.observer.reset()
+}
+
 // Reads a single packet from the remote track and forwards it to all subscribers.
 // The function stops when the remote track is closed or an error occurs when reading.
 // Each time new packet is received, the provided callback is called.
@@ -26,31 +26,37 @@ type statusObserver struct {
 }
 
 func newStatusObserver(timeout time.Duration) *statusObserver {
-	statusCh := make(chan Status, 1)
-	stalled := atomic.Bool{}
+	observer := &statusObserver{statusCh: make(chan Status, 1)}
 
-	worker := worker.StartWorker(worker.Config[struct{}]{
+	observer.worker = worker.StartWorker(worker.Config[struct{}]{
 		ChannelSize: 1,
 		Timeout:     timeout,
 		OnTimeout: func() {
-			if stalled.CompareAndSwap(false, true) {
-				statusCh <- StatusStalled
+			if observer.stalled.CompareAndSwap(false, true) {
+				observer.statusCh <- StatusStalled
 			}
 		},
 		OnTask: func(struct{}) {
-			if stalled.CompareAndSwap(true, false) {
-				statusCh <- StatusRecovered
+			if observer.stalled.CompareAndSwap(true, false) {
+				observer.statusCh <- StatusRecovered
 			}
 		},
 	})
 
-	return &statusObserver{worker, statusCh, stalled}
+	return observer
 }
 
 func (o *statusObserver) packetArrived() {
 	o.worker.Send(struct{}{})
 }
 
+// Starts the observation anew without reporting the recovery, i.e. the stalled status is
+// reported again if no packets are received within the timeout from now on.
+func (o *statusObserver) reset() {
+	o.stalled.Store(false)
+	o.worker.Send(struct{}{})
+}
+
 func (o *statusObserver) stop() {
 	o.worker.Stop()
 	close(o.statusCh)
@@ -0,0 +1,53 @@
+package publisher //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+)
+
+func expectStatus(t *testing.T, observer *statusObserver, expected Status, within time.Duration) {
+	t.Helper()
+
+	select {
+	case status := <-observer.statusCh:
+		if status != expected {
+			t.Fatalf("expected status %d, got %d", expected, status)
+		}
+	case <-time.After(within):
+		t.Fatalf("expected status %d within %v", expected, within)
+	}
+}
+
+func expectNoStatus(t *testing.T, observer *statusObserver, within time.Duration) {
+	t.Helper()
+
+	select {
+	case status := <-observer.statusCh:
+		t.Fatalf("unexpected status %d", status)
+	case <-time.After(within):
+	}
+}
+
+func TestStatusObserverReset(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	observer := newStatusObserver(timeout)
+	defer observer.worker.Stop()
+
+	expectStatus(t, observer, StatusStalled, 3*timeout)
+
+	// The reset neither reports the recovery nor the stall until the timeout passes.
+	observer.reset()
+	if observer.stalled.Load() {
+		t.Fatal("the observer must not be stalled after the reset")
+	}
+	expectNoStatus(t, observer, timeout/2)
+
+	// The publisher did not resume in time, so it's reported as stalled again.
+	expectStatus(t, observer, StatusStalled, 3*timeout)
+
+	// A packet after the reset is not a recovery either.
+	observer.reset()
+	observer.packetArrived()
+	expectNoStatus(t, observer, timeout/2)
+}
@@ -1,6 +1,9 @@
 package publisher
 
 import (
+	"strings"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
@@ -11,8 +14,25 @@ type RemoteTrack struct {
 	Track *webrtc.TrackRemote
 }
 
-// Implement the `Track` interface for the `webrtc.TrackRemote`.
+// Implement the `Track` interface for the `webrtc.TrackRemote`. Note that the retransmissions
+// that the publisher sends over RTX are unwrapped by the `webrtc_ext.RTXInterceptor` and are
+// returned here as regular packets in the primary sequence space.
+//
+// The audio packets carry our payload types of Opus and RED instead of the ones that the publisher
+// negotiated, so that the subscriptions can tell RED packets apart from the plain Opus ones.
 func (t *RemoteTrack) ReadPacket() (*rtp.Packet, error) {
 	packet, _, err := t.Track.ReadRTP()
-	return packet, err
+	if err != nil || t.Track.Kind() != webrtc.RTPCodecTypeAudio {
+		return packet, err
+	}
+
+	// Pion updates the codec of the track with each packet whose payload type differs from the previous one.
+	switch codec := t.Track.Codec(); {
+	case strings.EqualFold(codec.MimeType, webrtc_ext.MimeTypeRED):
+		packet.PayloadType = uint8(webrtc_ext.PayloadTypeRED)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus):
+		packet.PayloadType = uint8(webrtc_ext.PayloadTypeOpus)
+	}
+
+	return packet, nil
 }
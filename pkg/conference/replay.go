@@ -0,0 +1,177 @@
+package conference
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Replays a capture against a new conference. The first message of the capture must be the invite
+// that started the conference. The messages are handed to the main loop of the conference one by
+// one, so that they are processed in exactly the captured order, either as fast as possible or
+// (if `realtime` is set) with the captured delays. Once all messages are replayed, the conference
+// is ended unless it ended on its own. Returns a channel that is closed once the conference ends.
+//
+// Only the signaling is captured, the messages of the peer connections (ICE and connection state
+// changes, published tracks, key frame requests etc) are not. During the replay, such messages come
+// from the new peer connections that have no remote side, so they arrive at different times or not at
+// all, and they interleave with the replayed messages differently than in the captured conference. The
+// replay is therefore only deterministic as long as the conference does not depend on them, e.g. the
+// handling of the invites, the hangups and the data channel messages of the known participants.
+func Replay(
+	capture *Capture,
+	config Config,
+	peerConnectionFactory *webrtc_ext.PeerConnectionFactory,
+	signaler signaling.MatrixSignaler,
+	realtime bool,
+) (<-chan struct{}, error) {
+	if len(capture.Messages) == 0 {
+		return nil, fmt.Errorf("%w: no messages", ErrInvalidCapture)
+	}
+
+	first, err := capture.Messages[0].matrixMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	invite, ok := first.Content.(*event.CallInviteEventContent)
+	if !ok {
+		return nil, fmt.Errorf("%w: the first message is not an invite", ErrInvalidCapture)
+	}
+
+	// Validate the whole capture before starting the conference.
+	requests := make([]AdminRequest, 0, len(capture.Messages)-1)
+	for _, captured := range capture.Messages[1:] {
+		request, err := captured.replayRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		requests = append(requests, request)
+	}
+
+	// The replay must not be captured again.
+	config.Capture = CaptureConfig{}
+
+	adminRequests := make(chan AdminRequest)
+	done, err := StartConference(
+		capture.Header.ConferenceID,
+		id.RoomID(capture.Header.RoomID),
+		config,
+		peerConnectionFactory,
+		signaler,
+		make(chan MatrixMessage),
+		adminRequests,
+		first.Sender.UserID,
+		invite,
+		bandwidth.NewBudget(bandwidth.Config{}, func() uint64 { return 0 }),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		startedAt := time.Now()
+		for i, request := range requests {
+			if realtime {
+				offset := capture.Messages[i+1].Offset - capture.Messages[0].Offset
+				time.Sleep(time.Until(startedAt.Add(offset)))
+			}
+
+			select {
+			case adminRequests <- request:
+			case <-done:
+				return
+			}
+		}
+
+		select {
+		case adminRequests <- func(c *Conference) { c.End(CallHangupConferenceEnded) }:
+		case <-done:
+		}
+	}()
+
+	return done, nil
+}
+
+// Converts a captured message into a request that processes it on the main loop of the conference.
+func (m CapturedMessage) replayRequest() (AdminRequest, error) {
+	switch m.Source {
+	case CaptureSourceMatrix:
+		msg, err := m.matrixMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(c *Conference) { c.processMatrixMessage(msg) }, nil
+	case CaptureSourceDataChannel:
+		sender, msg := m.sender(), peer.DataChannelMessage{Message: m.Message}
+		return func(c *Conference) { c.processDataChannelMessage(sender, msg) }, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown source %q", ErrInvalidCapture, m.Source)
+	}
+}
+
+// A fake Matrix signaler that remembers the messages that the conference sends instead of sending them.
+type ReplaySignaler struct {
+	deviceID id.DeviceID
+	mutex    sync.Mutex
+	sent     []signaling.MatrixMessage
+	// Called upon each sent message, if set.
+	OnSend func(signaling.MatrixMessage)
+	// Returns the power levels of the room, if set. The power levels are not available otherwise.
+	OnGetPowerLevels func(id.RoomID) (*event.PowerLevelsEventContent, error)
+}
+
+// Creates a fake signaler for the SFU with a given device (see `CaptureHeader.DeviceID`).
+func NewReplaySignaler(deviceID id.DeviceID) *ReplaySignaler {
+	return &ReplaySignaler{deviceID: deviceID}
+}
+
+func (s *ReplaySignaler) SendMessage(msg signaling.MatrixMessage) error {
+	s.mutex.Lock()
+	s.sent = append(s.sent, msg)
+	onSend := s.OnSend
+	s.mutex.Unlock()
+
+	if onSend != nil {
+		onSend(msg)
+	}
+
+	return nil
+}
+
+func (s *ReplaySignaler) DeviceID() id.DeviceID {
+	return s.deviceID
+}
+
+// The power levels are not captured, so the roles are resolved without them (unless `OnGetPowerLevels` is set).
+func (s *ReplaySignaler) GetPowerLevels(roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	s.mutex.Lock()
+	onGetPowerLevels := s.OnGetPowerLevels
+	s.mutex.Unlock()
+
+	if onGetPowerLevels != nil {
+		return onGetPowerLevels(roomID)
+	}
+
+	return nil, fmt.Errorf("power levels of %s are not available during the replay", roomID)
+}
+
+// Returns the messages sent so far.
+func (s *ReplaySignaler) Sent() []signaling.MatrixMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]signaling.MatrixMessage{}, s.sent...)
+}
+
+var _ signaling.MatrixSignaler = (*ReplaySignaler)(nil)
@@ -0,0 +1,129 @@
+package conference //nolint:testpackage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestCaptureReplay(t *testing.T) {
+	sender := participant.ID{UserID: "@alice:example.org", DeviceID: "ALICE", CallID: "call"}
+	base := event.BaseCallEventContent{
+		CallID:          sender.CallID,
+		ConfID:          "conf",
+		DeviceID:        sender.DeviceID,
+		SenderSessionID: "session",
+		Version:         "1",
+	}
+
+	// Capture a short call: the invite, a data channel message and the hangup.
+	directory := t.TempDir()
+	recorder, err := newCaptureRecorder(CaptureConfig{Directory: directory}, CaptureHeader{
+		ConferenceID: "conf",
+		DeviceID:     "SFU",
+		StartedAt:    time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.recordMatrixMessage(MatrixMessage{Sender: sender, Content: &event.CallInviteEventContent{
+		BaseCallEventContent: base,
+		Offer:                event.CallData{Type: event.CallDataTypeOffer, SDP: createOffer(t)},
+	}})
+	recorder.recordDataChannelMessage(sender, `{"type":"m.call.pong","content":{}}`)
+	recorder.recordMatrixMessage(MatrixMessage{Sender: sender, Content: &event.CallHangupEventContent{
+		BaseCallEventContent: base,
+		Reason:               event.CallHangupUserHangup,
+	}})
+	recorder.close()
+
+	files, _ := filepath.Glob(filepath.Join(directory, "*.capture.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("expected a single capture, got %v", files)
+	}
+
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	capture, err := ReadCapture(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if capture.Header.ConferenceID != "conf" || len(capture.Messages) != 3 {
+		t.Fatalf("unexpected capture: %+v", capture)
+	}
+
+	factory, err := webrtc_ext.NewPeerConnectionFactory(webrtc_ext.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{HeartbeatConfig: Heartbeat{Timeout: 30, Interval: 30}}
+	signaler := NewReplaySignaler("SFU")
+
+	done, err := Replay(capture, config, factory, signaler, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The conference ends on its own, since the only participant hangs up.
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the conference did not end")
+	}
+
+	answered := false
+	for _, msg := range signaler.Sent() {
+		if _, ok := msg.Message.(signaling.SdpAnswer); ok && msg.Recipient.DeviceID == sender.DeviceID {
+			answered = true
+		}
+	}
+
+	if !answered {
+		t.Errorf("expected an SDP answer, got %+v", signaler.Sent())
+	}
+}
+
+func TestReadCaptureRejectsGarbage(t *testing.T) {
+	header, _ := json.Marshal(CaptureHeader{ConferenceID: "conf"})
+	if _, err := ReadCapture(strings.NewReader(string(header) + "\nnot json\n")); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// Creates an SDP offer of a client with a data channel.
+func createOffer(t *testing.T) string {
+	t.Helper()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerConnection.Close()
+
+	if _, err := peerConnection.CreateDataChannel("data", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return offer.SDP
+}
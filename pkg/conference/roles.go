@@ -0,0 +1,196 @@
+package conference
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// How long we use the fetched power levels before fetching them again.
+const powerLevelsCacheDuration = 30 * time.Second
+
+// Sent over the data channel by a moderator to remove a participant from the conference.
+var FocusCallKickParticipant = event.Type{Type: "m.call.kick_participant", Class: event.FocusEventType}
+
+type FocusCallKickParticipantEventContent struct {
+	// The user who is kicked.
+	UserID id.UserID `json:"user_id"`
+	// The device that is kicked, all devices of the user if empty.
+	DeviceID id.DeviceID `json:"device_id,omitempty"`
+}
+
+// The hangup reason that the kicked participants get.
+const CallHangupKicked event.CallHangupReason = "kicked"
+
+// Power levels of the room that the conference belongs to, fetched in the background and cached for a while.
+// The invites of the users whose roles depend on the power levels wait until the power levels are known.
+type powerLevelsCache struct {
+	signaling   signaling.MatrixSignaler
+	roomID      id.RoomID
+	powerLevels *event.PowerLevelsEventContent
+	err         error
+	fetchedAt   time.Time
+	// The result of the fetch in progress, nil if there is none.
+	fetched chan powerLevelsResult
+	// The messages of the users who wait for the power levels, in the order of arrival.
+	pending []MatrixMessage
+}
+
+type powerLevelsResult struct {
+	powerLevels *event.PowerLevelsEventContent
+	err         error
+}
+
+// Returns true if the power levels (or the failure to get them) were fetched recently.
+func (c *powerLevelsCache) fresh() bool {
+	return !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < powerLevelsCacheDuration
+}
+
+// Starts fetching the power levels unless they are being fetched already.
+func (c *powerLevelsCache) fetch() {
+	if c.fetched != nil {
+		return
+	}
+
+	// The channel is buffered, so that the fetch ends even if the conference is over by then.
+	fetched := make(chan powerLevelsResult, 1)
+	c.fetched = fetched
+
+	go func(signaling signaling.MatrixSignaler, roomID id.RoomID) {
+		powerLevels, err := signaling.GetPowerLevels(roomID)
+		fetched <- powerLevelsResult{powerLevels, err}
+	}(c.signaling, c.roomID)
+}
+
+// Stores the fetched power levels and returns the messages that waited for them.
+func (c *powerLevelsCache) update(result powerLevelsResult) []MatrixMessage {
+	c.powerLevels, c.err, c.fetchedAt, c.fetched = result.powerLevels, result.err, time.Now(), nil
+
+	pending := c.pending
+	c.pending = nil
+
+	return pending
+}
+
+// Returns true if the messages of a given participant wait for the power levels.
+func (c *powerLevelsCache) isPending(participantID participant.ID) bool {
+	for _, msg := range c.pending {
+		if msg.Sender == participantID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true if the role of a new participant depends on the power levels that are not known yet.
+// The power levels are fetched then and the invite must wait for them.
+func (c *Conference) awaitPowerLevels(userID id.UserID) bool {
+	if _, found := c.configuredRole(userID); found || c.config.Roles.PowerLevels == nil {
+		return false
+	}
+
+	if c.powerLevels.roomID == "" || c.powerLevels.fresh() {
+		return false
+	}
+
+	c.powerLevels.fetch()
+	return true
+}
+
+// Handles the messages that waited for the power levels.
+func (c *Conference) processPowerLevels(result powerLevelsResult) {
+	if result.err != nil {
+		c.logger.WithError(result.err).Warn("Failed to get power levels, using the default role")
+	}
+
+	for _, msg := range c.powerLevels.update(result) {
+		c.handleMatrixMessage(msg)
+	}
+}
+
+// Assigns the role to a user who joins the conference according to the configured policy.
+func (c *Conference) resolveRole(userID id.UserID) participant.Role {
+	if role, found := c.configuredRole(userID); found {
+		return role
+	}
+
+	if levels := c.config.Roles.PowerLevels; levels != nil && c.powerLevels.fresh() && c.powerLevels.err == nil {
+		switch level := c.powerLevels.powerLevels.GetUserLevel(userID); {
+		case level >= levels.Moderator:
+			return participant.RoleModerator
+		case level >= levels.Publisher:
+			return participant.RolePublisher
+		default:
+			return participant.RoleViewer
+		}
+	}
+
+	// The configuration is validated on start, so the error is not possible here.
+	role, _ := participant.ParseRole(c.config.Roles.Default)
+	return role
+}
+
+// Returns the role that the configuration assigns to a given user explicitly.
+func (c *Conference) configuredRole(userID id.UserID) (participant.Role, bool) {
+	switch {
+	case containsUser(c.config.Moderators, userID):
+		return participant.RoleModerator, true
+	case containsUser(c.config.Roles.Publishers, userID):
+		return participant.RolePublisher, true
+	case containsUser(c.config.Roles.Viewers, userID):
+		return participant.RoleViewer, true
+	default:
+		return participant.RolePublisher, false
+	}
+}
+
+func (c *Conference) processKickParticipantMessage(p *participant.Participant, focusEvent event.Event) {
+	var msg FocusCallKickParticipantEventContent
+	if err := json.Unmarshal(focusEvent.Content.VeryRaw, &msg); err != nil {
+		p.Logger.WithError(err).Error("Failed to unmarshal kick participant message")
+		return
+	}
+
+	if !p.Role.CanModerate() {
+		p.Logger.WithField("target", msg.UserID).Warn("Ignoring kick request from a non-moderator")
+		return
+	}
+
+	// The moderators can't kick each other.
+	protected := false
+	c.tracker.ForEachParticipant(func(id participant.ID, target *participant.Participant) {
+		if id.UserID == msg.UserID && (msg.DeviceID == "" || id.DeviceID == msg.DeviceID) {
+			protected = protected || !p.Role.Outranks(target.Role)
+		}
+	})
+
+	if protected {
+		p.Logger.WithField("target", msg.UserID).Warn("Ignoring kick request for a participant of equal or higher role")
+		return
+	}
+
+	c.logger.WithFields(logrus.Fields{
+		"moderator": p.ID.UserID,
+		"target":    msg.UserID,
+	}).Info("Kick requested by moderator")
+
+	if c.KickParticipants(msg.UserID, msg.DeviceID) == 0 {
+		p.Logger.WithField("target", msg.UserID).Warn("Participant to kick not found")
+	}
+}
+
+func containsUser(users []id.UserID, userID id.UserID) bool {
+	for _, user := range users {
+		if user == userID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,45 @@
+package conference
+
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/event"
+)
+
+// Sent over the data channel to the owner of a simulcast track to tell which layers it should
+// send. The layers that no subscriber uses are paused to save the uplink bandwidth and the CPU.
+var FocusCallSimulcastLayers = event.Type{Type: "m.call.simulcast_layers", Class: event.FocusEventType}
+
+type FocusCallSimulcastLayersEventContent struct {
+	StreamID string `json:"stream_id"`
+	TrackID  string `json:"track_id"`
+	// RIDs of the layers mapped to whether the layer should be sent (`true`) or paused (`false`).
+	Layers map[string]bool `json:"layers"`
+}
+
+func (c *Conference) processPublishedTrackLayersMessage(msg participant.TrackLayersMessage) {
+	if p := c.getParticipant(msg.OwnerID); p != nil {
+		c.sendRequestedLayers(p, msg.Info, msg.Layers)
+	}
+}
+
+func (c *Conference) sendRequestedLayers(
+	p *participant.Participant,
+	info webrtc_ext.TrackInfo,
+	layers map[string]bool,
+) {
+	layersEvent := event.Event{
+		Type: FocusCallSimulcastLayers,
+		Content: event.Content{
+			Parsed: FocusCallSimulcastLayersEventContent{
+				StreamID: info.StreamID,
+				TrackID:  info.TrackID,
+				Layers:   layers,
+			},
+		},
+	}
+
+	if err := p.SendOverDataChannel(layersEvent); err != nil {
+		p.Logger.WithError(err).Debug("Failed to send requested simulcast layers")
+	}
+}
@@ -18,12 +18,17 @@ package conference
 
 import (
 	"context"
+	"time"
 
+	"github.com/matrix-org/waterfall/pkg/audit"
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
@@ -35,15 +40,42 @@ import (
 // The conference ends when the last participant leaves.
 func StartConference(
 	confID string,
+	roomID id.RoomID,
 	config Config,
 	peerConnectionFactory *webrtc_ext.PeerConnectionFactory,
 	signaling signaling.MatrixSignaler,
 	matrixEvents <-chan MatrixMessage,
+	adminRequests <-chan AdminRequest,
 	userID id.UserID,
 	inviteEvent *event.CallInviteEventContent,
+	budget *bandwidth.Budget,
+	webhooks *webhook.Notifier,
 ) (<-chan struct{}, error) {
 	signalDone := make(chan struct{})
-	tracker, publishedTrackStopped := participant.NewParticipantTracker(signalDone)
+	tracker, trackerEvents := participant.NewParticipantTracker(
+		signalDone,
+		time.Duration(config.KeyFrameRequestInterval)*time.Millisecond,
+		participant.Limits{
+			MaxPublishedTracks: config.Limits.MaxPublishedTracks,
+			MaxSubscriptions:   config.Limits.MaxSubscriptions,
+		},
+		budget,
+	)
+
+	auditLog, err := audit.Open(config.Audit, confID)
+	if err != nil {
+		logrus.WithError(err).WithField("conf_id", confID).Warn("Failed to open audit log")
+	}
+
+	capture, err := newCaptureRecorder(config.Capture, CaptureHeader{
+		ConferenceID: confID,
+		RoomID:       roomID.String(),
+		DeviceID:     signaling.DeviceID().String(),
+		StartedAt:    time.Now(),
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("conf_id", confID).Warn("Failed to create capture")
+	}
 
 	telemetry := telemetry.NewTelemetry(
 		context.Background(),
@@ -52,25 +84,41 @@ func StartConference(
 	)
 
 	conference := &Conference{
-		id:                    confID,
-		config:                config,
-		connectionFactory:     peerConnectionFactory,
-		logger:                logrus.WithFields(logrus.Fields{"conf_id": confID}),
-		telemetry:             telemetry,
-		matrixWorker:          newMatrixWorker(signaling),
-		tracker:               tracker,
-		streamsMetadata:       make(event.CallSDPStreamMetadata),
-		peerMessages:          make(chan channel.Message[participant.ID, peer.MessageContent], 100),
-		matrixEvents:          matrixEvents,
-		publishedTrackStopped: publishedTrackStopped,
+		id:                       confID,
+		config:                   config,
+		connectionFactory:        peerConnectionFactory,
+		logger:                   logrus.WithFields(logrus.Fields{"conf_id": confID}),
+		telemetry:                telemetry,
+		matrixWorker:             newMatrixWorker(signaling),
+		webhooks:                 webhooks,
+		audit:                    auditLog,
+		capture:                  capture,
+		powerLevels:              powerLevelsCache{signaling: signaling, roomID: roomID},
+		tracker:                  tracker,
+		streamsMetadata:          make(event.CallSDPStreamMetadata),
+		peerMessages:             make(chan channel.Message[participant.ID, peer.MessageContent], 100),
+		matrixEvents:             matrixEvents,
+		adminRequests:            adminRequests,
+		publishedTrackStopped:    trackerEvents.PublishedTrackStopped,
+		publishedTrackStatus:     trackerEvents.PublishedTrackStatus,
+		publishedTrackLayers:     trackerEvents.PublishedTrackLayers,
+		publishedTrackResolution: trackerEvents.PublishedTrackResolution,
+		subscriptionLayer:        trackerEvents.SubscriptionLayer,
 	}
 
 	participantID := participant.ID{UserID: userID, DeviceID: inviteEvent.DeviceID, CallID: inviteEvent.CallID}
+	invite := MatrixMessage{Sender: participantID, Content: inviteEvent}
+	conference.auditMatrixMessage(invite)
+	conference.capture.recordMatrixMessage(invite)
 	if err := conference.onNewParticipant(participantID, inviteEvent); err != nil {
-		return nil, nil
+		conference.audit.Close()
+		conference.capture.close()
+		return nil, err
 	}
 
 	// Start conference "main loop".
+	metrics.Conferences.Inc()
+	conference.notify(webhook.Event{Type: webhook.ConferenceCreated})
 	go conference.processMessages(signalDone)
 
 	return signalDone, nil
@@ -1,11 +1,13 @@
 package conference
 
 import (
+	"github.com/matrix-org/waterfall/pkg/audit"
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	published "github.com/matrix-org/waterfall/pkg/conference/track"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/event"
@@ -21,13 +23,25 @@ type Conference struct {
 
 	connectionFactory *webrtc_ext.PeerConnectionFactory
 	matrixWorker      *matrixWorker
+	webhooks          *webhook.Notifier
+	// The timeline of the conference (nil if not configured).
+	audit *audit.Log
+	// The inbound signaling of the conference (nil if the capture mode is not enabled).
+	capture *captureRecorder
+	// Power levels of the room that the conference belongs to, if the room is known.
+	powerLevels powerLevelsCache
 
 	tracker         *participant.Tracker
 	streamsMetadata event.CallSDPStreamMetadata
 
-	peerMessages          chan channel.Message[participant.ID, peer.MessageContent]
-	matrixEvents          <-chan MatrixMessage
-	publishedTrackStopped <-chan participant.TrackStoppedMessage
+	peerMessages             chan channel.Message[participant.ID, peer.MessageContent]
+	matrixEvents             <-chan MatrixMessage
+	adminRequests            <-chan AdminRequest
+	publishedTrackStopped    <-chan participant.TrackStoppedMessage
+	publishedTrackStatus     <-chan participant.TrackStatusMessage
+	publishedTrackLayers     <-chan participant.TrackLayersMessage
+	publishedTrackResolution <-chan participant.TrackResolutionMessage
+	subscriptionLayer        <-chan participant.SubscriptionLayerMessage
 }
 
 func (c *Conference) getParticipant(id participant.ID) *participant.Participant {
@@ -40,7 +54,23 @@ func (c *Conference) getParticipant(id participant.ID) *participant.Participant
 }
 
 // Helper to terminate and remove a participant from the conference.
-func (c *Conference) removeParticipant(id participant.ID) {
+func (c *Conference) removeParticipant(id participant.ID, reason event.CallHangupReason) {
+	p := c.tracker.GetParticipant(id)
+	if p == nil {
+		return
+	}
+
+	// The tracks of the participant are removed along with it.
+	c.tracker.ForEachPublishedTrackInfo(func(owner participant.ID, info webrtc_ext.TrackInfo) {
+		if owner == id {
+			c.notifyTrack(webhook.TrackRemoved, owner, info)
+		}
+	})
+	// The participants that never joined the call were never announced.
+	if p.Joined {
+		c.notifyParticipant(webhook.ParticipantLeft, id, string(reason))
+	}
+
 	// Remove the participant and then remove its streams from the map.
 	for streamID := range c.tracker.RemoveParticipant(id) {
 		delete(c.streamsMetadata, streamID)
@@ -61,18 +91,20 @@ func (c *Conference) getAvailableStreamsFor(forParticipant participant.ID) event
 		// Skip us. As we know about our own tracks.
 		if owner != forParticipant {
 			streamID := info.StreamID
-			kind := info.Kind.String()
+			// The resolution that we see in the bitstream is more reliable than the one the owner told us.
+			width, height := c.tracker.GetPublishedTrackResolution(info.TrackID)
+			track := event.CallSDPStreamMetadataTrack{
+				Kind:   info.Kind.String(),
+				Width:  width,
+				Height: height,
+			}
 
 			if metadata, ok := streamsMetadata[streamID]; ok {
-				metadata.Tracks[info.TrackID] = event.CallSDPStreamMetadataTrack{
-					Kind: kind,
-				}
+				metadata.Tracks[info.TrackID] = track
 				streamsMetadata[streamID] = metadata
 			} else if metadata, ok := c.streamsMetadata[streamID]; ok {
 				metadata.Tracks = event.CallSDPStreamMetadataTracks{
-					info.TrackID: event.CallSDPStreamMetadataTrack{
-						Kind: kind,
-					},
+					info.TrackID: track,
 				}
 				streamsMetadata[streamID] = metadata
 			} else {
@@ -104,8 +136,19 @@ func (c *Conference) resendMetadataToAllExcept(exceptMe participant.ID) {
 	})
 }
 
+// The resolution of a published track changed, so the subscribers get the updated metadata.
+func (c *Conference) processPublishedTrackResolutionMessage(msg participant.TrackResolutionMessage) {
+	c.newLogger(msg.OwnerID).WithField("track", msg.Info.TrackID).Debug("Published track resolution changed")
+	c.resendMetadataToAllExcept(msg.OwnerID)
+}
+
 // Helper that updates the metadata each time the metadata is received.
-func (c *Conference) updateMetadata(metadata event.CallSDPStreamMetadata) {
+func (c *Conference) updateMetadata(p *participant.Participant, metadata event.CallSDPStreamMetadata) {
+	// The viewers don't publish anything, their metadata could only spoof the streams of the others.
+	if !p.Role.CanPublish() {
+		return
+	}
+
 	// Note that this assumes that the stream IDs are unique, which is not always so!
 	// Yet, our previous implementation of the SFU has always combined the metadata for all available
 	// streams when notifying other participants in a call about any changes, so it implicitly expected
@@ -117,6 +160,7 @@ func (c *Conference) updateMetadata(metadata event.CallSDPStreamMetadata) {
 
 	for trackID, metadata := range streamIntoTrackMetadata(metadata) {
 		c.tracker.UpdatePublishedTrackMetadata(trackID, metadata)
+		c.applySubscriptionRules(c.tracker.RuleSubscriptionsTo(trackID))
 	}
 }
 
@@ -139,6 +183,7 @@ func streamIntoTrackMetadata(
 				MaxWidth:  track.Width,
 				MaxHeight: track.Height,
 				Muted:     muted,
+				Purpose:   string(metadata.Purpose),
 			}
 		}
 	}
@@ -4,51 +4,218 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sync/atomic"
+	"time"
 
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/red"
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/matrix-org/waterfall/pkg/worker"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Packet loss thresholds (fraction of 256 as in the RTCP receiver reports) after which
+// we start sending redundant audio (RED) to the subscriber.
+const (
+	// ~2% of loss, one redundant frame per packet.
+	lossThresholdLow = 5
+	// ~10% of loss, two redundant frames per packet.
+	lossThresholdHigh = 26
 )
 
 type AudioSubscription struct {
+	track      *audioTrack
 	sender     *webrtc.RTPSender
 	controller SubscriptionController
+	worker     *worker.Worker[rtp.Packet]
+	stopped    atomic.Bool
+
+	// Set if the audio must not be forwarded to the subscriber (muted on the server side).
+	muted atomic.Bool
+	// Smoothed packet loss (fraction of 256) reported by the subscriber.
+	loss atomic.Uint32
+	// The amount of redundant frames that we currently send to the subscriber.
+	redundancy atomic.Int32
+	// Frames forwarded to the subscriber.
+	forwarded forwardingStats
+
+	logger *logrus.Entry
 }
 
+// Creates a new audio subscription. Each subscription has its own local track and a worker
+// that rewrites the packets before sending them to the subscriber.
 func NewAudioSubscription(
-	outputTrack *webrtc.TrackLocalStaticRTP,
+	info webrtc_ext.TrackInfo,
 	controller SubscriptionController,
+	logger *logrus.Entry,
 ) (*AudioSubscription, error) {
-	if outputTrack == nil {
-		return nil, fmt.Errorf("Output track is nil")
-	}
+	track := newAudioTrack(info.TrackID, info.StreamID)
 
-	sender, err := controller.AddTrack(outputTrack)
+	sender, err := controller.AddTrack(track)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to add track: %s", err)
 	}
 
-	subscription := &AudioSubscription{sender, controller}
+	subscription := &AudioSubscription{
+		track:      track,
+		sender:     sender,
+		controller: controller,
+		logger:     logger,
+	}
+
+	// Create a worker state.
+	workerState := audioWorkerState{
+		subscription:   subscription,
+		packetRewriter: rewriter.NewPacketRewriter(),
+	}
+
+	// Configure the worker for the subscription.
+	workerConfig := worker.Config[rtp.Packet]{
+		ChannelSize: 16, // We really don't need a large buffer here, just to account for spikes.
+		Timeout:     1 * time.Hour,
+		OnTimeout:   func() {},
+		OnTask:      workerState.handlePacket,
+	}
+
+	// Start a worker for the subscription.
+	subscription.worker = worker.StartWorker(workerConfig)
+
 	go subscription.readRTCP()
+	metrics.Subscriptions.With("audio").Inc()
 
 	return subscription, nil
 }
 
 func (s *AudioSubscription) Unsubscribe() error {
+	if !s.stopped.CompareAndSwap(false, true) {
+		return fmt.Errorf("Already stopped")
+	}
+
+	s.worker.Stop()
+	metrics.Subscriptions.With("audio").Dec()
+	s.logger.Info("Unsubscribed")
 	return s.controller.RemoveTrack(s.sender)
 }
 
 func (s *AudioSubscription) WriteRTP(packet rtp.Packet) error {
-	return fmt.Errorf("Bug: no write RTP logic for an audio subscription!")
+	// Send the packet to the worker.
+	err := s.worker.Send(packet)
+	if errors.Is(err, worker.ErrWorkerTooBusy) {
+		metrics.WorkerDrops.With("audio_subscription").Inc()
+	}
+
+	return err
+}
+
+// Mutes or unmutes the audio on the server side, i.e. the packets are not forwarded to the subscriber.
+func (s *AudioSubscription) SetMuted(muted bool) {
+	if previous := s.muted.Swap(muted); previous != muted {
+		s.logger.WithField("muted", muted).Info("Server-side mute changed")
+	}
+}
+
+// Returns the forwarding statistics since the previous call.
+func (s *AudioSubscription) Stats() Stats {
+	return s.forwarded.take(s.worker.QueueLength())
 }
 
 func (s *AudioSubscription) readRTCP() {
 	// Read incoming RTCP packets. Before these packets are returned they are processed by interceptors.
 	// For things like NACK this needs to be called.
 	for {
-		if _, _, err := s.sender.ReadRTCP(); err != nil {
+		packets, _, err := s.sender.ReadRTCP()
+		if err != nil {
 			if errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.EOF) {
 				return
 			}
 		}
+
+		// We're only interested in the receiver reports to learn about the packet loss.
+		for _, packet := range packets {
+			if report, ok := packet.(*rtcp.ReceiverReport); ok {
+				s.processReceiverReport(report)
+			}
+		}
+	}
+}
+
+// Updates the packet loss of the subscriber and adjusts the redundancy accordingly.
+func (s *AudioSubscription) processReceiverReport(report *rtcp.ReceiverReport) {
+	if !s.track.supportsRED() {
+		return
+	}
+
+	encodings := s.sender.GetParameters().Encodings
+	if len(encodings) == 0 {
+		return
+	}
+
+	ssrc := uint32(encodings[0].SSRC)
+	for _, reception := range report.Reports {
+		if reception.SSRC != ssrc {
+			continue
+		}
+
+		loss := (s.loss.Load()*3 + uint32(reception.FractionLost)) / 4
+		s.loss.Store(loss)
+
+		var redundancy int32
+		switch {
+		case loss >= lossThresholdHigh:
+			redundancy = 2
+		case loss >= lossThresholdLow:
+			redundancy = 1
+		}
+
+		if previous := s.redundancy.Swap(redundancy); previous != redundancy {
+			s.logger.WithField("loss", float32(loss)/256).Infof("Audio redundancy changed to %d", redundancy)
+		}
 	}
 }
+
+// Internal state of a worker that runs in its own goroutine.
+type audioWorkerState struct {
+	subscription *AudioSubscription
+	// Rewriter of the packet IDs.
+	packetRewriter *rewriter.PacketRewriter
+}
+
+func (w *audioWorkerState) handlePacket(packet rtp.Packet) {
+	// Muted packets are dropped, but the rewriter must know about them to avoid the gaps.
+	if w.subscription.muted.Load() {
+		w.packetRewriter.DropIncoming(packet)
+		return
+	}
+
+	// The publisher may switch between RED and plain Opus at any time, so it's decided for each packet.
+	// The redundancy of the publisher is forwarded to the subscribers that negotiated RED, the other
+	// subscribers get the primary frame, to which we add our own redundancy if they need it.
+	var (
+		opus     = packet.Payload
+		incoming []red.Block
+		isRED    = packet.PayloadType == uint8(webrtc_ext.PayloadTypeRED)
+	)
+
+	if isRED {
+		blocks, primary, err := red.Decode(packet.Payload)
+		if err != nil {
+			w.subscription.logger.WithError(err).Debug("Dropping malformed RED packet")
+			return
+		}
+
+		opus, incoming = primary, blocks
+	}
+
+	rewritten := w.packetRewriter.ProcessIncoming(packet)
+	redundancy := int(w.subscription.redundancy.Load())
+	if err := w.subscription.track.writeFrame(rewritten.Header, opus, incoming, isRED, redundancy); err != nil {
+		w.subscription.logger.WithError(err).Debug("Failed to write audio frame")
+		return
+	}
+
+	w.subscription.forwarded.add(rewritten.Header.MarshalSize() + len(opus))
+}
@@ -0,0 +1,159 @@
+package subscription
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/red"
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// A local audio track of a single subscriber. Unlike `webrtc.TrackLocalStaticRTP`, it remembers
+// both the Opus and the RED (RFC 2198) payload types if the subscriber negotiated RED, so that
+// the redundancy could be decided upon for each packet depending on the subscriber's link quality.
+type audioTrack struct {
+	id       string
+	streamID string
+
+	mutex    sync.Mutex
+	bindings []audioBinding
+	history  *red.History
+}
+
+type audioBinding struct {
+	id          string
+	ssrc        webrtc.SSRC
+	writeStream webrtc.TrackLocalWriter
+	// Payload type of Opus.
+	opusPayloadType uint8
+	// Payload type of RED, only valid if `hasRED` is set.
+	redPayloadType uint8
+	hasRED         bool
+}
+
+func newAudioTrack(id, streamID string) *audioTrack {
+	return &audioTrack{id: id, streamID: streamID, history: red.NewHistory()}
+}
+
+// Implementation of `webrtc.TrackLocal`.
+func (t *audioTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	var (
+		opus, redundant     webrtc.RTPCodecParameters
+		foundOpus, foundRED bool
+	)
+
+	for _, codec := range ctx.CodecParameters() {
+		switch {
+		case strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus) && !foundOpus:
+			opus, foundOpus = codec, true
+		case strings.EqualFold(codec.MimeType, webrtc_ext.MimeTypeRED) && !foundRED:
+			redundant, foundRED = codec, true
+		}
+	}
+
+	if !foundOpus {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.bindings = append(t.bindings, audioBinding{
+		id:              ctx.ID(),
+		ssrc:            ctx.SSRC(),
+		writeStream:     ctx.WriteStream(),
+		opusPayloadType: uint8(opus.PayloadType),
+		redPayloadType:  uint8(redundant.PayloadType),
+		hasRED:          foundRED,
+	})
+
+	return opus, nil
+}
+
+// Implementation of `webrtc.TrackLocal`.
+func (t *audioTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for i := range t.bindings {
+		if t.bindings[i].id == ctx.ID() {
+			t.bindings = append(t.bindings[:i], t.bindings[i+1:]...)
+			return nil
+		}
+	}
+
+	return webrtc.ErrUnbindFailed
+}
+
+// Implementation of `webrtc.TrackLocal`.
+func (t *audioTrack) ID() string { return t.id }
+
+// Implementation of `webrtc.TrackLocal`.
+func (t *audioTrack) RID() string { return "" }
+
+// Implementation of `webrtc.TrackLocal`.
+func (t *audioTrack) StreamID() string { return t.streamID }
+
+// Implementation of `webrtc.TrackLocal`.
+func (t *audioTrack) Kind() webrtc.RTPCodecType { return webrtc.RTPCodecTypeAudio }
+
+// Returns `true` if at least one of the bindings negotiated RED.
+func (t *audioTrack) supportsRED() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, binding := range t.bindings {
+		if binding.hasRED {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writes a single Opus frame. If the publisher sent the frame as RED (`isRED`), the subscribers that
+// negotiated RED get the redundant blocks of the publisher (`incoming`) as they are. Otherwise, if
+// `redundancy` is greater than zero and the subscriber negotiated RED, the frame is sent as a RED
+// packet along with up to `redundancy` previous frames.
+func (t *audioTrack) writeFrame(
+	header rtp.Header,
+	opus []byte,
+	incoming []red.Block,
+	isRED bool,
+	redundancy int,
+) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	blocks := incoming
+	if !isRED {
+		blocks = t.history.Redundancy(header.Timestamp, redundancy)
+	}
+	t.history.Remember(header.Timestamp, opus)
+
+	var writeErr error
+	for _, binding := range t.bindings {
+		outgoing := header
+		outgoing.SSRC = uint32(binding.ssrc)
+		outgoing.PayloadType = binding.opusPayloadType
+		payload := opus
+
+		if binding.hasRED && (isRED || redundancy > 0) {
+			outgoing.PayloadType = binding.redPayloadType
+			payload = red.Encode(binding.opusPayloadType, blocks, opus)
+		}
+
+		if _, err := binding.writeStream.WriteRTP(&outgoing, payload); err != nil {
+			writeErr = err
+			continue
+		}
+
+		metrics.ForwardedPackets.With("audio").Inc()
+		metrics.ForwardedBytes.With("audio").Add(float64(outgoing.MarshalSize() + len(payload)))
+	}
+
+	return writeErr
+}
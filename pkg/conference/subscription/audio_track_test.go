@@ -0,0 +1,70 @@
+package subscription //nolint:testpackage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/red"
+	"github.com/pion/rtp"
+)
+
+// Remembers the packets written to a binding of the audio track.
+type recordingWriter struct {
+	packets []rtp.Packet
+}
+
+func (w *recordingWriter) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	w.packets = append(w.packets, rtp.Packet{Header: *header, Payload: append([]byte{}, payload...)})
+	return len(payload), nil
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func TestAudioTrackForwardsIncomingRED(t *testing.T) {
+	withRED, withoutRED := &recordingWriter{}, &recordingWriter{}
+
+	track := newAudioTrack("audio", "stream")
+	track.bindings = []audioBinding{
+		{id: "red", writeStream: withRED, opusPayloadType: 111, redPayloadType: 63, hasRED: true},
+		{id: "opus", writeStream: withoutRED, opusPayloadType: 109},
+	}
+
+	// The publisher sends RED, the subscriber that supports it gets the redundancy of the publisher.
+	incoming := []red.Block{{TimestampOffset: 960, Payload: []byte{1, 2}}}
+	if err := track.writeFrame(rtp.Header{Timestamp: 1920}, []byte{3, 4}, incoming, true, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	forwarded := red.Encode(111, incoming, []byte{3, 4})
+	if packet := withRED.packets[0]; packet.PayloadType != 63 || !bytes.Equal(packet.Payload, forwarded) {
+		t.Errorf("expected the incoming RED to be forwarded, got %+v", packet)
+	}
+
+	if packet := withoutRED.packets[0]; packet.PayloadType != 109 || !bytes.Equal(packet.Payload, []byte{3, 4}) {
+		t.Errorf("expected the primary frame, got %+v", packet)
+	}
+
+	// The next packet of the publisher is plain Opus, there is no need for the redundancy.
+	if err := track.writeFrame(rtp.Header{Timestamp: 2880}, []byte{5, 6}, nil, false, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if packet := withRED.packets[1]; packet.PayloadType != 111 || !bytes.Equal(packet.Payload, []byte{5, 6}) {
+		t.Errorf("expected plain Opus, got %+v", packet)
+	}
+
+	// Our own redundancy is built from the frames that we forwarded, including the ones from RED packets.
+	if err := track.writeFrame(rtp.Header{Timestamp: 3840}, []byte{7}, nil, false, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := red.Encode(111, []red.Block{
+		{TimestampOffset: 1920, Payload: []byte{3, 4}},
+		{TimestampOffset: 960, Payload: []byte{5, 6}},
+	}, []byte{7})
+	if packet := withRED.packets[2]; packet.PayloadType != 63 || !bytes.Equal(packet.Payload, expected) {
+		t.Errorf("expected our own redundancy, got %+v", packet)
+	}
+}
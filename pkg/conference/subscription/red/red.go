@@ -0,0 +1,154 @@
+package red
+
+import (
+	"errors"
+)
+
+// The maximum amount of redundant blocks that we put into a single RED packet.
+const MaxRedundancy = 2
+
+const (
+	// Length of the header of a redundant block.
+	redundantHeaderLength = 4
+	// Length of the header of the primary block.
+	primaryHeaderLength = 1
+	// The timestamp offset is a 14 bit field.
+	maxTimestampOffset = 1<<14 - 1
+	// The block length is a 10 bit field.
+	maxBlockLength = 1<<10 - 1
+)
+
+var ErrMalformedPacket = errors.New("malformed RED packet")
+
+// A redundant block of a RED packet (RFC 2198).
+type Block struct {
+	// Offset of the block's timestamp relative to the timestamp of the RTP packet.
+	TimestampOffset uint16
+	// Encoded audio frame.
+	Payload []byte
+}
+
+// Splits the payload of a RED packet into the redundant blocks (the oldest first) and the primary block.
+func Decode(payload []byte) ([]Block, []byte, error) {
+	var (
+		redundant []Block
+		lengths   []int
+		offset    int
+	)
+
+	// Parse the headers. All headers but the last one (primary) have the F bit set.
+	for {
+		if offset >= len(payload) {
+			return nil, nil, ErrMalformedPacket
+		}
+
+		if payload[offset]&0x80 == 0 {
+			offset += primaryHeaderLength
+			break
+		}
+
+		if offset+redundantHeaderLength > len(payload) {
+			return nil, nil, ErrMalformedPacket
+		}
+
+		header := payload[offset : offset+redundantHeaderLength]
+		timestampOffset := uint16(header[1])<<6 | uint16(header[2])>>2
+		length := int(header[2]&0x03)<<8 | int(header[3])
+
+		redundant = append(redundant, Block{TimestampOffset: timestampOffset})
+		lengths = append(lengths, length)
+		offset += redundantHeaderLength
+	}
+
+	// Now the data of the blocks follows in the same order as the headers.
+	for i, length := range lengths {
+		if offset+length > len(payload) {
+			return nil, nil, ErrMalformedPacket
+		}
+
+		redundant[i].Payload = payload[offset : offset+length]
+		offset += length
+	}
+
+	return redundant, payload[offset:], nil
+}
+
+// Encodes the redundant blocks (the oldest first) and the primary block into a RED payload.
+// All blocks are assumed to be encoded with the codec that has a given payload type.
+func Encode(payloadType uint8, redundant []Block, primary []byte) []byte {
+	size := primaryHeaderLength + len(primary)
+	for _, block := range redundant {
+		size += redundantHeaderLength + len(block.Payload)
+	}
+
+	payload := make([]byte, 0, size)
+	for _, block := range redundant {
+		payload = append(payload,
+			0x80|payloadType&0x7F,
+			byte(block.TimestampOffset>>6),
+			byte(block.TimestampOffset<<2)|byte(len(block.Payload)>>8&0x03),
+			byte(len(block.Payload)),
+		)
+	}
+
+	payload = append(payload, payloadType&0x7F)
+	for _, block := range redundant {
+		payload = append(payload, block.Payload...)
+	}
+
+	return append(payload, primary...)
+}
+
+// Keeps the history of the recently sent audio frames, so that they could be
+// sent again as redundant blocks along with the new frames.
+type History struct {
+	frames []frame
+}
+
+type frame struct {
+	timestamp uint32
+	payload   []byte
+}
+
+func NewHistory() *History {
+	return &History{frames: make([]frame, 0, MaxRedundancy)}
+}
+
+// Returns up to `level` recent frames as redundant blocks (the oldest first) for a frame with a given timestamp.
+func (h *History) Redundancy(timestamp uint32, level int) []Block {
+	if level > MaxRedundancy {
+		level = MaxRedundancy
+	}
+
+	if level <= 0 {
+		return nil
+	}
+
+	redundant := make([]Block, 0, level)
+	for _, previous := range h.frames[max(0, len(h.frames)-level):] {
+		offset := timestamp - previous.timestamp
+		if offset == 0 || offset > maxTimestampOffset || len(previous.payload) > maxBlockLength {
+			continue
+		}
+
+		redundant = append(redundant, Block{TimestampOffset: uint16(offset), Payload: previous.payload})
+	}
+
+	return redundant
+}
+
+// Remembers the frame so that it can be used as a redundant block for the subsequent frames.
+func (h *History) Remember(timestamp uint32, primary []byte) {
+	if len(h.frames) == MaxRedundancy {
+		h.frames = append(h.frames[:0], h.frames[1:]...)
+	}
+
+	h.frames = append(h.frames, frame{timestamp, append([]byte(nil), primary...)})
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,54 @@
+package red_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/red"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	history := red.NewHistory()
+
+	// No history yet, so no redundancy.
+	if blocks := history.Redundancy(960, 2); len(blocks) != 0 {
+		t.Fatalf("expected no redundant blocks, got %d", len(blocks))
+	}
+
+	history.Remember(0, []byte{1})
+	history.Remember(960, []byte{2, 2})
+	history.Remember(1920, []byte{3, 3, 3})
+
+	// Only the latest frames are used, the oldest first.
+	blocks := history.Redundancy(2880, 2)
+	if len(blocks) != 2 || blocks[0].TimestampOffset != 1920 || blocks[1].TimestampOffset != 960 {
+		t.Fatalf("unexpected redundant blocks: %+v", blocks)
+	}
+
+	payload := red.Encode(111, blocks, []byte{4, 4, 4, 4})
+
+	redundant, primary, err := red.Decode(payload)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+
+	if !bytes.Equal(primary, []byte{4, 4, 4, 4}) {
+		t.Fatalf("unexpected primary block: %v", primary)
+	}
+
+	if len(redundant) != 2 ||
+		!bytes.Equal(redundant[0].Payload, []byte{2, 2}) || redundant[0].TimestampOffset != 1920 ||
+		!bytes.Equal(redundant[1].Payload, []byte{3, 3, 3}) || redundant[1].TimestampOffset != 960 {
+		t.Fatalf("unexpected redundant blocks: %+v", redundant)
+	}
+
+	// Primary only.
+	if _, primary, err := red.Decode(red.Encode(111, nil, []byte{5})); err != nil || !bytes.Equal(primary, []byte{5}) {
+		t.Fatalf("unexpected primary-only decoding: %v, %v", primary, err)
+	}
+
+	// Truncated packets must be rejected.
+	if _, _, err := red.Decode(payload[:3]); err == nil {
+		t.Fatal("expected an error for a truncated packet")
+	}
+}
@@ -38,6 +38,17 @@ func (p *PacketRewriter) ProcessIncoming(packet rtp.Packet) RewrittenRTPPacket {
 	return &packet
 }
 
+// Process new incoming packet that is not going to be forwarded (e.g. because the track is muted).
+// The subsequent packets get the sequence numbers without a gap, so that the receiver does not treat
+// the dropped packets as lost, while their timestamps still advance (like with DTX).
+func (p *PacketRewriter) DropIncoming(packet rtp.Packet) {
+	incomingIDs := TruncatedPacketIdentifiers{packet.Timestamp, packet.SequenceNumber}
+	p.state.process(packet.SSRC, incomingIDs, p.latestOutgoing)
+
+	// Shift the base, so that the next packet gets the sequence number that this one would have gotten.
+	p.state.firstOutgoing.sequenceNumber--
+}
+
 // The state of the forwarding/rewriting process for a single SSRC, i.e. a
 // single simulcast layer after a switch. This changes each time the simulcast
 // layer is switched and/or the incoming SSRC changes.
@@ -57,3 +57,45 @@ func TestRewriter(t *testing.T) {
 		}
 	}
 }
+
+func TestRewriterDrop(t *testing.T) {
+	cases := []struct {
+		seqNum         uint16
+		ts             uint32
+		drop           bool
+		expectedSeqNum uint16
+		expectedTs     uint32
+	}{
+		{100, 1000, true, 0, 0},    // first packet is dropped
+		{101, 1960, false, 0, 960}, // the first forwarded packet starts with 0
+		{102, 2920, false, 1, 1920},
+		{103, 3880, true, 0, 0},
+		{104, 4840, true, 0, 0},
+		{105, 5800, false, 2, 4800}, // no gap in sequence numbers, timestamp advances
+		{106, 6760, false, 3, 5760},
+	}
+
+	rewriter := rewriter.NewPacketRewriter()
+	packet := new(rtp.Packet)
+	packet.SSRC = 1111
+
+	for _, c := range cases {
+		packet.SequenceNumber = c.seqNum
+		packet.Timestamp = c.ts
+
+		if c.drop {
+			rewriter.DropIncoming(*packet)
+			continue
+		}
+
+		rewritten := rewriter.ProcessIncoming(*packet)
+
+		if rewritten.SequenceNumber != c.expectedSeqNum {
+			t.Fatalf("expected seqNum %d, got %d", c.expectedSeqNum, rewritten.SequenceNumber)
+		}
+
+		if rewritten.Timestamp != c.expectedTs {
+			t.Fatalf("expected ts %d, got %d", c.expectedTs, rewritten.Timestamp)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package subscription
 
 import (
+	"sync/atomic"
+
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
@@ -11,6 +13,30 @@ type Subscription interface {
 }
 
 type SubscriptionController interface {
-	AddTrack(track *webrtc.TrackLocalStaticRTP) (*webrtc.RTPSender, error)
+	AddTrack(track webrtc.TrackLocal) (*webrtc.RTPSender, error)
 	RemoveTrack(sender *webrtc.RTPSender) error
 }
+
+// Forwarding statistics of a subscription.
+type Stats struct {
+	// The number of packets and bytes forwarded since the previous call to `Stats()`.
+	Packets, Bytes uint64
+	// The number of packets waiting to be forwarded.
+	QueueDepth int
+}
+
+// Counts the forwarded packets, updated by the worker of the subscription.
+type forwardingStats struct {
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+func (s *forwardingStats) add(bytes int) {
+	s.packets.Add(1)
+	s.bytes.Add(uint64(bytes))
+}
+
+// Returns the statistics and resets the counters.
+func (s *forwardingStats) take(queueDepth int) Stats {
+	return Stats{Packets: s.packets.Swap(0), Bytes: s.bytes.Swap(0), QueueDepth: queueDepth}
+}
@@ -0,0 +1,59 @@
+package subscription
+
+import (
+	"math"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// Forward all temporal layers.
+const AllTemporalLayers = math.MaxUint8
+
+// Drops the VP8 temporal layers that the subscriber does not need (e.g. a thumbnail at a lower
+// frame rate). The frames of the higher temporal layers are not used as references by the lower
+// ones, so they can be dropped without breaking the decoding.
+type temporalFilter struct {
+	// Whether the codec carries the temporal layer index that we understand (VP8).
+	enabled bool
+	// The highest temporal layer that the subscriber wants, changed from outside of the worker.
+	target *atomic.Uint32
+	// The highest temporal layer that we currently forward.
+	current uint32
+}
+
+func newTemporalFilter(codec webrtc.RTPCodecCapability, target *atomic.Uint32) temporalFilter {
+	return temporalFilter{
+		enabled: strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8),
+		target:  target,
+		current: AllTemporalLayers,
+	}
+}
+
+// Returns `true` if the packet must be forwarded to the subscriber.
+func (f *temporalFilter) forward(packet rtp.Packet) bool {
+	if !f.enabled {
+		return true
+	}
+
+	vp8Packet := codecs.VP8Packet{}
+	if _, err := vp8Packet.Unmarshal(packet.Payload); err != nil || vp8Packet.T == 0 {
+		// No temporal layers, nothing to drop.
+		return true
+	}
+
+	// The layer is switched only at the beginning of a frame. Switching down is always possible,
+	// but switching up is only possible on a frame that does not depend on the frames of the higher
+	// layers that we have dropped, i.e. on the base layer or on a layer sync frame.
+	if vp8Packet.S == 1 && vp8Packet.PID == 0 {
+		target := f.target.Load()
+		if target < f.current || vp8Packet.TID == 0 || vp8Packet.Y == 1 {
+			f.current = target
+		}
+	}
+
+	return uint32(vp8Packet.TID) <= f.current
+}
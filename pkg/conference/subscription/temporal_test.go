@@ -0,0 +1,68 @@
+package subscription //nolint:testpackage
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Creates a VP8 packet with a given temporal layer (RFC 7741, section 4.2).
+func vp8Packet(start bool, temporalLayer uint8, layerSync bool) rtp.Packet {
+	descriptor := byte(0x80) // Extended control bits present.
+	if start {
+		descriptor |= 0x10
+	}
+
+	temporal := temporalLayer << 6
+	if layerSync {
+		temporal |= 0x20
+	}
+
+	return rtp.Packet{Payload: []byte{descriptor, 0x20, temporal, 0x00}}
+}
+
+func TestTemporalFilter(t *testing.T) {
+	target := &atomic.Uint32{}
+	target.Store(AllTemporalLayers)
+
+	filter := newTemporalFilter(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, target)
+
+	steps := []struct {
+		target   uint32
+		packet   rtp.Packet
+		expected bool
+	}{
+		{AllTemporalLayers, vp8Packet(true, 2, false), true},
+		// Switching down in the middle of a frame waits for the next frame.
+		{0, vp8Packet(false, 2, false), true},
+		{0, vp8Packet(true, 1, false), false},
+		{0, vp8Packet(false, 1, false), false},
+		{0, vp8Packet(true, 0, false), true},
+		// Switching up waits for the base layer or a layer sync frame.
+		{1, vp8Packet(true, 1, false), false},
+		{1, vp8Packet(true, 2, false), false},
+		{1, vp8Packet(true, 1, true), true},
+		{1, vp8Packet(true, 2, true), false},
+		{AllTemporalLayers, vp8Packet(true, 2, false), false},
+		{AllTemporalLayers, vp8Packet(true, 0, false), true},
+		{AllTemporalLayers, vp8Packet(true, 2, false), true},
+	}
+
+	for i, step := range steps {
+		target.Store(step.target)
+		if forwarded := filter.forward(step.packet); forwarded != step.expected {
+			t.Errorf("step %d: expected forwarded=%v, got %v", i, step.expected, forwarded)
+		}
+	}
+}
+
+func TestTemporalFilterIgnoresOtherCodecs(t *testing.T) {
+	target := &atomic.Uint32{}
+	filter := newTemporalFilter(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, target)
+
+	if !filter.forward(vp8Packet(true, 2, false)) {
+		t.Error("expected the packet of a codec without temporal layers to be forwarded")
+	}
+}
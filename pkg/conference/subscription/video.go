@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/matrix-org/waterfall/pkg/worker"
@@ -15,6 +16,7 @@ import (
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type VideoSubscription struct {
@@ -26,6 +28,13 @@ type VideoSubscription struct {
 	worker     *worker.Worker[rtp.Packet]
 	stopped    atomic.Bool
 
+	// The highest temporal layer that the subscriber wants to get.
+	maxTemporalLayer atomic.Uint32
+	// The fraction of packets lost by the subscriber (multiplied by 256) from the latest receiver report.
+	fractionLost atomic.Uint32
+	// Packets forwarded to the subscriber.
+	forwarded forwardingStats
+
 	logger    *logrus.Entry
 	telemetry *telemetry.Telemetry
 }
@@ -54,19 +63,20 @@ func NewVideoSubscription(
 
 	// Create a subscription.
 	subscription := &VideoSubscription{
-		rtpSender,
-		info,
-		controller,
-		nil,
-		atomic.Bool{},
-		logger,
-		telemetryBuilder.Create("VideoSubscription"),
+		rtpSender:  rtpSender,
+		info:       info,
+		controller: controller,
+		logger:     logger,
+		telemetry:  telemetryBuilder.Create("VideoSubscription"),
 	}
+	subscription.maxTemporalLayer.Store(AllTemporalLayers)
 
 	// Create a worker state.
 	workerState := workerState{
 		packetRewriter: rewriter.NewPacketRewriter(),
+		temporalFilter: newTemporalFilter(info.Codec, &subscription.maxTemporalLayer),
 		rtpTrack:       rtpTrack,
+		forwarded:      &subscription.forwarded,
 	}
 
 	// Configure the worker for the subscription.
@@ -82,6 +92,7 @@ func NewVideoSubscription(
 
 	// Start reading and forwarding RTCP packets goroutine.
 	ch := subscription.startReadRTCP()
+	metrics.Subscriptions.With("video").Inc()
 
 	return subscription, ch, nil
 }
@@ -92,6 +103,7 @@ func (s *VideoSubscription) Unsubscribe() error {
 	}
 
 	s.worker.Stop()
+	metrics.Subscriptions.With("video").Dec()
 	s.logger.Info("Unsubscribed")
 	s.telemetry.End()
 	return s.controller.RemoveTrack(s.rtpSender)
@@ -99,7 +111,31 @@ func (s *VideoSubscription) Unsubscribe() error {
 
 func (s *VideoSubscription) WriteRTP(packet rtp.Packet) error {
 	// Send the packet to the worker.
-	return s.worker.Send(packet)
+	err := s.worker.Send(packet)
+	if errors.Is(err, worker.ErrWorkerTooBusy) {
+		metrics.WorkerDrops.With("video_subscription").Inc()
+	}
+
+	return err
+}
+
+// Limits the temporal layers that are forwarded to the subscriber, e.g. to lower the frame rate.
+// The change takes effect on the next frame that allows switching the layer.
+func (s *VideoSubscription) SetMaxTemporalLayer(layer uint8) {
+	if previous := s.maxTemporalLayer.Swap(uint32(layer)); previous != uint32(layer) {
+		s.telemetry.AddEvent("max temporal layer changed", attribute.Int("layer", int(layer)))
+	}
+}
+
+// Returns the fraction of packets (from 0 to 1) lost on the way to the subscriber
+// according to the latest receiver report.
+func (s *VideoSubscription) PacketLoss() float64 {
+	return float64(s.fractionLost.Load()) / 256
+}
+
+// Returns the forwarding statistics since the previous call.
+func (s *VideoSubscription) Stats() Stats {
+	return s.forwarded.take(s.worker.QueueLength())
 }
 
 // Read incoming RTCP packets. Before these packets are returned they are processed by interceptors.
@@ -124,10 +160,13 @@ func (s *VideoSubscription) startReadRTCP() <-chan KeyFrameRequest {
 
 			// We only want to inform others about PLIs and FIRs. We skip the rest of the packets for now.
 			for _, packet := range packets {
-				switch packet.(type) {
+				switch packet := packet.(type) {
 				// For simplicity we assume that any of the key frame requests is just a key frame request.
 				case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
 					ch <- KeyFrameRequest{}
+				// The receiver reports tell us how well the subscriber receives the packets.
+				case *rtcp.ReceiverReport:
+					s.processReceiverReport(packet)
 				}
 			}
 		}
@@ -136,14 +175,42 @@ func (s *VideoSubscription) startReadRTCP() <-chan KeyFrameRequest {
 	return ch
 }
 
+func (s *VideoSubscription) processReceiverReport(report *rtcp.ReceiverReport) {
+	encodings := s.rtpSender.GetParameters().Encodings
+	if len(encodings) == 0 {
+		return
+	}
+
+	for _, block := range report.Reports {
+		if block.SSRC == uint32(encodings[0].SSRC) {
+			s.fractionLost.Store(uint32(block.FractionLost))
+		}
+	}
+}
+
 // Internal state of a worker that runs in its own goroutine.
 type workerState struct {
 	// Rewriter of the packet IDs.
 	packetRewriter *rewriter.PacketRewriter
+	// Drops the temporal layers that the subscriber does not want.
+	temporalFilter temporalFilter
 	// Undelying output track.
 	rtpTrack *webrtc.TrackLocalStaticRTP
+	// Statistics of the forwarded packets.
+	forwarded *forwardingStats
 }
 
 func (w *workerState) handlePacket(packet rtp.Packet) {
-	w.rtpTrack.WriteRTP(w.packetRewriter.ProcessIncoming(packet))
+	if !w.temporalFilter.forward(packet) {
+		w.packetRewriter.DropIncoming(packet)
+		return
+	}
+
+	rewritten := w.packetRewriter.ProcessIncoming(packet)
+	if err := w.rtpTrack.WriteRTP(rewritten); err == nil {
+		size := (*rtp.Packet)(rewritten).MarshalSize()
+		w.forwarded.add(size)
+		metrics.ForwardedPackets.With("video").Inc()
+		metrics.ForwardedBytes.With("video").Add(float64(size))
+	}
 }
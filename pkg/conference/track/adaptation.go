@@ -0,0 +1,241 @@
+package track
+
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// The state of a video subscription that matters when the subscriber's network is congested.
+type SubscriptionQuality struct {
+	// Priority of the subscription requested by the subscriber.
+	Priority int
+	// The fraction of packets lost on the way to the subscriber (from 0 to 1).
+	PacketLoss float64
+	// Whether the subscription is limited to a lower layer than it wants.
+	Degraded bool
+}
+
+// Returns the quality of the video subscription of a given subscriber, if any.
+func (p *PublishedTrack[SubscriberID]) SubscriptionQuality(subscriberID SubscriberID) (SubscriptionQuality, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil {
+		return SubscriptionQuality{}, false
+	}
+
+	measurable, ok := sub.subscription.(measurableSubscription)
+	if !ok {
+		return SubscriptionQuality{}, false
+	}
+
+	return SubscriptionQuality{
+		Priority:   sub.requirements.Priority,
+		PacketLoss: measurable.PacketLoss(),
+		Degraded:   sub.maxLayer != webrtc_ext.SimulcastLayerNone,
+	}, true
+}
+
+// Returns the forwarding statistics of the subscription of a given subscriber since the previous call.
+func (p *PublishedTrack[SubscriberID]) SubscriptionStats(subscriberID SubscriberID) (subscription.Stats, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil {
+		return subscription.Stats{}, false
+	}
+
+	withStats, ok := sub.subscription.(statsSubscription)
+	if !ok {
+		return subscription.Stats{}, false
+	}
+
+	return withStats.Stats(), true
+}
+
+// Limits the subscription of a given subscriber to the layer below the one it currently gets.
+// Returns `false` if the subscription already gets the lowest layer or if it's not a simulcast track.
+func (p *PublishedTrack[SubscriberID]) DegradeSubscription(subscriberID SubscriberID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil || !p.isSimulcast() {
+		return false
+	}
+
+	ordered := orderLayers(p.video.layout, p.video.stats())
+	position := layerPosition(ordered, sub.currentLayer)
+	if position <= 0 {
+		return false
+	}
+
+	sub.maxLayer = ordered[position-1]
+	p.updateSubscription(sub, LayerChangeCongestion)
+
+	p.logger.WithField("subscriber", subscriberID).WithField("layer", sub.maxLayer).Info("Subscription degraded")
+	p.telemetry.AddEvent("subscription degraded", attribute.String("layer", sub.maxLayer.String()))
+	return true
+}
+
+// Lifts the limit of the subscription of a given subscriber by one layer.
+// Returns `false` if the subscription is not limited.
+func (p *PublishedTrack[SubscriberID]) RestoreSubscription(subscriberID SubscriberID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil || sub.maxLayer == webrtc_ext.SimulcastLayerNone {
+		return false
+	}
+
+	ordered := orderLayers(p.video.layout, p.video.stats())
+	if position := layerPosition(ordered, sub.maxLayer); position >= 0 && position+1 < len(ordered)-1 {
+		sub.maxLayer = ordered[position+1]
+	} else {
+		sub.maxLayer = webrtc_ext.SimulcastLayerNone
+	}
+
+	p.updateSubscription(sub, LayerChangeCongestionRecovered)
+
+	p.logger.WithField("subscriber", subscriberID).WithField("layer", sub.maxLayer).Info("Subscription restored")
+	p.telemetry.AddEvent("subscription restored", attribute.String("layer", sub.maxLayer.String()))
+	return true
+}
+
+// Limits the subscription of a given subscriber to the lowest layer, e.g. when the SFU is close to its
+// egress bandwidth budget. Returns `false` if it's not a simulcast track or there is nothing to limit.
+func (p *PublishedTrack[SubscriberID]) LimitSubscription(subscriberID SubscriberID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil || !p.isSimulcast() {
+		return false
+	}
+
+	ordered := orderLayers(p.video.layout, p.video.stats())
+	if len(ordered) == 0 || sub.maxLayer == ordered[0] {
+		return false
+	}
+
+	sub.maxLayer = ordered[0]
+	p.updateSubscription(sub, LayerChangeServerLoad)
+
+	p.logger.WithField("subscriber", subscriberID).WithField("layer", sub.maxLayer).Info("Subscription limited")
+	p.telemetry.AddEvent("subscription limited", attribute.String("layer", sub.maxLayer.String()))
+	return true
+}
+
+// Re-evaluates the temporal layers of all subscriptions, since the frame rates of the layers are only
+// known once we have received enough packets and they may change at any time.
+func (p *PublishedTrack[SubscriberID]) UpdateTemporalLayers() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, sub := range p.subscriptions {
+		p.updateTemporalLayer(sub)
+	}
+}
+
+// Calculates the layer that the subscription should get now and the one it would get if all layers
+// were active, taking the limit into account. The forced layer overrides everything else while it's
+// active. Must be called with the mutex locked.
+func (p *PublishedTrack[SubscriberID]) subscriptionLayers(
+	requirements SubscriptionRequirements,
+	maxLayer webrtc_ext.SimulcastLayer,
+	forcedLayer webrtc_ext.SimulcastLayer,
+) (webrtc_ext.SimulcastLayer, webrtc_ext.SimulcastLayer) {
+	active := p.video.layersUpTo(p.video.activeLayers(), maxLayer)
+	all := p.video.layersUpTo(p.video.allLayers(), maxLayer)
+
+	if forcedLayer != webrtc_ext.SimulcastLayerNone {
+		if _, found := p.video.activeLayers()[forcedLayer]; found {
+			return forcedLayer, forcedLayer
+		}
+
+		// Meanwhile the subscription gets what it would get without forcing.
+		return p.video.optimalLayer(active, p.metadata, requirements.Width, requirements.Height), forcedLayer
+	}
+
+	return p.video.optimalLayer(active, p.metadata, requirements.Width, requirements.Height),
+		p.video.optimalLayer(all, p.metadata, requirements.Width, requirements.Height)
+}
+
+// Limits the frame rate of the subscription according to the subscriber's requirements by dropping
+// the temporal layers. Must be called with the mutex locked.
+func (p *PublishedTrack[SubscriberID]) updateTemporalLayer(sub *trackSubscription[SubscriberID]) {
+	temporal, ok := sub.subscription.(temporalSubscription)
+	if !ok {
+		return
+	}
+
+	layer := uint8(subscription.AllTemporalLayers)
+	if pub := p.video.publishers[sub.currentLayer]; pub != nil {
+		layer = temporalLayer(pub.stats.snapshot(), sub.requirements.MaxFrameRate)
+	}
+
+	temporal.SetMaxTemporalLayer(layer)
+}
+
+// Returns the layers that are not higher than a given one (all layers if there is no limit).
+func (t *videoTrack) layersUpTo(
+	layers map[webrtc_ext.SimulcastLayer]struct{},
+	maxLayer webrtc_ext.SimulcastLayer,
+) map[webrtc_ext.SimulcastLayer]struct{} {
+	if maxLayer == webrtc_ext.SimulcastLayerNone {
+		return layers
+	}
+
+	ordered := orderLayers(t.layout, t.stats())
+	maxPosition := layerPosition(ordered, maxLayer)
+
+	limited := make(map[webrtc_ext.SimulcastLayer]struct{}, len(layers))
+	for layer := range layers {
+		if position := layerPosition(ordered, layer); position <= maxPosition {
+			limited[layer] = struct{}{}
+		}
+	}
+
+	// If nothing is left, the limit is ignored, since it's better to get something than nothing.
+	if len(limited) == 0 {
+		return layers
+	}
+
+	return limited
+}
+
+// Returns the position of the layer in the ordered list or -1 if it's not there.
+func layerPosition(ordered []webrtc_ext.SimulcastLayer, layer webrtc_ext.SimulcastLayer) int {
+	for position, other := range ordered {
+		if other == layer {
+			return position
+		}
+	}
+
+	return -1
+}
+
+// The observed frame rate is not precise, so we tolerate a slightly higher one.
+const frameRateTolerance = 1.1
+
+// Picks the highest temporal layer which frame rate does not exceed the maximum frame rate.
+// Each temporal layer doubles the frame rate of the layers below it.
+func temporalLayer(stats layerStats, maxFrameRate float64) uint8 {
+	if maxFrameRate <= 0 || stats.temporalLayers <= 1 || stats.frameRate <= 0 ||
+		stats.frameRate <= maxFrameRate*frameRateTolerance {
+		return subscription.AllTemporalLayers
+	}
+
+	for layer := stats.temporalLayers - 2; layer > 0; layer-- {
+		frameRate := stats.frameRate / float64(int(1)<<(stats.temporalLayers-1-layer))
+		if frameRate <= maxFrameRate*frameRateTolerance {
+			return uint8(layer)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,38 @@
+package track //nolint:testpackage
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+)
+
+func TestTemporalLayer(t *testing.T) {
+	const all = subscription.AllTemporalLayers
+
+	cases := []struct {
+		frameRate      float64
+		temporalLayers int
+		maxFrameRate   float64
+		expected       uint8
+	}{
+		{30, 3, 0, all},  // No limit.
+		{30, 1, 15, all}, // No temporal layers.
+		{0, 3, 15, all},  // Frame rate is not known yet.
+		{30, 3, 30, all}, // The limit is not lower than the frame rate.
+		{31, 3, 30, all}, // Within the tolerance.
+		{30, 3, 15, 1},   // 30 fps, 15 fps, 7.5 fps.
+		{30, 3, 10, 0},   // 7.5 fps is the best we can do.
+		{30, 3, 1, 0},    // The base layer is always forwarded.
+		{30, 2, 15, 0},   // 30 fps, 15 fps.
+		{60, 3, 30, 1},   // 60 fps, 30 fps, 15 fps.
+		{60, 3, 16, 0},   // Within the tolerance of the base layer.
+	}
+
+	for _, c := range cases {
+		stats := layerStats{frameRate: c.frameRate, temporalLayers: c.temporalLayers}
+		if layer := temporalLayer(stats, c.maxFrameRate); layer != c.expected {
+			t.Errorf("%v fps with %d layers limited to %v fps: expected layer %d, got %d",
+				c.frameRate, c.temporalLayers, c.maxFrameRate, c.expected, layer)
+		}
+	}
+}
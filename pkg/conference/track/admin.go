@@ -0,0 +1,153 @@
+package track
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// The state of the published track for the introspection.
+type Snapshot struct {
+	TrackID          string                 `json:"track_id"`
+	StreamID         string                 `json:"stream_id"`
+	Kind             string                 `json:"kind"`
+	Muted            bool                   `json:"muted"`
+	MutedByModerator bool                   `json:"muted_by_moderator"`
+	Layers           []LayerSnapshot        `json:"layers"`
+	Subscriptions    []SubscriptionSnapshot `json:"subscriptions"`
+}
+
+type LayerSnapshot struct {
+	// RID of the layer, empty if the track is not a simulcast track.
+	RID       string  `json:"rid,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Bitrate   int     `json:"bitrate"`
+	FrameRate float64 `json:"frame_rate"`
+	// No packets are received for a while.
+	Stalled bool `json:"stalled"`
+	// The owner is asked to stop sending the layer, since nobody uses it.
+	Paused bool `json:"paused"`
+}
+
+type SubscriptionSnapshot struct {
+	Subscriber string `json:"subscriber"`
+	// The layer that the subscription gets now, the one it wants, the highest one it may get due to
+	// the congestion and the one forced by the admin. Empty if not applicable.
+	RID        string `json:"rid,omitempty"`
+	DesiredRID string `json:"desired_rid,omitempty"`
+	MaxRID     string `json:"max_rid,omitempty"`
+	ForcedRID  string `json:"forced_rid,omitempty"`
+	// Whether there is a publisher that feeds the subscription.
+	Active bool `json:"active"`
+}
+
+// Returns the current state of the track, its layers and subscriptions.
+func (p *PublishedTrack[SubscriberID]) Snapshot() Snapshot {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	snapshot := Snapshot{
+		TrackID:          p.info.TrackID,
+		StreamID:         p.info.StreamID,
+		Kind:             p.info.Kind.String(),
+		Muted:            p.muted,
+		MutedByModerator: p.mutedByModerator,
+		Layers:           []LayerSnapshot{},
+		Subscriptions:    []SubscriptionSnapshot{},
+	}
+
+	if p.info.Kind == webrtc.RTPCodecTypeAudio && p.audio != nil {
+		snapshot.Layers = append(snapshot.Layers, LayerSnapshot{
+			Bitrate: p.audio.stats.snapshot().bitrate,
+			Stalled: p.audio.isStalled(),
+		})
+	}
+
+	if p.info.Kind == webrtc.RTPCodecTypeVideo {
+		for _, layer := range orderLayers(p.video.layout, p.video.stats()) {
+			publisher := p.video.publishers[layer]
+			if publisher == nil {
+				continue
+			}
+
+			stats := publisher.stats.snapshot()
+			snapshot.Layers = append(snapshot.Layers, LayerSnapshot{
+				RID:       p.video.rid(layer),
+				Width:     stats.resolution.width,
+				Height:    stats.resolution.height,
+				Bitrate:   stats.bitrate,
+				FrameRate: stats.frameRate,
+				Stalled:   publisher.isStalled(),
+				Paused:    p.video.isPaused(layer),
+			})
+		}
+	}
+
+	for _, sub := range p.subscriptions {
+		snapshot.Subscriptions = append(snapshot.Subscriptions, SubscriptionSnapshot{
+			Subscriber: sub.subscriberID.String(),
+			RID:        p.video.rid(sub.currentLayer),
+			DesiredRID: p.video.rid(sub.desiredLayer),
+			MaxRID:     p.video.rid(sub.maxLayer),
+			ForcedRID:  p.video.rid(sub.forcedLayer),
+			Active:     p.subscriptionLayer(sub.currentLayer).Active,
+		})
+	}
+
+	sort.Slice(snapshot.Subscriptions, func(i, j int) bool {
+		return snapshot.Subscriptions[i].Subscriber < snapshot.Subscriptions[j].Subscriber
+	})
+
+	return snapshot
+}
+
+// Forces the subscription of a given subscriber to use the layer with a given RID regardless of
+// the subscriber's requirements. An empty RID lifts the restriction.
+func (p *PublishedTrack[SubscriberID]) ForceSubscriptionLayer(subscriberID SubscriberID, rid string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil {
+		return fmt.Errorf("%w: %v", ErrSubscriptionNotFound, subscriberID)
+	}
+
+	if !p.isSimulcast() {
+		return fmt.Errorf("track %s is not a simulcast track", p.info.TrackID)
+	}
+
+	forcedLayer := webrtc_ext.SimulcastLayerNone
+	if rid != "" {
+		found := false
+		for layer := range p.video.publishers {
+			if p.video.rid(layer) == rid {
+				forcedLayer, found = layer, true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("layer %s not found", rid)
+		}
+	}
+
+	sub.forcedLayer = forcedLayer
+	p.updateSubscription(sub, LayerChangeRequested)
+
+	p.logger.WithField("subscriber", subscriberID).WithField("rid", rid).Info("Subscription layer forced")
+	p.telemetry.AddEvent("subscription layer forced", attribute.String("rid", rid))
+	return nil
+}
+
+// Returns the RID of a given layer, empty if the track has no simulcast.
+func (t *videoTrack) rid(layer webrtc_ext.SimulcastLayer) string {
+	if rid, found := t.layout.RID(layer); found && layer != webrtc_ext.SimulcastLayerNone {
+		return rid.ID
+	}
+
+	return ""
+}
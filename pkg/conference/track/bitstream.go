@@ -0,0 +1,271 @@
+package track
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/pion/rtp/codecs"
+)
+
+// Extracts the resolution from the first packet of a VP8 key frame (RFC 6386, section 9.1).
+func vp8Resolution(payload []byte) (int, int, bool) {
+	vp8Packet := codecs.VP8Packet{}
+
+	frame, err := vp8Packet.Unmarshal(payload)
+	if err != nil || vp8Packet.S != 1 || vp8Packet.PID != 0 {
+		return 0, 0, false
+	}
+
+	// 3 bytes of the frame tag (the P bit is 0 for key frames), 3 bytes of the start code, then
+	// 14 bits of width and 14 bits of height in little endian (the upper 2 bits are the scaling).
+	const headerLength = 10
+	if len(frame) < headerLength || frame[0]&0x01 != 0 || frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+		return 0, 0, false
+	}
+
+	width := int(frame[6]) | int(frame[7]&0x3f)<<8
+	height := int(frame[8]) | int(frame[9]&0x3f)<<8
+
+	return width, height, true
+}
+
+// Returns the temporal layer index of a VP8 packet if the packet has it.
+func vp8TemporalLayer(payload []byte) (uint8, bool) {
+	vp8Packet := codecs.VP8Packet{}
+	if _, err := vp8Packet.Unmarshal(payload); err != nil || vp8Packet.T == 0 {
+		return 0, false
+	}
+
+	return vp8Packet.TID, true
+}
+
+// Extracts the resolution from the SPS (sequence parameter set) if the H.264 payload contains it.
+// Browsers send the SPS either as a single NAL unit or aggregated with the PPS and the IDR slice.
+func h264Resolution(payload []byte) (int, int, bool) {
+	if len(payload) == 0 {
+		return 0, 0, false
+	}
+
+	switch payload[0] & 0x1F {
+	case h264NALUnitSPS:
+		return parseH264SPS(payload)
+
+	case h264NALUnitSTAP:
+		for offset := 1; offset+2 < len(payload); {
+			size := int(binary.BigEndian.Uint16(payload[offset:]))
+			offset += 2
+
+			if offset+size > len(payload) {
+				break
+			}
+
+			// A unit without the header or the payload can't be an SPS.
+			if size < 2 {
+				offset += size
+				continue
+			}
+
+			if payload[offset]&0x1F == h264NALUnitSPS {
+				return parseH264SPS(payload[offset : offset+size])
+			}
+
+			offset += size
+		}
+	}
+
+	return 0, 0, false
+}
+
+// Parses the SPS NAL unit (ITU-T H.264, section 7.3.2.1.1) up to the frame size and cropping.
+//
+//nolint:cyclop,funlen,gomnd
+func parseH264SPS(nalUnit []byte) (int, int, bool) {
+	if len(nalUnit) < 2 {
+		return 0, 0, false
+	}
+
+	reader := newBitReader(removeEmulationPrevention(nalUnit[1:]))
+
+	profile := reader.bits(8)
+	reader.skip(16) // Constraint flags and level.
+	reader.golomb() // seq_parameter_set_id
+
+	chromaFormat, separateColourPlane := uint(1), false
+
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		if chromaFormat = reader.golomb(); chromaFormat == 3 {
+			separateColourPlane = reader.bits(1) == 1
+		}
+
+		reader.golomb() // bit_depth_luma_minus8
+		reader.golomb() // bit_depth_chroma_minus8
+		reader.skip(1)  // qpprime_y_zero_transform_bypass_flag
+
+		if reader.bits(1) == 1 { // seq_scaling_matrix_present_flag
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+
+			for i := 0; i < lists; i++ {
+				if reader.bits(1) == 0 {
+					continue
+				}
+
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+
+				for last, next, j := 8, 8, 0; j < size && next != 0; j++ {
+					next = (last + reader.signedGolomb() + 256) % 256
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	reader.golomb() // log2_max_frame_num_minus4
+
+	switch reader.golomb() { // pic_order_cnt_type
+	case 0:
+		reader.golomb() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		reader.skip(1)        // delta_pic_order_always_zero_flag
+		reader.signedGolomb() // offset_for_non_ref_pic
+		reader.signedGolomb() // offset_for_top_to_bottom_field
+		for cycle := reader.golomb(); cycle > 0 && reader.err == nil; cycle-- {
+			reader.signedGolomb() // offset_for_ref_frame
+		}
+	}
+
+	reader.golomb() // max_num_ref_frames
+	reader.skip(1)  // gaps_in_frame_num_value_allowed_flag
+
+	widthInMacroblocks := reader.golomb() + 1
+	heightInMapUnits := reader.golomb() + 1
+
+	frameMacroblocksOnly := reader.bits(1)
+	if frameMacroblocksOnly == 0 {
+		reader.skip(1) // mb_adaptive_frame_field_flag
+	}
+
+	reader.skip(1) // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	if reader.bits(1) == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = reader.golomb(), reader.golomb(), reader.golomb(), reader.golomb()
+	}
+
+	if reader.err != nil {
+		return 0, 0, false
+	}
+
+	// Cropping is expressed in chroma samples, so it depends on the chroma subsampling.
+	cropUnitX, cropUnitY := uint(1), 2-frameMacroblocksOnly
+	if chromaFormat != 0 && !separateColourPlane {
+		if chromaFormat == 1 || chromaFormat == 2 {
+			cropUnitX = 2
+		}
+
+		if chromaFormat == 1 {
+			cropUnitY *= 2
+		}
+	}
+
+	frameWidth, frameHeight := widthInMacroblocks*16, (2-frameMacroblocksOnly)*heightInMapUnits*16
+	cropX, cropY := cropUnitX*(cropLeft+cropRight), cropUnitY*(cropTop+cropBottom)
+
+	// The SPS comes from the publisher, the cropping must not exceed the frame (and wrap around).
+	if cropX >= frameWidth || cropY >= frameHeight {
+		return 0, 0, false
+	}
+
+	return int(frameWidth - cropX), int(frameHeight - cropY), true
+}
+
+// Removes the emulation prevention bytes (0x03 in 0x000003) from the NAL unit payload.
+func removeEmulationPrevention(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+	zeros := 0
+
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+
+		result = append(result, b)
+	}
+
+	return result
+}
+
+var errEndOfData = errors.New("unexpected end of data")
+
+// Reads the bit fields and Exp-Golomb codes. Once the data is over, all reads return zeros and
+// the error is set, so that the caller could check it once at the end.
+type bitReader struct {
+	data     []byte
+	position int
+	err      error
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (r *bitReader) bits(count int) uint {
+	var value uint
+
+	for i := 0; i < count; i++ {
+		if r.position >= len(r.data)*8 {
+			r.err = errEndOfData
+			return 0
+		}
+
+		bit := (r.data[r.position/8] >> (7 - r.position%8)) & 0x01
+		value = value<<1 | uint(bit)
+		r.position++
+	}
+
+	return value
+}
+
+func (r *bitReader) skip(count int) {
+	r.bits(count)
+}
+
+// Reads the unsigned Exp-Golomb code.
+func (r *bitReader) golomb() uint {
+	leadingZeros := 0
+	for r.bits(1) == 0 {
+		if r.err != nil || leadingZeros > 31 {
+			r.err = errEndOfData
+			return 0
+		}
+
+		leadingZeros++
+	}
+
+	return 1<<leadingZeros - 1 + r.bits(leadingZeros)
+}
+
+// Reads the signed Exp-Golomb code.
+func (r *bitReader) signedGolomb() int {
+	value := r.golomb()
+	if value%2 == 0 {
+		return -int(value / 2)
+	}
+
+	return int(value/2) + 1
+}
@@ -0,0 +1,71 @@
+package track //nolint:testpackage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVP8Resolution(t *testing.T) {
+	// Payload descriptor (S bit set, partition 0), key frame tag, start code, 640x360.
+	keyFrame := []byte{0x10, 0x00, 0x00, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0x68, 0x01}
+	if width, height, ok := vp8Resolution(keyFrame); !ok || width != 640 || height != 360 {
+		t.Fatalf("Unexpected resolution: %dx%d (%v)", width, height, ok)
+	}
+
+	// The same packet, but an inter frame (P bit is set).
+	interFrame := append([]byte(nil), keyFrame...)
+	interFrame[1] |= 0x01
+	if _, _, ok := vp8Resolution(interFrame); ok {
+		t.Fatal("Expected no resolution for an inter frame")
+	}
+}
+
+func TestH264Resolution(t *testing.T) {
+	cases := []struct {
+		payload       []byte
+		width, height int
+		found         bool
+	}{
+		// Baseline profile SPS, 1280x720.
+		{[]byte{0x67, 0x42, 0xc0, 0x1f, 0xf4, 0x02, 0x80, 0x2d, 0xd0}, 1280, 720, true},
+		// High profile SPS, 1920x1088 cropped to 1920x1080.
+		{[]byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x80}, 1920, 1080, true},
+		// The same SPS aggregated with a PPS (STAP-A).
+		{[]byte{
+			0x18,
+			0x00, 0x09, 0x67, 0x42, 0xc0, 0x1f, 0xf4, 0x02, 0x80, 0x2d, 0xd0,
+			0x00, 0x02, 0x68, 0xce,
+		}, 1280, 720, true},
+		// Baseline profile SPS, 1280x720 cropped to 1272x712.
+		{[]byte{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01, 0x40, 0x16, 0xf9, 0x65, 0x40}, 1272, 712, true},
+		// The same SPS with the cropping wider and higher than the frame.
+		{[]byte{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01, 0x40, 0x16, 0xf8, 0x02, 0xbd, 0xd0}, 0, 0, false},
+		{[]byte{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01, 0x40, 0x16, 0xfe, 0x01, 0x91, 0x40}, 0, 0, false},
+		// Truncated SPS.
+		{[]byte{0x67, 0x42, 0xc0, 0x1f, 0xf4}, 0, 0, false},
+		// STAP-A that ends in the middle of the aggregated SPS.
+		{[]byte{0x18, 0x00, 0x09, 0x67, 0x42, 0xc0}, 0, 0, false},
+		// STAP-A with empty and 1-byte units at the end of the payload.
+		{[]byte{0x18, 0x00, 0x02, 0x68, 0xce, 0x00, 0x00, 0x00, 0x01, 0x67}, 0, 0, false},
+		// SPS without the profile.
+		{[]byte{0x67}, 0, 0, false},
+		// IDR slice without SPS.
+		{[]byte{0x65, 0x88, 0x84}, 0, 0, false},
+	}
+
+	for _, c := range cases {
+		width, height, found := h264Resolution(c.payload)
+		if width != c.width || height != c.height || found != c.found {
+			t.Errorf("Unexpected resolution for %x: %dx%d (%v)", c.payload, width, height, found)
+		}
+	}
+}
+
+func TestRemoveEmulationPrevention(t *testing.T) {
+	data := []byte{0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x00, 0x03}
+	expected := []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x03}
+
+	if result := removeEmulationPrevention(data); !bytes.Equal(result, expected) {
+		t.Fatalf("Unexpected result: %x", result)
+	}
+}
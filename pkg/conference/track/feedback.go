@@ -0,0 +1,159 @@
+package track
+
+import (
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+)
+
+// Why the layer of a subscription changed.
+type LayerChangeReason int
+
+const (
+	// The subscriber changed its requirements (e.g. the desired resolution).
+	LayerChangeRequested LayerChangeReason = iota
+	// The publisher of the layer stopped sending packets.
+	LayerChangePublisherStalled
+	// The publisher of the layer recovered or a paused layer was resumed.
+	LayerChangePublisherRecovered
+	// The publisher of the layer is gone.
+	LayerChangePublisherStopped
+	// The subscriber's downlink is congested.
+	LayerChangeCongestion
+	// The subscriber's downlink is not congested anymore.
+	LayerChangeCongestionRecovered
+	// The SFU is close to its egress bandwidth budget.
+	LayerChangeServerLoad
+)
+
+func (r LayerChangeReason) String() string {
+	switch r {
+	case LayerChangeRequested:
+		return "requested"
+	case LayerChangePublisherStalled:
+		return "publisher_stalled"
+	case LayerChangePublisherRecovered:
+		return "publisher_recovered"
+	case LayerChangePublisherStopped:
+		return "publisher_stopped"
+	case LayerChangeCongestion:
+		return "congestion"
+	case LayerChangeCongestionRecovered:
+		return "congestion_recovered"
+	case LayerChangeServerLoad:
+		return "server_load"
+	default:
+		return "unknown"
+	}
+}
+
+// The layer that the subscription gets.
+type SubscriptionLayer struct {
+	// RID of the layer, empty if the track is not a simulcast track.
+	RID string
+	// Estimated resolution of the layer, zeros if not known.
+	Width, Height int
+	// Whether there is a publisher that feeds the subscription, i.e. `false` if all publishers are stalled.
+	Active bool
+}
+
+// Describes a change of the layer that the SFU made on its own.
+type SubscriptionLayerChange[SubscriberID SubscriberIdentifier] struct {
+	SubscriberID SubscriberID
+	Layer        SubscriptionLayer
+	Reason       LayerChangeReason
+}
+
+// Returns the layer that the subscription of a given subscriber currently gets.
+func (p *PublishedTrack[SubscriberID]) SubscriptionLayer(subscriberID SubscriberID) (SubscriptionLayer, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sub := p.subscriptions[subscriberID]
+	if sub == nil {
+		return SubscriptionLayer{}, false
+	}
+
+	return p.subscriptionLayer(sub.currentLayer), true
+}
+
+// Returns a channel that informs that the layers of some subscriptions changed, see `SubscriptionLayerChanges()`.
+func (p *PublishedTrack[SubscriberID]) SubscriptionLayerChanged() <-chan struct{} {
+	return p.subscriptionLayerChanged
+}
+
+// Returns the subscriptions whose layers changed since the last call, the changes requested by the
+// subscribers themselves are not included, since they learn about them from the subscription result.
+func (p *PublishedTrack[SubscriberID]) SubscriptionLayerChanges() []SubscriptionLayerChange[SubscriberID] {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	changes := []SubscriptionLayerChange[SubscriberID]{}
+	for _, sub := range p.subscriptions {
+		if sub.currentLayer == sub.reportedLayer {
+			continue
+		}
+
+		sub.reportedLayer = sub.currentLayer
+		changes = append(changes, SubscriptionLayerChange[SubscriberID]{
+			SubscriberID: sub.subscriberID,
+			Layer:        p.subscriptionLayer(sub.currentLayer),
+			Reason:       sub.layerChangeReason,
+		})
+	}
+
+	return changes
+}
+
+// Switches the subscription to a different layer and informs about the change. The caller is responsible
+// for moving the subscription between the publishers. Must be called with the mutex locked.
+func (p *PublishedTrack[SubscriberID]) switchSubscriptionLayer(
+	sub *trackSubscription[SubscriberID],
+	layer webrtc_ext.SimulcastLayer,
+	reason LayerChangeReason,
+) {
+	if sub.currentLayer == layer {
+		return
+	}
+
+	sub.currentLayer = layer
+	sub.layerChangeReason = reason
+	metrics.LayerSwitches.With(reason.String()).Inc()
+
+	// The notifications are coalesced if the receiver is not fast enough.
+	select {
+	case p.subscriptionLayerChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Describes a given layer of the track. Must be called with the mutex locked.
+func (p *PublishedTrack[SubscriberID]) subscriptionLayer(layer webrtc_ext.SimulcastLayer) SubscriptionLayer {
+	if p.info.Kind == webrtc.RTPCodecTypeAudio {
+		return SubscriptionLayer{Active: p.audio != nil}
+	}
+
+	result := SubscriptionLayer{Active: p.video.publishers[layer] != nil}
+
+	stats := p.video.stats()
+	if layer == webrtc_ext.SimulcastLayerNone {
+		// Either a non-simulcast track or a simulcast track without active publishers.
+		if observed := stats[layer].resolution; observed.size() > 0 {
+			result.Width, result.Height = observed.width, observed.height
+		} else if result.Active {
+			result.Width, result.Height = p.metadata.MaxWidth, p.metadata.MaxHeight
+		}
+
+		return result
+	}
+
+	if rid, found := p.video.layout.RID(layer); found {
+		result.RID = rid.ID
+	}
+
+	ordered := orderLayers(p.video.layout, stats)
+	resolution := estimateResolutions(ordered, p.video.layout, stats, p.metadata)[layer]
+	result.Width, result.Height = resolution.width, resolution.height
+
+	return result
+}
@@ -0,0 +1,82 @@
+package track //nolint:testpackage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestSubscriptionLayerChanges(t *testing.T) {
+	low, mid, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+
+	published := &PublishedTrack[testSubscriber]{
+		info:          webrtc_ext.TrackInfo{Kind: webrtc.RTPCodecTypeVideo},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video: &videoTrack{
+			layout: webrtc_ext.SimulcastLayout{RIDs: []webrtc_ext.SimulcastRID{
+				{ID: "q", ScaleDownBy: 4},
+				{ID: "h", ScaleDownBy: 2},
+				{ID: "f", ScaleDownBy: 1},
+			}, Ordered: true},
+			publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{
+				low:  {stats: &publisherStats{}},
+				mid:  {stats: &publisherStats{}},
+				high: {stats: &publisherStats{}},
+			},
+		},
+		metadata:                 TrackMetadata{MaxWidth: 1280, MaxHeight: 720},
+		subscriptionLayerChanged: make(chan struct{}, 1),
+	}
+
+	changed := func() bool {
+		select {
+		case <-published.subscriptionLayerChanged:
+			return true
+		default:
+			return false
+		}
+	}
+
+	sub := &trackSubscription[testSubscriber]{subscriberID: "a", currentLayer: high, reportedLayer: high}
+	published.subscriptions["a"] = sub
+
+	// The subscriber knows about its layer.
+	if changes := published.SubscriptionLayerChanges(); len(changes) != 0 {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+
+	// Switching to the same layer is not a change.
+	published.switchSubscriptionLayer(sub, high, LayerChangePublisherStalled)
+	if changed() {
+		t.Fatal("unexpected notification")
+	}
+
+	// The high layer stalled, so the subscription got the low one.
+	published.switchSubscriptionLayer(sub, low, LayerChangePublisherStalled)
+	expected := []SubscriptionLayerChange[testSubscriber]{{
+		SubscriberID: "a",
+		Layer:        SubscriptionLayer{RID: "q", Width: 320, Height: 180, Active: true},
+		Reason:       LayerChangePublisherStalled,
+	}}
+	if changes := published.SubscriptionLayerChanges(); !changed() || !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+
+	// The changes are reported once.
+	if changes := published.SubscriptionLayerChanges(); len(changes) != 0 {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+
+	// All layers stalled, so the subscription gets nothing.
+	published.switchSubscriptionLayer(sub, webrtc_ext.SimulcastLayerNone, LayerChangePublisherStalled)
+	expected = []SubscriptionLayerChange[testSubscriber]{{
+		SubscriberID: "a",
+		Layer:        SubscriptionLayer{},
+		Reason:       LayerChangePublisherStalled,
+	}}
+	if changes := published.SubscriptionLayerChanges(); !changed() || !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+}
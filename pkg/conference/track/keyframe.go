@@ -0,0 +1,197 @@
+package track
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// The interval that is used if the configured one is not set.
+const DefaultKeyFrameRequestInterval = 500 * time.Millisecond
+
+// Sends key frame requests to a single publisher (simulcast layer). All requests that arrive within
+// `minInterval` after the last one are coalesced into a single delayed request, so that the publisher
+// is not flooded with requests when many subscribers join at once. If the previous request has not
+// been answered with a key frame, the next one is sent as FIR instead of PLI.
+type keyFrameRequester struct {
+	// Sends the request of a given type to the publisher.
+	send func(packetType webrtc_ext.RTCPPacketType, firSequenceNumber uint8) error
+	// Minimal interval between two requests.
+	minInterval time.Duration
+	// Set if we're able to recognize the key frames of the track's codec. If we can't, we never
+	// know if the request has been answered, so we stick to PLI.
+	detectsKeyFrames bool
+	// Stops sending the delayed requests.
+	stop <-chan struct{}
+	// Scoped logger.
+	logger *logrus.Entry
+
+	mutex sync.Mutex
+	// The time when the last request has been sent (zero if none has been sent yet).
+	lastRequest time.Time
+	// The time when the last key frame has been received.
+	lastKeyFrame time.Time
+	// The time when the oldest pending (delayed) request has been made, zero if there is none.
+	pendingSince time.Time
+	// Sequence number of the last FIR (RFC 5104), incremented for each new FIR.
+	firSequenceNumber uint8
+}
+
+func newKeyFrameRequester(
+	send func(packetType webrtc_ext.RTCPPacketType, firSequenceNumber uint8) error,
+	minInterval time.Duration,
+	codec webrtc.RTPCodecCapability,
+	stop <-chan struct{},
+	logger *logrus.Entry,
+) *keyFrameRequester {
+	if minInterval <= 0 {
+		minInterval = DefaultKeyFrameRequestInterval
+	}
+
+	return &keyFrameRequester{
+		send:             send,
+		minInterval:      minInterval,
+		detectsKeyFrames: isKeyFrameDetectable(codec),
+		stop:             stop,
+		logger:           logger,
+	}
+}
+
+// Requests a key frame. The request is either sent immediately or scheduled to be sent once the
+// minimal interval elapses. Requests that are made while another one is pending are coalesced.
+func (k *keyFrameRequester) request() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	// There is a pending request already, it will cover this one as well.
+	if !k.pendingSince.IsZero() {
+		return nil
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(k.lastRequest)
+	if elapsed >= k.minInterval {
+		return k.sendRequest()
+	}
+
+	k.pendingSince = now
+	time.AfterFunc(k.minInterval-elapsed, k.sendPendingRequest)
+
+	return nil
+}
+
+// Informs the requester that a packet has been received from the publisher.
+func (k *keyFrameRequester) packetReceived(codec webrtc.RTPCodecCapability, packet *rtp.Packet) {
+	if !k.detectsKeyFrames || !isKeyFrame(codec, packet) {
+		return
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.lastKeyFrame = time.Now()
+}
+
+// Sends the delayed request unless it has been answered in the meantime or the publisher is gone.
+func (k *keyFrameRequester) sendPendingRequest() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	pendingSince := k.pendingSince
+	k.pendingSince = time.Time{}
+
+	select {
+	case <-k.stop:
+		return
+	default:
+	}
+
+	// The key frame that has arrived after the request had been made satisfies it.
+	if k.detectsKeyFrames && k.lastKeyFrame.After(pendingSince) {
+		return
+	}
+
+	if err := k.sendRequest(); err != nil {
+		k.logger.WithError(err).Warn("Failed to send delayed key frame request")
+	}
+}
+
+// Sends a request right away. Must be called with the mutex locked.
+func (k *keyFrameRequester) sendRequest() error {
+	packetType := webrtc_ext.PictureLossIndicator
+
+	// The previous request has not been answered, the publisher might ignore PLIs, so let's try with FIR.
+	unanswered := !k.lastRequest.IsZero() && k.lastKeyFrame.Before(k.lastRequest)
+	if k.detectsKeyFrames && unanswered {
+		packetType = webrtc_ext.FullIntraRequest
+		k.firSequenceNumber++
+		k.logger.Debug("Previous key frame request is unanswered, sending FIR")
+	}
+
+	k.lastRequest = time.Now()
+	return k.send(packetType, k.firSequenceNumber)
+}
+
+// Returns `true` if we know how to recognize key frames of a given codec.
+func isKeyFrameDetectable(codec webrtc.RTPCodecCapability) bool {
+	return strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8) ||
+		strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264)
+}
+
+// Determines if a given packet contains (the beginning of) a key frame.
+func isKeyFrame(codec webrtc.RTPCodecCapability, packet *rtp.Packet) bool {
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8):
+		return rewriter.IsVP8Keyframe(*packet)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264):
+		return isH264Keyframe(packet.Payload)
+	default:
+		return false
+	}
+}
+
+// H.264 NAL unit types (RFC 6184) that we're interested in.
+const (
+	h264NALUnitIDR  = 5
+	h264NALUnitSPS  = 7
+	h264NALUnitSTAP = 24
+	h264NALUnitFUA  = 28
+)
+
+// Determines if a given H.264 payload contains an IDR slice or the SPS that precedes it.
+func isH264Keyframe(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+
+	switch nalUnitType := payload[0] & 0x1F; nalUnitType {
+	case h264NALUnitIDR, h264NALUnitSPS:
+		return true
+
+	case h264NALUnitSTAP:
+		// Aggregation packet: a sequence of 16 bit sizes followed by NAL units.
+		for offset := 1; offset+2 < len(payload); {
+			size := int(binary.BigEndian.Uint16(payload[offset:]))
+			offset += 2
+
+			if nalUnitType := payload[offset] & 0x1F; nalUnitType == h264NALUnitIDR || nalUnitType == h264NALUnitSPS {
+				return true
+			}
+
+			offset += size
+		}
+
+	case h264NALUnitFUA:
+		// Fragmentation unit: only the first fragment (S bit set) is interesting.
+		return len(payload) > 1 && payload[1]&0x80 != 0 && payload[1]&0x1F == h264NALUnitIDR
+	}
+
+	return false
+}
@@ -0,0 +1,98 @@
+package track //nolint:testpackage
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+type sentRequest struct {
+	packetType        webrtc_ext.RTCPPacketType
+	firSequenceNumber uint8
+}
+
+func TestKeyFrameRequester(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		sent  []sentRequest
+	)
+
+	interval := 50 * time.Millisecond
+	codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}
+	requester := newKeyFrameRequester(
+		func(packetType webrtc_ext.RTCPPacketType, firSequenceNumber uint8) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			sent = append(sent, sentRequest{packetType, firSequenceNumber})
+			return nil
+		},
+		interval,
+		codec,
+		make(chan struct{}),
+		logrus.NewEntry(logrus.New()),
+	)
+
+	sentRequests := func() []sentRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]sentRequest(nil), sent...)
+	}
+
+	// The first request goes out immediately, the rest are coalesced into a single delayed one.
+	for i := 0; i < 30; i++ {
+		if err := requester.request(); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+
+	if requests := sentRequests(); len(requests) != 1 || requests[0].packetType != webrtc_ext.PictureLossIndicator {
+		t.Fatalf("expected a single PLI, got %+v", requests)
+	}
+
+	// The PLI has not been answered, so the delayed request is upgraded to FIR.
+	time.Sleep(2 * interval)
+	requests := sentRequests()
+	if len(requests) != 2 || requests[1] != (sentRequest{webrtc_ext.FullIntraRequest, 1}) {
+		t.Fatalf("expected a FIR with sequence number 1, got %+v", requests)
+	}
+
+	// Once the key frame arrives, we're back to PLIs.
+	requester.packetReceived(codec, &rtp.Packet{Payload: []byte{h264NALUnitIDR}})
+	time.Sleep(interval)
+	if err := requester.request(); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	requests = sentRequests()
+	if len(requests) != 3 || requests[2].packetType != webrtc_ext.PictureLossIndicator {
+		t.Fatalf("expected a PLI after the key frame, got %+v", requests)
+	}
+}
+
+func TestIsH264Keyframe(t *testing.T) {
+	cases := []struct {
+		payload  []byte
+		keyframe bool
+	}{
+		{[]byte{}, false},
+		{[]byte{0x65}, true},                          // IDR slice.
+		{[]byte{0x41}, false},                         // Non-IDR slice.
+		{[]byte{0x18, 0x00, 0x01, 0x67}, true},        // STAP-A with SPS.
+		{[]byte{0x18, 0x00, 0x01, 0x41}, false},       // STAP-A with a non-IDR slice.
+		{[]byte{0x7c, 0x85}, true},                    // First FU-A fragment of an IDR slice.
+		{[]byte{0x7c, 0x05}, false},                   // Subsequent FU-A fragment of an IDR slice.
+		{[]byte{0x18, 0x00, 0x05, 0x41}, false},       // Truncated STAP-A.
+		{[]byte{0x18, 0x00, 0x01, 0x41, 0x00}, false}, // STAP-A with a trailing byte.
+	}
+
+	for _, c := range cases {
+		if isH264Keyframe(c.payload) != c.keyframe {
+			t.Errorf("unexpected result for %v, expected %v", c.payload, c.keyframe)
+		}
+	}
+}
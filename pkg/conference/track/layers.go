@@ -0,0 +1,114 @@
+package track
+
+import (
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Get the set of all layers that the track has, including the stalled and paused ones.
+func (t *videoTrack) allLayers() map[webrtc_ext.SimulcastLayer]struct{} {
+	layers := make(map[webrtc_ext.SimulcastLayer]struct{}, len(t.publishers))
+	for layer := range t.publishers {
+		layers[layer] = struct{}{}
+	}
+
+	return layers
+}
+
+// Returns `true` if we asked the owner of the track to stop sending a given layer.
+func (t *videoTrack) isPaused(layer webrtc_ext.SimulcastLayer) bool {
+	requested, found := t.requestedLayers[layer]
+	return found && !requested
+}
+
+// Returns the RIDs of the layers of a simulcast track along with the flag that tells if the owner
+// of the track is asked to send the layer. Returns `nil` for tracks without simulcast.
+func (p *PublishedTrack[SubscriberID]) RequestedLayers() map[string]bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.video.requestedLayers == nil {
+		return nil
+	}
+
+	layers := make(map[string]bool, len(p.video.requestedLayers))
+	for layer, requested := range p.video.requestedLayers {
+		if rid, found := p.video.layout.RID(layer); found {
+			layers[rid.ID] = requested
+		}
+	}
+
+	return layers
+}
+
+// Returns a channel that informs that the requested layers changed, see `RequestedLayers()`.
+func (p *PublishedTrack[SubscriberID]) RequestedLayersChanged() <-chan struct{} {
+	return p.requestedLayersChanged
+}
+
+// Recalculates which layers of a simulcast track are in use by the subscriptions. The layers that
+// nobody uses are paused, i.e. we ask the owner of the track to stop sending them, so that the
+// owner saves the uplink bandwidth and the CPU time. The lowest layer is never paused, since it's
+// our fallback when other layers stall. Must be called with the mutex locked.
+func (p *PublishedTrack[SubscriberID]) updateRequestedLayers() {
+	if !p.isSimulcast() {
+		return
+	}
+
+	lowestLayer := p.video.lowestLayer()
+	requested := make(map[webrtc_ext.SimulcastLayer]bool, len(p.video.publishers))
+	for layer := range p.video.publishers {
+		requested[layer] = layer == lowestLayer
+	}
+
+	// The layer is in use if a subscription wants it or is currently subscribed to it.
+	for _, sub := range p.subscriptions {
+		for _, layer := range []webrtc_ext.SimulcastLayer{sub.desiredLayer, sub.currentLayer} {
+			if _, found := requested[layer]; found {
+				requested[layer] = true
+			}
+		}
+	}
+
+	if equalLayers(requested, p.video.requestedLayers) {
+		return
+	}
+
+	for layer, active := range requested {
+		// The owner sends all layers unless asked otherwise.
+		previous, found := p.video.requestedLayers[layer]
+		if (found && previous == active) || (!found && active) {
+			continue
+		}
+
+		event := "layer paused"
+		if active {
+			event = "layer resumed"
+		}
+
+		p.logger.WithField("layer", layer).Info(event)
+		p.telemetry.AddEvent(event, attribute.String("layer", layer.String()))
+	}
+
+	p.video.requestedLayers = requested
+
+	// Let the owner know, the notifications are coalesced if the owner is not fast enough.
+	select {
+	case p.requestedLayersChanged <- struct{}{}:
+	default:
+	}
+}
+
+func equalLayers(a, b map[webrtc_ext.SimulcastLayer]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for layer, requested := range a {
+		if other, found := b[layer]; !found || other != requested {
+			return false
+		}
+	}
+
+	return true
+}
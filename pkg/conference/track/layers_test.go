@@ -0,0 +1,78 @@
+package track //nolint:testpackage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+type testSubscriber string
+
+func (s testSubscriber) String() string { return string(s) }
+
+func TestUpdateRequestedLayers(t *testing.T) {
+	low, mid, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+
+	published := &PublishedTrack[testSubscriber]{
+		logger:        logrus.NewEntry(logrus.New()),
+		telemetry:     telemetry.NewTelemetry(context.Background(), "test"),
+		info:          webrtc_ext.TrackInfo{Kind: webrtc.RTPCodecTypeVideo},
+		subscriptions: make(map[testSubscriber]*trackSubscription[testSubscriber]),
+		video: &videoTrack{
+			layout: webrtc_ext.SimulcastLayout{RIDs: []webrtc_ext.SimulcastRID{
+				{ID: "q", ScaleDownBy: 4},
+				{ID: "h", ScaleDownBy: 2},
+				{ID: "f", ScaleDownBy: 1},
+			}, Ordered: true},
+			publishers: map[webrtc_ext.SimulcastLayer]*trackPublisher{
+				low:  {stats: &publisherStats{}},
+				mid:  {stats: &publisherStats{}},
+				high: {stats: &publisherStats{}},
+			},
+		},
+		requestedLayersChanged: make(chan struct{}, 1),
+	}
+
+	changed := func() bool {
+		select {
+		case <-published.requestedLayersChanged:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Nobody is subscribed, so only the low layer is requested.
+	published.updateRequestedLayers()
+	expected := map[string]bool{"q": true, "h": false, "f": false}
+	if !changed() || !reflect.DeepEqual(published.RequestedLayers(), expected) {
+		t.Fatalf("unexpected requested layers: %v", published.RequestedLayers())
+	}
+
+	// Nothing changed, so no notification.
+	published.updateRequestedLayers()
+	if changed() {
+		t.Fatal("unexpected notification")
+	}
+
+	// A subscriber wants the high layer, but it currently gets the medium one.
+	published.subscriptions["a"] = &trackSubscription[testSubscriber]{currentLayer: mid, desiredLayer: high}
+	published.updateRequestedLayers()
+	expected = map[string]bool{"q": true, "h": true, "f": true}
+	if !changed() || !reflect.DeepEqual(published.RequestedLayers(), expected) {
+		t.Fatalf("unexpected requested layers: %v", published.RequestedLayers())
+	}
+
+	// The subscriber got the high layer, the medium layer is not needed anymore.
+	published.subscriptions["a"].currentLayer = high
+	published.updateRequestedLayers()
+	expected = map[string]bool{"q": true, "h": false, "f": true}
+	if !changed() || !reflect.DeepEqual(published.RequestedLayers(), expected) {
+		t.Fatalf("unexpected requested layers: %v", published.RequestedLayers())
+	}
+}
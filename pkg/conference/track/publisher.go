@@ -6,6 +6,7 @@ import (
 	"github.com/matrix-org/waterfall/pkg/conference/publisher"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
 )
@@ -17,8 +18,10 @@ type trackPublisher struct {
 	publisher *publisher.Publisher
 	// A channel to observe status changes on the publisher (stalled, recovered, stopped).
 	eventsChannel <-chan publisher.Status
-	// Keyframe request function.
-	requestKeyFrameFn func(*webrtc.TrackRemote) error
+	// Coalesces and throttles the key frame requests sent to the publisher, `nil` for audio.
+	keyFrames *keyFrameRequester
+	// Observed resolution and bitrate of the layer.
+	stats *publisherStats
 	// A simulcast layer that this publisher is responsible for.
 	layer webrtc_ext.SimulcastLayer
 	// Scoped logger.
@@ -29,26 +32,52 @@ type trackPublisher struct {
 
 func newTrackPublisher(
 	track *webrtc.TrackRemote,
-	reqKeyFrameFn func(track *webrtc.TrackRemote) error,
+	reqKeyFrameFn func(track *webrtc.TrackRemote, packetType webrtc_ext.RTCPPacketType, firSequenceNumber uint8) error,
+	keyFrameRequestInterval time.Duration,
 	stopPublishers <-chan struct{},
 	stallTimeout time.Duration,
+	resolutionChanged chan<- struct{},
 	layer webrtc_ext.SimulcastLayer,
 	logger *logrus.Entry,
 	telemetry *telemetry.Telemetry,
 ) *trackPublisher {
-	pub, pubCh := publisher.NewPublisher(
-		&publisher.RemoteTrack{track},
+	trackPublisher := &trackPublisher{
+		stats:     newPublisherStats(resolutionChanged, logger),
+		layer:     layer,
+		logger:    logger,
+		telemetry: telemetry,
+	}
+
+	// Key frames only make sense for video. The request is always sent to the track that is currently
+	// used by the publisher.
+	if track.Kind() == webrtc.RTPCodecTypeVideo {
+		trackPublisher.keyFrames = newKeyFrameRequester(
+			func(packetType webrtc_ext.RTCPPacketType, firSequenceNumber uint8) error {
+				track := trackPublisher.publisher.GetTrack().(*observedTrack) //nolint:forcetypeassert
+				return reqKeyFrameFn(track.Track, packetType, firSequenceNumber)
+			},
+			keyFrameRequestInterval,
+			track.Codec().RTPCodecCapability,
+			stopPublishers,
+			logger,
+		)
+	}
+
+	trackPublisher.publisher, trackPublisher.eventsChannel = publisher.NewPublisher(
+		trackPublisher.observe(track),
 		stopPublishers,
 		stallTimeout,
 		logger,
 	)
 
-	return &trackPublisher{pub, pubCh, reqKeyFrameFn, layer, logger, telemetry}
+	return trackPublisher
 }
 
 func (p *trackPublisher) addSubscription(subscription publisher.Subscription) {
 	p.publisher.AddSubscription(subscription)
-	p.requestKeyFrame()
+	if err := p.requestKeyFrame(); err != nil {
+		p.logger.WithError(err).Warn("Failed to request key frame for a new subscription")
+	}
 }
 
 func (p *trackPublisher) removeSubscription(subscription publisher.Subscription) {
@@ -60,7 +89,11 @@ func (p *trackPublisher) removeSubscriptions() []publisher.Subscription {
 }
 
 func (p *trackPublisher) replaceTrack(track *webrtc.TrackRemote) {
-	p.publisher.ReplaceTrack(&publisher.RemoteTrack{track})
+	p.publisher.ReplaceTrack(p.observe(track))
+}
+
+func (p *trackPublisher) resetStallTimer() {
+	p.publisher.ResetStallTimer()
 }
 
 func (p *trackPublisher) isStalled() bool {
@@ -68,6 +101,38 @@ func (p *trackPublisher) isStalled() bool {
 }
 
 func (p *trackPublisher) requestKeyFrame() error {
-	track := p.publisher.GetTrack().(*publisher.RemoteTrack) //nolint:forcetypeassert
-	return p.requestKeyFrameFn(track.Track)
+	if p.keyFrames == nil {
+		return nil
+	}
+
+	return p.keyFrames.request()
+}
+
+// Wraps the remote track, so that we could see the key frames and the bitrate of the publisher.
+func (p *trackPublisher) observe(track *webrtc.TrackRemote) *observedTrack {
+	return &observedTrack{publisher.RemoteTrack{Track: track}, track.Codec().RTPCodecCapability, p.keyFrames, p.stats}
+}
+
+// A remote track that informs the key frame requester and the statistics about each packet read from it.
+type observedTrack struct {
+	publisher.RemoteTrack
+	codec     webrtc.RTPCodecCapability
+	keyFrames *keyFrameRequester
+	stats     *publisherStats
+}
+
+// Implementation of `publisher.Track`.
+func (t *observedTrack) ReadPacket() (*rtp.Packet, error) {
+	packet, err := t.RemoteTrack.ReadPacket()
+	if err != nil {
+		return packet, err
+	}
+
+	if t.keyFrames != nil {
+		t.keyFrames.packetReceived(t.codec, packet)
+	}
+
+	t.stats.packetReceived(t.codec, packet)
+
+	return packet, err
 }
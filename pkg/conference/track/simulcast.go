@@ -1,73 +1,202 @@
 package track
 
 import (
+	"sort"
+
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/webrtc/v3"
 )
 
 // Metadata that we have received about this track from a user.
-// This metadata is only set for video tracks at the moment.
+// The dimensions are only set for video tracks.
 type TrackMetadata struct {
 	MaxWidth, MaxHeight int
 	Muted               bool
+	// Purpose of the stream that the track belongs to, e.g. "m.usermedia" or "m.screenshare".
+	Purpose string
+}
+
+// Resolution of a video layer, zero if unknown.
+type resolution struct {
+	width, height int
 }
 
-// Calculate the layer that we can use based on the requirements passed as parameters and available layers.
+// The combined length of width and height, that's what we compare when choosing the layer.
+func (r resolution) size() int {
+	return r.width + r.height
+}
+
+// Calculate the layer that we can use based on the requested resolution and available layers. The layers
+// are ordered from the lowest quality to the highest one. We pick the largest layer that does not exceed
+// the requested resolution, if there is no such layer (or the resolutions are unknown), we pick the lowest one.
 func getOptimalLayer(
-	layers map[webrtc_ext.SimulcastLayer]struct{},
-	metadata TrackMetadata,
+	available map[webrtc_ext.SimulcastLayer]struct{},
+	ordered []webrtc_ext.SimulcastLayer,
+	resolutions map[webrtc_ext.SimulcastLayer]resolution,
 	requestedWidth, requestedHeight int,
 ) webrtc_ext.SimulcastLayer {
 	// If we don't have any layers available, then there is no simulcast.
-	if _, found := layers[webrtc_ext.SimulcastLayerNone]; found || len(layers) == 0 {
+	if _, found := available[webrtc_ext.SimulcastLayerNone]; found || len(available) == 0 {
 		return webrtc_ext.SimulcastLayerNone
 	}
 
-	// Video track. Calculate the optimal layer closest to the requested resolution.
-	desiredLayer := calculateDesiredLayer(metadata.MaxWidth, metadata.MaxHeight, requestedWidth, requestedHeight)
+	requestedSize := requestedWidth + requestedHeight
+	lowest, optimal, optimalSize := webrtc_ext.SimulcastLayerNone, webrtc_ext.SimulcastLayerNone, 0
+
+	for _, layer := range ordered {
+		if _, found := available[layer]; !found {
+			continue
+		}
+
+		if lowest == webrtc_ext.SimulcastLayerNone {
+			lowest = layer
+		}
+
+		if size := resolutions[layer].size(); size > 0 && size <= requestedSize && size > optimalSize {
+			optimal, optimalSize = layer, size
+		}
+	}
 
-	// Ideally, here we would need to send an error if the desired layer is not available, but we don't
-	// have a way to do it. So we just return the closest available layer.
-	priority := []webrtc_ext.SimulcastLayer{
-		desiredLayer,
-		webrtc_ext.SimulcastLayerMedium,
-		webrtc_ext.SimulcastLayerLow,
-		webrtc_ext.SimulcastLayerHigh,
+	// If the desired layer is not available, we return the lowest available layer. The subscriber
+	// learns which layer and resolution it got from the result of its subscription request.
+	switch {
+	case optimal != webrtc_ext.SimulcastLayerNone:
+		return optimal
+	case lowest != webrtc_ext.SimulcastLayerNone:
+		return lowest
 	}
 
-	// More Go boilerplate.
-	for _, desiredLayer := range priority {
-		if _, found := layers[desiredLayer]; found {
-			return desiredLayer
+	// Actually this part will never be executed, because all layers that we have are part of the layout.
+	minimal := webrtc_ext.SimulcastLayerNone
+	for layer := range available {
+		if minimal == webrtc_ext.SimulcastLayerNone || layer < minimal {
+			minimal = layer
 		}
 	}
 
-	// Actually this part will never be executed, because if we got to this point,
-	// we know that we at least have one layer available.
-	return webrtc_ext.SimulcastLayerLow
+	return minimal
 }
 
-// Calculates the optimal layer closest to the requested resolution. We assume that the full resolution is the
-// maximum resolution that we can get from the user. We assume that a medium quality layer is half the size of
-// the video (**but not half of the resolution**). I.e. medium quality is high quality divided by 4. And low
-// quality is medium quality divided by 4 (which is the same as the high quality dividied by 16).
-func calculateDesiredLayer(fullWidth, fullHeight int, desiredWidth, desiredHeight int) webrtc_ext.SimulcastLayer {
-	// Calculate combined length of width and height for the full and desired size videos.
-	fullSize := fullWidth + fullHeight
-	desiredSize := desiredWidth + desiredHeight
-
-	if fullSize == 0 || desiredSize == 0 {
-		return webrtc_ext.SimulcastLayerLow
+// Orders the layers of the layout from the lowest quality to the highest one. The observed resolutions
+// are the most reliable source, then the order derived from the SDP, then the scale factors of the
+// well-known RIDs and finally the observed bitrates. If nothing helps, we keep the order of the SDP.
+func orderLayers(
+	layout webrtc_ext.SimulcastLayout,
+	stats map[webrtc_ext.SimulcastLayer]layerStats,
+) []webrtc_ext.SimulcastLayer {
+	layers := make([]webrtc_ext.SimulcastLayer, len(layout.RIDs))
+	for i := range layout.RIDs {
+		layers[i] = webrtc_ext.SimulcastLayer(i + 1)
 	}
 
-	// Determine which simulcast desiredLayer to subscribe to based on the requested resolution.
-	if ratio := float32(fullSize) / float32(desiredSize); ratio <= 1 {
-		return webrtc_ext.SimulcastLayerHigh
-	} else if ratio <= 2 {
-		return webrtc_ext.SimulcastLayerMedium
+	if sortLayersBy(layers, func(layer webrtc_ext.SimulcastLayer) int { return stats[layer].resolution.size() }) {
+		return layers
+	}
+
+	if layout.Ordered {
+		return layers
+	}
+
+	scaleDownBy := func(layer webrtc_ext.SimulcastLayer) int {
+		if rid, _ := layout.RID(layer); rid.ScaleDownBy > 0 {
+			// The higher the scale factor, the lower the quality.
+			return -rid.ScaleDownBy
+		}
+		return 0
+	}
+
+	if sortLayersBy(layers, scaleDownBy) {
+		return layers
+	}
+
+	sortLayersBy(layers, func(layer webrtc_ext.SimulcastLayer) int { return stats[layer].bitrate })
+	return layers
+}
+
+// Sorts the layers by a given key if all of them have it (i.e. it's not zero).
+func sortLayersBy(layers []webrtc_ext.SimulcastLayer, key func(webrtc_ext.SimulcastLayer) int) bool {
+	for _, layer := range layers {
+		if key(layer) == 0 {
+			return false
+		}
+	}
+
+	sort.SliceStable(layers, func(i, j int) bool {
+		return key(layers[i]) < key(layers[j])
+	})
+
+	return true
+}
+
+// Estimates the resolutions of the ordered layers. We prefer the resolutions observed in the bitstream,
+// then the restrictions from the SDP, then the scale factors of the well-known RIDs applied to the full
+// resolution (observed for the highest layer or taken from the metadata). If nothing is known, we assume
+// that each layer is half the size of the next one (i.e. a quarter of its resolution).
+func estimateResolutions(
+	ordered []webrtc_ext.SimulcastLayer,
+	layout webrtc_ext.SimulcastLayout,
+	stats map[webrtc_ext.SimulcastLayer]layerStats,
+	metadata TrackMetadata,
+) map[webrtc_ext.SimulcastLayer]resolution {
+	resolutions := make(map[webrtc_ext.SimulcastLayer]resolution, len(ordered))
+
+	// The metadata is provided by the client and may be outdated, so we prefer what we see.
+	full := resolution{metadata.MaxWidth, metadata.MaxHeight}
+	if len(ordered) > 0 && stats[ordered[len(ordered)-1]].resolution.size() > 0 {
+		full = stats[ordered[len(ordered)-1]].resolution
+	}
+
+	for position, layer := range ordered {
+		rid, _ := layout.RID(layer)
+		scaleDownBy := 1 << (len(ordered) - position - 1)
+		if rid.ScaleDownBy > 0 {
+			scaleDownBy = rid.ScaleDownBy
+		}
+
+		switch {
+		case stats[layer].resolution.size() > 0:
+			resolutions[layer] = stats[layer].resolution
+		case rid.MaxWidth > 0 && rid.MaxHeight > 0:
+			resolutions[layer] = resolution{rid.MaxWidth, rid.MaxHeight}
+		default:
+			resolutions[layer] = resolution{full.width / scaleDownBy, full.height / scaleDownBy}
+		}
+	}
+
+	return resolutions
+}
+
+// Calculates the optimal layer among the given ones for the requested resolution.
+func (t *videoTrack) optimalLayer(
+	available map[webrtc_ext.SimulcastLayer]struct{},
+	metadata TrackMetadata,
+	requestedWidth, requestedHeight int,
+) webrtc_ext.SimulcastLayer {
+	stats := t.stats()
+	ordered := orderLayers(t.layout, stats)
+	resolutions := estimateResolutions(ordered, t.layout, stats, metadata)
+	return getOptimalLayer(available, ordered, resolutions, requestedWidth, requestedHeight)
+}
+
+// Returns the lowest layer that has a publisher or `SimulcastLayerNone` if there is no such layer.
+func (t *videoTrack) lowestLayer() webrtc_ext.SimulcastLayer {
+	for _, layer := range orderLayers(t.layout, t.stats()) {
+		if t.publishers[layer] != nil {
+			return layer
+		}
+	}
+
+	return webrtc_ext.SimulcastLayerNone
+}
+
+// Collects the observed statistics of all layers.
+func (t *videoTrack) stats() map[webrtc_ext.SimulcastLayer]layerStats {
+	stats := make(map[webrtc_ext.SimulcastLayer]layerStats, len(t.publishers))
+	for layer, publisher := range t.publishers {
+		stats[layer] = publisher.stats.snapshot()
 	}
 
-	return webrtc_ext.SimulcastLayerLow
+	return stats
 }
 
 // Does this published track contain any simulcast tracks or is it a non-simulcast published track.
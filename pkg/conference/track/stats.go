@@ -0,0 +1,108 @@
+package track
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// The interval over which we measure the bitrate and the frame rate of a publisher.
+const statsWindow = time.Second
+
+// What we observed about a single layer so far.
+type layerStats struct {
+	// Resolution from the latest key frame (VP8) or SPS (H.264), zero if unknown.
+	resolution resolution
+	// Bitrate in bits per second measured over the last complete window, zero if unknown.
+	bitrate int
+	// Frames per second measured over the last complete window, zero if unknown.
+	frameRate float64
+	// Number of temporal layers seen within the last complete window, zero if unknown.
+	temporalLayers int
+}
+
+// Collects the statistics of the packets that a publisher receives. It's updated from
+// the publisher's go-routine and read while choosing the layers, hence the mutex.
+type publisherStats struct {
+	mutex   sync.Mutex
+	current layerStats
+	// Packets, frames and temporal layers received within the current window.
+	bytes, frames, temporalLayers int
+	windowStart                   time.Time
+	lastTimestamp                 uint32
+	// Informs that the resolution changed, the notifications are coalesced, may be `nil`.
+	resolutionChanged chan<- struct{}
+	logger            *logrus.Entry
+}
+
+func newPublisherStats(resolutionChanged chan<- struct{}, logger *logrus.Entry) *publisherStats {
+	return &publisherStats{resolutionChanged: resolutionChanged, logger: logger}
+}
+
+func (s *publisherStats) packetReceived(codec webrtc.RTPCodecCapability, packet *rtp.Packet) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+	}
+
+	// All packets of a single frame share the same timestamp.
+	s.bytes += len(packet.Payload)
+	if s.frames == 0 || packet.Timestamp != s.lastTimestamp {
+		s.frames++
+		s.lastTimestamp = packet.Timestamp
+	}
+
+	isVP8 := strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8)
+	if isVP8 {
+		if temporalLayer, found := vp8TemporalLayer(packet.Payload); found && int(temporalLayer) >= s.temporalLayers {
+			s.temporalLayers = int(temporalLayer) + 1
+		}
+	}
+
+	if elapsed := now.Sub(s.windowStart); elapsed >= statsWindow {
+		s.current.bitrate = int(float64(s.bytes*8) / elapsed.Seconds())
+		s.current.frameRate = float64(s.frames) / elapsed.Seconds()
+		s.current.temporalLayers = s.temporalLayers
+		s.bytes, s.frames, s.temporalLayers, s.windowStart = 0, 0, 0, now
+	}
+
+	width, height, found := 0, 0, false
+
+	switch {
+	case isVP8:
+		width, height, found = vp8Resolution(packet.Payload)
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264):
+		width, height, found = h264Resolution(packet.Payload)
+	}
+
+	if !found || (resolution{width, height}) == s.current.resolution {
+		return
+	}
+
+	s.current.resolution = resolution{width, height}
+	s.logger.WithFields(logrus.Fields{
+		"width":      width,
+		"height":     height,
+		"frame_rate": s.current.frameRate,
+	}).Info("Resolution changed")
+
+	if s.resolutionChanged != nil {
+		select {
+		case s.resolutionChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *publisherStats) snapshot() layerStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.current
+}
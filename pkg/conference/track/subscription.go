@@ -14,7 +14,31 @@ import (
 type trackSubscription[SubscriberID SubscriberIdentifier] struct {
 	subscription subscription.Subscription
 	currentLayer webrtc_ext.SimulcastLayer
+	// The layer that the subscriber would get if all layers were active.
+	desiredLayer webrtc_ext.SimulcastLayer
 	subscriberID SubscriberID
+	// What the subscriber asked for.
+	requirements SubscriptionRequirements
+	// The highest layer that the subscription may get, it's lowered when the subscriber's network
+	// is congested. `SimulcastLayerNone` means that there is no limit.
+	maxLayer webrtc_ext.SimulcastLayer
+	// The layer that the admin forced the subscription to use, `SimulcastLayerNone` if not forced.
+	forcedLayer webrtc_ext.SimulcastLayer
+	// The layer that the subscriber knows about and the reason of the latest change of the layer.
+	reportedLayer     webrtc_ext.SimulcastLayer
+	layerChangeReason LayerChangeReason
+}
+
+// What the subscriber wants to get from the track.
+type SubscriptionRequirements struct {
+	// Desired resolution of the video, zeros if not known.
+	Width, Height int
+	// The maximum frame rate that the subscriber needs (e.g. for a thumbnail), zero if not limited.
+	MaxFrameRate float64
+	// Priority of the subscription relative to the other subscriptions of the same subscriber, the
+	// higher the value, the more important the subscription is. The subscriptions with the lower
+	// priority get degraded first when the subscriber's network is congested.
+	Priority int
 }
 
 // Implementation of `subscription.Subscription`.
@@ -27,6 +51,33 @@ func (s *trackSubscription[SubscriberID]) WriteRTP(packet rtp.Packet) error {
 	return s.subscription.WriteRTP(packet)
 }
 
+// Subscriptions that can be muted on the server side.
+type mutableSubscription interface {
+	SetMuted(muted bool)
+}
+
+// Subscriptions that can drop the temporal layers to lower the frame rate.
+type temporalSubscription interface {
+	SetMaxTemporalLayer(layer uint8)
+}
+
+// Subscriptions that know how well the subscriber receives the packets.
+type measurableSubscription interface {
+	PacketLoss() float64
+}
+
+// Subscriptions that count the forwarded packets.
+type statsSubscription interface {
+	Stats() subscription.Stats
+}
+
+// Mutes or unmutes the subscription if it supports it.
+func (s *trackSubscription[SubscriberID]) setMuted(muted bool) {
+	if sub, ok := s.subscription.(mutableSubscription); ok {
+		sub.SetMuted(muted)
+	}
+}
+
 func (p *PublishedTrack[SubscriberID]) processSubscriptionEvents(
 	sub *trackSubscription[SubscriberID],
 	events <-chan subscription.KeyFrameRequest,
@@ -47,6 +98,7 @@ func (p *PublishedTrack[SubscriberID]) processSubscriptionEvents(
 	}
 
 	delete(p.subscriptions, sub.subscriberID)
+	p.updateRequestedLayers()
 }
 
 func (p *PublishedTrack[SubscriberID]) processKeyFrameRequest(sub *trackSubscription[SubscriberID]) error {
@@ -1,9 +1,12 @@
 package track
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/matrix-org/waterfall/pkg/conference/publisher"
 	"github.com/matrix-org/waterfall/pkg/conference/subscription"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
@@ -20,6 +23,11 @@ type SubscriberIdentifier interface {
 
 type TrackID = string
 
+var (
+	ErrTrackClosed          = errors.New("track is already closed")
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+)
+
 // Represents a track that a peer has published (has already started sending to the SFU).
 type PublishedTrack[SubscriberID SubscriberIdentifier] struct {
 	// Logger.
@@ -35,12 +43,21 @@ type PublishedTrack[SubscriberID SubscriberIdentifier] struct {
 	mutex sync.Mutex
 	// Currently active subscriptions for this track.
 	subscriptions map[SubscriberID]*trackSubscription[SubscriberID]
-	// Audio track data. The content will be `nil` if it's not an audio track.
-	audio *audioTrack
 	// Video track. The content will be `nil` if it's not a video track.
 	video *videoTrack
+	// Audio publisher. The content will be `nil` if it's not an audio track.
+	audio *trackPublisher
+	// Set if a moderator muted the track, i.e. it must not be forwarded to the subscribers.
+	mutedByModerator bool
 	// Track metadata.
 	metadata TrackMetadata
+	// Whether the track is muted by its owner. It's updated from the metadata, each change of the state
+	// is handled explicitly, since it changes the meaning of a stalled publisher.
+	muted bool
+	// Set if we informed the owner about the stalled audio publisher and have not informed about the recovery yet.
+	audioStallReported bool
+	// Minimal interval between two key frame requests sent to a single publisher (layer).
+	keyFrameRequestInterval time.Duration
 
 	// Wait group for all active publishers.
 	activePublishers *sync.WaitGroup
@@ -48,12 +65,22 @@ type PublishedTrack[SubscriberID SubscriberIdentifier] struct {
 	stopPublishers chan struct{}
 	// A aignal to inform the caller that all publishers of this track **have been stopped**.
 	done chan struct{}
+	// Unexpected status changes of the track, e.g. the audio that is stalled while not being muted.
+	statusChanges chan publisher.Status
+	// Informs that the layers requested from the owner changed.
+	requestedLayersChanged chan struct{}
+	// Informs that the resolution of one of the layers observed in the bitstream changed.
+	resolutionChanged chan struct{}
+	// Informs that the SFU switched some subscriptions to different layers.
+	subscriptionLayerChanged chan struct{}
 }
 
 func NewPublishedTrack[SubscriberID SubscriberIdentifier](
 	ownerID SubscriberID,
-	requestKeyFrame func(track *webrtc.TrackRemote) error,
+	requestKeyFrame func(track *webrtc.TrackRemote, packetType webrtc_ext.RTCPPacketType, firSequenceNumber uint8) error,
+	keyFrameRequestInterval time.Duration,
 	track *webrtc.TrackRemote,
+	simulcast webrtc_ext.SimulcastLayout,
 	metadata TrackMetadata,
 	logger *logrus.Entry,
 	telemetryBuilder *telemetry.ChildBuilder,
@@ -65,44 +92,31 @@ func NewPublishedTrack[SubscriberID SubscriberIdentifier](
 	)
 
 	published := &PublishedTrack[SubscriberID]{
-		logger:           logger.WithField("track", track.ID()),
-		info:             webrtc_ext.TrackInfoFromTrack(track),
-		telemetry:        telemetry,
-		owner:            trackOwner[SubscriberID]{ownerID, requestKeyFrame},
-		subscriptions:    make(map[SubscriberID]*trackSubscription[SubscriberID]),
-		audio:            &audioTrack{outputTrack: nil},
-		video:            &videoTrack{publishers: make(map[webrtc_ext.SimulcastLayer]*trackPublisher)},
-		metadata:         metadata,
-		activePublishers: &sync.WaitGroup{},
-		stopPublishers:   make(chan struct{}),
-		done:             make(chan struct{}),
+		logger:        logger.WithField("track", track.ID()),
+		info:          webrtc_ext.TrackInfoFromTrack(track),
+		telemetry:     telemetry,
+		owner:         trackOwner[SubscriberID]{ownerID, requestKeyFrame},
+		subscriptions: make(map[SubscriberID]*trackSubscription[SubscriberID]),
+		video: &videoTrack{
+			layout:     simulcast,
+			publishers: make(map[webrtc_ext.SimulcastLayer]*trackPublisher),
+		},
+		metadata:                 metadata,
+		muted:                    metadata.Muted,
+		keyFrameRequestInterval:  keyFrameRequestInterval,
+		activePublishers:         &sync.WaitGroup{},
+		stopPublishers:           make(chan struct{}),
+		done:                     make(chan struct{}),
+		statusChanges:            make(chan publisher.Status),
+		requestedLayersChanged:   make(chan struct{}, 1),
+		resolutionChanged:        make(chan struct{}, 1),
+		subscriptionLayerChanged: make(chan struct{}, 1),
 	}
 
 	switch published.info.Kind {
 	case webrtc.RTPCodecTypeAudio:
-		// Create a local track, all our SFU clients that are subscribed to this
-		// peer (publisher) wil be fed via this track.
-		localTrack, err := webrtc.NewTrackLocalStaticRTP(
-			track.Codec().RTPCodecCapability,
-			track.ID(),
-			track.StreamID(),
-		)
-		if err != nil {
-			telemetry.Fail(err)
-			telemetry.End()
-			return nil, err
-		}
-
-		published.audio.outputTrack = localTrack
-
-		// Start audio publisher in a separate goroutine.
-		published.activePublishers.Add(1)
-		go func() {
-			defer published.activePublishers.Done()
-			if err := forward(track, localTrack, published.stopPublishers); err != nil {
-				logger.Infof("audio publisher stopped: %v", err)
-			}
-		}()
+		// Start audio publisher.
+		published.addAudioPublisher(track)
 
 	case webrtc.RTPCodecTypeVideo:
 		// Start video publisher.
@@ -132,7 +146,7 @@ func NewPublishedTrack[SubscriberID SubscriberIdentifier](
 // have multiple qualities (layers) on a single track.
 func (p *PublishedTrack[SubscriberID]) AddPublisher(track *webrtc.TrackRemote) error {
 	if p.isClosed() {
-		return fmt.Errorf("track is already closed")
+		return ErrTrackClosed
 	}
 
 	info := webrtc_ext.TrackInfoFromTrack(track)
@@ -140,13 +154,20 @@ func (p *PublishedTrack[SubscriberID]) AddPublisher(track *webrtc.TrackRemote) e
 		return fmt.Errorf("track mismatch")
 	}
 
-	// Such publisher already exists. Let's replace the track that provides frames with a new one.
-	simulcast := webrtc_ext.RIDToSimulcastLayer(track.RID())
-
 	// Lock the mutex since we access the publishers from multiple threads.
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	// Audio has a single publisher, so the only thing that may happen is that the SSRC changes.
+	if p.audio != nil {
+		p.telemetry.AddEvent("replacing audio publisher")
+		p.audio.replaceTrack(track)
+		return nil
+	}
+
+	// Such publisher already exists. Let's replace the track that provides frames with a new one.
+	simulcast := p.video.layout.Layer(track.RID())
+
 	// If the publisher for this track already exists, let's replace the track. This may happen during
 	// the negotiation when the SSRC changes and Pion fires a new track for the track that has already
 	// been published.
@@ -175,12 +196,11 @@ func (p *PublishedTrack[SubscriberID]) Stop() {
 func (p *PublishedTrack[SubscriberID]) Subscribe(
 	subscriberID SubscriberID,
 	controller subscription.SubscriptionController,
-	desiredWidth int,
-	desiredHeight int,
+	requirements SubscriptionRequirements,
 	logger *logrus.Entry,
 ) error {
 	if p.isClosed() {
-		return fmt.Errorf("track is already closed")
+		return ErrTrackClosed
 	}
 
 	// Lock the mutex as we access subscriptions and publishers from multiple threads.
@@ -191,34 +211,15 @@ func (p *PublishedTrack[SubscriberID]) Subscribe(
 	// change the existing subscription (e.g. if a different simulcast track is desired for a given
 	// subscription).
 	if sub := p.subscriptions[subscriberID]; sub != nil {
-		// Non-simulcast tracks can't be updated, so if the subscription exists already, no need to do anything.
-		if !p.isSimulcast() {
-			return nil
-		}
-
-		// We're dealing with a simulcast track if we're here, so let's calculate the optimal layer.
-		layer := getOptimalLayer(p.video.activeLayers(), p.metadata, desiredWidth, desiredHeight)
-
-		// Let's see if the current layer matches what the subscriber wants.
-		if sub.currentLayer != layer {
-			// It could be that all subscriptions are subscribed to `LayerNone` (i.e. to no publisher,
-			// since all the available publishers are stalled). In this case `p.video.publishers[LayerNone]`
-			// would be nil.
-			if currentPublisher := p.video.publishers[sub.currentLayer]; currentPublisher != nil {
-				currentPublisher.removeSubscription(sub)
-			}
-
-			newPublisher := p.video.publishers[layer]
-			newPublisher.addSubscription(sub)
-
-			sub.currentLayer = layer
-		}
-
+		sub.requirements = requirements
+		p.updateSubscription(sub, LayerChangeRequested)
+		// The subscriber learns about the new layer from the result of its request.
+		sub.reportedLayer = sub.currentLayer
 		return nil
 	}
 
 	// If we got here, then we need to create a new subscription.
-	var layer webrtc_ext.SimulcastLayer
+	var layer, desiredLayer webrtc_ext.SimulcastLayer
 	sub, ch, err := func() (subscription.Subscription, <-chan subscription.KeyFrameRequest, error) {
 		// Subscription does not exist, so let's create it.
 		switch p.info.Kind {
@@ -229,10 +230,18 @@ func (p *PublishedTrack[SubscriberID]) Subscribe(
 				logger.WithField("track", p.info.TrackID),
 				p.telemetry.ChildBuilder(attribute.String("id", subscriberID.String())),
 			)
-			layer = getOptimalLayer(p.video.activeLayers(), p.metadata, desiredWidth, desiredHeight)
+			layer, desiredLayer = p.subscriptionLayers(
+				requirements,
+				webrtc_ext.SimulcastLayerNone,
+				webrtc_ext.SimulcastLayerNone,
+			)
 			return sub, ch, err
 		case webrtc.RTPCodecTypeAudio:
-			sub, err := subscription.NewAudioSubscription(p.audio.outputTrack, controller)
+			sub, err := subscription.NewAudioSubscription(
+				p.info,
+				controller,
+				logger.WithField("track", p.info.TrackID),
+			)
 			return sub, nil, err
 		default:
 			return nil, nil, fmt.Errorf("unsupported track kind: %v", p.info.Kind)
@@ -244,19 +253,73 @@ func (p *PublishedTrack[SubscriberID]) Subscribe(
 	}
 
 	// Add the subscription to the list of subscriptions.
-	subscription := &trackSubscription[SubscriberID]{sub, layer, subscriberID}
+	subscription := &trackSubscription[SubscriberID]{
+		subscription:  sub,
+		currentLayer:  layer,
+		desiredLayer:  desiredLayer,
+		subscriberID:  subscriberID,
+		requirements:  requirements,
+		reportedLayer: layer,
+	}
 	p.subscriptions[subscriberID] = subscription
 
-	// And if it's a video subscription, add it to the list of subscriptions that get the feed from the publisher.
-	if p.info.Kind == webrtc.RTPCodecTypeVideo {
-		p.video.publishers[layer].addSubscription(subscription)
+	// Add the subscription to the list of subscriptions that get the feed from the publisher.
+	switch p.info.Kind {
+	case webrtc.RTPCodecTypeVideo:
+		// If all publishers are stalled, the subscription gets a publisher once one of them recovers.
+		if pub := p.video.publishers[layer]; pub != nil {
+			pub.addSubscription(subscription)
+		}
 		go p.processSubscriptionEvents(subscription, ch)
+		p.updateTemporalLayer(subscription)
+		p.updateRequestedLayers()
+	case webrtc.RTPCodecTypeAudio:
+		subscription.setMuted(p.mutedByModerator)
+		p.audio.addSubscription(subscription)
 	}
 
 	p.logger.WithField("subscriber", subscriberID).WithField("layer", layer).Info("New subscription")
 	return nil
 }
 
+// Switches the existing subscription to a different layer if necessary. Must be called with the mutex locked.
+func (p *PublishedTrack[SubscriberID]) updateSubscription(
+	sub *trackSubscription[SubscriberID],
+	reason LayerChangeReason,
+) {
+	// The frame rate limit may change for any video track, not only for a simulcast one.
+	defer p.updateTemporalLayer(sub)
+
+	// Non-simulcast tracks can't be updated, so if the subscription exists already, no need to do anything.
+	if !p.isSimulcast() {
+		return
+	}
+
+	// We're dealing with a simulcast track if we're here, so let's calculate the optimal layer along with
+	// the layer that the subscriber would get if all layers were active. If it's paused, it gets resumed.
+	layer, desiredLayer := p.subscriptionLayers(sub.requirements, sub.maxLayer, sub.forcedLayer)
+	sub.desiredLayer = desiredLayer
+	defer p.updateRequestedLayers()
+
+	// Let's see if the current layer matches what the subscriber wants.
+	if sub.currentLayer != layer {
+		// It could be that all subscriptions are subscribed to `LayerNone` (i.e. to no publisher,
+		// since all the available publishers are stalled). In this case `p.video.publishers[LayerNone]`
+		// would be nil.
+		if currentPublisher := p.video.publishers[sub.currentLayer]; currentPublisher != nil {
+			currentPublisher.removeSubscription(sub)
+		}
+
+		// The new layer may not exist if all publishers are stalled, then the subscription
+		// stays orphaned until one of the publishers recovers.
+		if newPublisher := p.video.publishers[layer]; newPublisher != nil {
+			newPublisher.addSubscription(sub)
+		}
+
+		p.switchSubscriptionLayer(sub, layer, reason)
+	}
+}
+
 // Remove subscriptions with a given subscriber id.
 func (p *PublishedTrack[SubscriberID]) Unsubscribe(subscriberID SubscriberID) {
 	p.mutex.Lock()
@@ -266,12 +329,26 @@ func (p *PublishedTrack[SubscriberID]) Unsubscribe(subscriberID SubscriberID) {
 		sub.Unsubscribe()
 		delete(p.subscriptions, subscriberID)
 
-		if p.info.Kind == webrtc.RTPCodecTypeVideo {
-			p.video.publishers[sub.currentLayer].removeSubscription(sub)
+		switch p.info.Kind {
+		case webrtc.RTPCodecTypeVideo:
+			if pub := p.video.publishers[sub.currentLayer]; pub != nil {
+				pub.removeSubscription(sub)
+			}
+			p.updateRequestedLayers()
+		case webrtc.RTPCodecTypeAudio:
+			p.audio.removeSubscription(sub)
 		}
 	}
 }
 
+// Checks if a given subscriber is subscribed to the track.
+func (p *PublishedTrack[SubscriberID]) IsSubscribed(subscriberID SubscriberID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.subscriptions[subscriberID] != nil
+}
+
 func (p *PublishedTrack[SubscriberID]) Owner() SubscriberID {
 	return p.owner.owner
 }
@@ -284,6 +361,33 @@ func (p *PublishedTrack[SubscriberID]) Done() <-chan struct{} {
 	return p.done
 }
 
+// Returns a channel that informs about the unexpected status changes of the track, i.e. when the
+// track stops receiving packets while it's not muted and when it recovers afterwards.
+func (p *PublishedTrack[SubscriberID]) StatusChanges() <-chan publisher.Status {
+	return p.statusChanges
+}
+
+// Returns the resolution of the highest layer of a video track as observed in the bitstream,
+// zeros if it's not known (yet).
+func (p *PublishedTrack[SubscriberID]) Resolution() (int, int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var highest resolution
+	for _, stats := range p.video.stats() {
+		if stats.resolution.size() > highest.size() {
+			highest = stats.resolution
+		}
+	}
+
+	return highest.width, highest.height
+}
+
+// Returns a channel that informs that the resolution of the track observed in the bitstream changed.
+func (p *PublishedTrack[SubscriberID]) ResolutionChanged() <-chan struct{} {
+	return p.resolutionChanged
+}
+
 func (p *PublishedTrack[SubscriberID]) Metadata() TrackMetadata {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
@@ -295,6 +399,43 @@ func (p *PublishedTrack[SubscriberID]) SetMetadata(metadata TrackMetadata) {
 	defer p.mutex.Unlock()
 
 	p.metadata = metadata
+
+	switch {
+	case metadata.Muted && !p.muted:
+		p.muted = true
+		p.logger.Info("Track is muted")
+		p.telemetry.AddEvent("muted")
+
+	case !metadata.Muted && p.muted:
+		p.muted = false
+		p.logger.Info("Track is unmuted")
+		p.telemetry.AddEvent("unmuted")
+		p.resetStallTimers()
+	}
+}
+
+// Mutes or unmutes the track for all subscribers on behalf of a moderator. Only audio can be muted.
+func (p *PublishedTrack[SubscriberID]) SetMutedByModerator(muted bool) error {
+	if p.info.Kind != webrtc.RTPCodecTypeAudio {
+		return fmt.Errorf("only audio tracks can be muted by a moderator")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.mutedByModerator = muted
+	for _, subscription := range p.subscriptions {
+		subscription.setMuted(muted)
+	}
+
+	p.telemetry.AddEvent("muted by moderator", attribute.Bool("muted", muted))
+	return nil
+}
+
+func (p *PublishedTrack[SubscriberID]) MutedByModerator() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.mutedByModerator
 }
 
 func (p *PublishedTrack[SubscriberID]) isClosed() bool {
@@ -2,27 +2,30 @@ package track
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/matrix-org/waterfall/pkg/conference/publisher"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/attribute"
 )
 
 type trackOwner[SubscriberID comparable] struct {
 	owner           SubscriberID
-	requestKeyFrame func(track *webrtc.TrackRemote) error
-}
-
-type audioTrack struct {
-	// The sink of this audio track packets.
-	outputTrack *webrtc.TrackLocalStaticRTP
+	requestKeyFrame func(track *webrtc.TrackRemote, packetType webrtc_ext.RTCPPacketType, firSequenceNumber uint8) error
 }
 
 type videoTrack struct {
+	// Simulcast layers of the track as negotiated in the SDP, empty if the track has no simulcast.
+	layout webrtc_ext.SimulcastLayout
 	// Publishers of each video layer.
 	publishers map[webrtc_ext.SimulcastLayer]*trackPublisher
+	// Layers that the owner of the track is asked to send (`true`) or to pause (`false`).
+	// It's `nil` until we ask the owner for anything, the owner sends all layers by default.
+	requestedLayers map[webrtc_ext.SimulcastLayer]bool
 }
 
 // Get the set of active layers (the tricky return type is a simulation of a `HashSet` in Golang).
@@ -37,45 +40,142 @@ func (t *videoTrack) activeLayers() map[webrtc_ext.SimulcastLayer]struct{} {
 	return layers
 }
 
-// Forward audio packets from the source track to the destination track.
-func forward(sender *webrtc.TrackRemote, receiver *webrtc.TrackLocalStaticRTP, stop <-chan struct{}) error {
-	for {
-		// Read the data from the remote track.
-		packet, _, readErr := sender.ReadRTP()
-		if readErr != nil {
-			return readErr
-		}
+// Audio publishers are considered stalled if there are no packets within this interval.
+const audioStallTimeout = 2 * time.Second
+
+// With DTX (discontinuous transmission) Opus sends almost nothing during silence (libwebrtc sends
+// a packet every 400 ms, but other implementations may send even less), so we wait longer.
+const audioDTXStallTimeout = 10 * time.Second
+
+func (p *PublishedTrack[SubscriberID]) addAudioPublisher(track *webrtc.TrackRemote) {
+	stallTimeout := audioStallTimeout
+	if usesDTX(track.Codec()) {
+		stallTimeout = audioDTXStallTimeout
+	}
+
+	// Create a publisher. There are no simulcast layers for audio.
+	trackPublisher := newTrackPublisher(
+		track,
+		p.owner.requestKeyFrame,
+		p.keyFrameRequestInterval,
+		p.stopPublishers,
+		stallTimeout,
+		nil, // Audio has no resolution.
+		webrtc_ext.SimulcastLayerNone,
+		p.logger,
+		p.telemetry.CreateChild("audio"),
+	)
+
+	p.audio = trackPublisher
+
+	// Start publisher's goroutine.
+	p.activePublishers.Add(1)
+	go func() {
+		// Once this go-routine is done, inform that this publisher is stopped.
+		defer p.activePublishers.Done()
+		defer trackPublisher.telemetry.End()
+
+		// The subscriptions stay with the publisher even if it's stalled, since there is nothing
+		// to switch to. We only need to report the stalls that are not expected.
+		for status := range trackPublisher.eventsChannel {
+			switch status {
+			case publisher.StatusStalled:
+				metrics.PublisherStalls.With("audio").Inc()
+				if p.handleStalledAudioPublisher() {
+					p.reportStatus(status)
+				}
 
-		// Write the data to the local track.
-		if writeErr := receiver.WriteRTP(packet); writeErr != nil {
-			return writeErr
+			case publisher.StatusRecovered:
+				trackPublisher.logger.Info("Audio publisher is recovered")
+				trackPublisher.telemetry.AddEvent("recovered")
+				if p.handleRecoveredAudioPublisher() {
+					p.reportStatus(status)
+				}
+			}
 		}
 
-		// Check if we need to stop processing packets.
-		select {
-		case <-stop:
-			return nil
-		default:
+		trackPublisher.telemetry.AddEvent("stopped")
+	}()
+}
+
+// Handles the stalled audio publisher. Returns `true` if the stall is unexpected and must be reported.
+func (p *PublishedTrack[SubscriberID]) handleStalledAudioPublisher() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.checkStalledAudioPublisher()
+}
+
+// Checks if the stall of the audio publisher is unexpected. Must be called with the mutex locked.
+func (p *PublishedTrack[SubscriberID]) checkStalledAudioPublisher() bool {
+	// It's ok to not receive any packets if the track is muted.
+	if p.muted {
+		p.audio.logger.Info("No RTPs (muted)")
+		p.audio.telemetry.AddEvent("No RTPs (muted)")
+		return false
+	}
+
+	if p.audioStallReported {
+		return false
+	}
+
+	p.audio.logger.Warn("Audio publisher is stalled")
+	p.audio.telemetry.Fail(fmt.Errorf("stalled"))
+	p.audioStallReported = true
+	return true
+}
+
+// Handles the recovered audio publisher. Returns `true` if we reported the stall before.
+func (p *PublishedTrack[SubscriberID]) handleRecoveredAudioPublisher() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	reported := p.audioStallReported
+	p.audioStallReported = false
+	return reported
+}
+
+// Informs the owner of the published track about the status change unless the track is stopped.
+func (p *PublishedTrack[SubscriberID]) reportStatus(status publisher.Status) {
+	select {
+	case p.statusChanges <- status:
+	case <-p.stopPublishers:
+	}
+}
+
+// Determines if the publisher negotiated DTX for the codec.
+func usesDTX(codec webrtc.RTPCodecParameters) bool {
+	for _, parameter := range strings.Split(codec.SDPFmtpLine, ";") {
+		if strings.TrimSpace(parameter) == "usedtx=1" {
+			return true
 		}
 	}
+
+	return false
 }
 
 func (p *PublishedTrack[SubscriberID]) addVideoPublisher(track *webrtc.TrackRemote) {
 	// Detect simulcast layer of a publisher and create loggers and scoped telemetry.
-	simulcast := webrtc_ext.RIDToSimulcastLayer(track.RID())
+	simulcast := p.video.layout.Layer(track.RID())
 
 	// Create a publisher.
 	trackPublisher := newTrackPublisher(
 		track,
 		p.owner.requestKeyFrame,
+		p.keyFrameRequestInterval,
 		p.stopPublishers,
 		2*time.Second, // We consider publisher as stalled if there are no packets within 2 seconds.
+		p.resolutionChanged,
 		simulcast,
-		p.logger.WithField("layer", simulcast.String()),
-		p.telemetry.CreateChild("layer", attribute.String("layer", simulcast.String())),
+		p.logger.WithFields(logrus.Fields{"layer": simulcast.String(), "rid": track.RID()}),
+		p.telemetry.CreateChild(
+			"layer",
+			attribute.String("layer", simulcast.String()),
+			attribute.String("rid", track.RID()),
+		),
 	)
 
 	p.video.publishers[simulcast] = trackPublisher
+	p.updateRequestedLayers()
 
 	// Start publisher's goroutine.
 	p.activePublishers.Add(1)
@@ -89,6 +189,7 @@ func (p *PublishedTrack[SubscriberID]) addVideoPublisher(track *webrtc.TrackRemo
 			switch status {
 			case publisher.StatusStalled:
 				// Publisher is not active (no packets received for a while).
+				metrics.PublisherStalls.With("video").Inc()
 				p.handleStalledPublisher(trackPublisher)
 
 			case publisher.StatusRecovered:
@@ -119,11 +220,14 @@ func (p *PublishedTrack[SubscriberID]) addVideoPublisher(track *webrtc.TrackRemo
 		// TODO: Do we need to do it? Can publishers **fail** during the call and get created by Pion automatically?
 		for layer, pub := range p.video.publishers {
 			for _, sub := range pub.removeSubscriptions() {
-				sub.(*trackSubscription[SubscriberID]).currentLayer = layer //nolint:forcetypeassert
+				//nolint:forcetypeassert
+				p.switchSubscriptionLayer(sub.(*trackSubscription[SubscriberID]), layer, LayerChangePublisherStopped)
 				pub.addSubscription(sub)
 			}
 			break
 		}
+
+		p.updateRequestedLayers()
 	}()
 }
 
@@ -131,18 +235,43 @@ func (p *PublishedTrack[SubscriberID]) handleStalledPublisher(pub *trackPublishe
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	// Let's check if we're muted. If we are, it's ok to not receive packets.
-	//
-	// FIXME: What if the track gets unmuted? We won't get a "stalled" notification if
-	//        it went to the stalled state right after the unmute, since the track was
-	//        already marked as stalled. Edge-case?
-	if p.metadata.Muted {
+	// Let's check if we're muted. If we are, it's ok to not receive packets. Once the track gets
+	// unmuted, the publishers get another stall timeout to resume (see `resetStallTimers()`).
+	if p.muted {
 		pub.logger.Info("No RTPs (muted)")
 		pub.telemetry.AddEvent("No RTPs (muted)")
 		return
 	}
 
-	// Otherwise, remove all subscriptions and switch them to the lowest layer if available.
+	// The same applies to the layers that we asked the owner to pause.
+	if p.video.isPaused(pub.layer) {
+		pub.logger.Info("No RTPs (paused)")
+		pub.telemetry.AddEvent("No RTPs (paused)")
+		return
+	}
+
+	p.switchFromStalledPublisher(pub)
+	p.updateRequestedLayers()
+}
+
+// Called when the track gets unmuted. The publishers that went stalled while the track was muted
+// won't inform us about it again, yet they need some time to resume after the unmute. So instead
+// of treating them as stalled right away, we restart their stall timers: the publishers that don't
+// resume within the timeout are reported as stalled as usual. Must be called with the mutex locked.
+func (p *PublishedTrack[SubscriberID]) resetStallTimers() {
+	if p.audio != nil {
+		p.audio.resetStallTimer()
+	}
+
+	for _, pub := range p.video.publishers {
+		pub.resetStallTimer()
+	}
+}
+
+// Moves the subscriptions of a stalled publisher to another layer if possible.
+// Must be called with the mutex locked.
+func (p *PublishedTrack[SubscriberID]) switchFromStalledPublisher(pub *trackPublisher) {
+	// Remove all subscriptions and switch them to the lowest layer if available.
 	// We assume that the lowest layer is the latest to fail (normally, lowest layer always
 	// receive packets even if other layers are stalled).
 
@@ -156,12 +285,14 @@ func (p *PublishedTrack[SubscriberID]) handleStalledPublisher(pub *trackPublishe
 	}
 
 	// If low layer is available, switch to it.
-	if lowLayer := p.video.publishers[webrtc_ext.SimulcastLayerLow]; lowLayer != nil && lowLayer != pub {
+	lowestLayer := p.video.lowestLayer()
+	lowLayer := p.video.publishers[lowestLayer]
+	if lowLayer != nil && lowLayer != pub && !lowLayer.isStalled() {
 		pub.logger.Info("Publisher is stalled, switching to the lowest layer")
 		pub.telemetry.AddEvent("stalled, so subscriptions switched to the low layer")
 		for _, sub := range subscriptions {
 			lowLayer.addSubscription(sub)
-			sub.currentLayer = webrtc_ext.SimulcastLayerLow
+			p.switchSubscriptionLayer(sub, lowestLayer, LayerChangePublisherStalled)
 		}
 		return
 	}
@@ -170,7 +301,7 @@ func (p *PublishedTrack[SubscriberID]) handleStalledPublisher(pub *trackPublishe
 	pub.logger.Warn("Publisher is stalled and we have no other layer to switch to")
 	pub.telemetry.Fail(fmt.Errorf("stalled"))
 	for _, sub := range subscriptions {
-		sub.currentLayer = webrtc_ext.SimulcastLayerNone
+		p.switchSubscriptionLayer(sub, webrtc_ext.SimulcastLayerNone, LayerChangePublisherStalled)
 	}
 }
 
@@ -181,6 +312,8 @@ func (p *PublishedTrack[SubscriberID]) handleStalledPublisher(pub *trackPublishe
 // track will be observed by the participant either as a grey frame (if it's a
 // start of a call) or as a freeze (if it's in the middle of a call). We call
 // this function to switch stalled subscriptions to use the given publisher.
+// The subscriptions that desire the layer of the publisher (e.g. because it
+// has just been resumed) are switched to it as well.
 func (p *PublishedTrack[SubscriberID]) recoverOrphanedSubscriptions(
 	trackPublisher *trackPublisher,
 ) error {
@@ -192,11 +325,20 @@ func (p *PublishedTrack[SubscriberID]) recoverOrphanedSubscriptions(
 	defer p.mutex.Unlock()
 
 	for _, subscription := range p.subscriptions {
-		if subscription.currentLayer == webrtc_ext.SimulcastLayerNone {
-			subscription.currentLayer = trackPublisher.layer
-			trackPublisher.addSubscription(subscription)
+		orphaned := subscription.currentLayer == webrtc_ext.SimulcastLayerNone
+		desired := subscription.desiredLayer == trackPublisher.layer && subscription.currentLayer != trackPublisher.layer
+		if !orphaned && !desired {
+			continue
 		}
+
+		if current := p.video.publishers[subscription.currentLayer]; current != nil && current != trackPublisher {
+			current.removeSubscription(subscription)
+		}
+
+		p.switchSubscriptionLayer(subscription, trackPublisher.layer, LayerChangePublisherRecovered)
+		trackPublisher.addSubscription(subscription)
 	}
 
+	p.updateRequestedLayers()
 	return nil
 }
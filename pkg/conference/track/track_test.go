@@ -1,6 +1,7 @@
 package track //nolint:testpackage
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
@@ -41,6 +42,14 @@ func TestGetOptimalLayer(t *testing.T) {
 		{layers(high), 1280, 720, 200, 200, high},
 	}
 
+	// The well-known layout with the quarter, half and full resolution.
+	layout := webrtc_ext.SimulcastLayout{RIDs: []webrtc_ext.SimulcastRID{
+		{ID: "q", ScaleDownBy: 4},
+		{ID: "h", ScaleDownBy: 2},
+		{ID: "f", ScaleDownBy: 1},
+	}, Ordered: true}
+	ordered := orderLayers(layout, nil)
+
 	for _, c := range cases {
 		metadata := TrackMetadata{
 			MaxWidth:  c.fullWidth,
@@ -52,7 +61,8 @@ func TestGetOptimalLayer(t *testing.T) {
 			layers[layer] = struct{}{}
 		}
 
-		optimalLayer := getOptimalLayer(layers, metadata, c.desiredWidth, c.desiredHeight)
+		resolutions := estimateResolutions(ordered, layout, nil, metadata)
+		optimalLayer := getOptimalLayer(layers, ordered, resolutions, c.desiredWidth, c.desiredHeight)
 		if optimalLayer != c.expectedOptimalLayer {
 			t.Errorf("Expected optimal layer %s, got %s", c.expectedOptimalLayer, optimalLayer)
 		}
@@ -61,9 +71,69 @@ func TestGetOptimalLayer(t *testing.T) {
 
 func TestGetOptimalLayerNone(t *testing.T) {
 	layers := make(map[webrtc_ext.SimulcastLayer]struct{})
-	metadata := TrackMetadata{}
 
-	if getOptimalLayer(layers, metadata, 100, 100) != webrtc_ext.SimulcastLayerNone {
+	if getOptimalLayer(layers, nil, nil, 100, 100) != webrtc_ext.SimulcastLayerNone {
 		t.Fatal("Expected no simulcast layer for audio")
 	}
 }
+
+func TestGetOptimalLayerObserved(t *testing.T) {
+	// Arbitrary RIDs in no particular order, nothing is known about them from the SDP.
+	layout := webrtc_ext.SimulcastLayout{RIDs: []webrtc_ext.SimulcastRID{{ID: "1"}, {ID: "2"}, {ID: "3"}}}
+	first, second, third := webrtc_ext.SimulcastLayer(1), webrtc_ext.SimulcastLayer(2), webrtc_ext.SimulcastLayer(3)
+	all := map[webrtc_ext.SimulcastLayer]struct{}{first: {}, second: {}, third: {}}
+	metadata := TrackMetadata{MaxWidth: 1280, MaxHeight: 720}
+
+	// Without any observations, we rely on the order of the SDP.
+	ordered := orderLayers(layout, nil)
+	if !reflect.DeepEqual(ordered, []webrtc_ext.SimulcastLayer{first, second, third}) {
+		t.Fatalf("Unexpected order without observations: %v", ordered)
+	}
+
+	// The observed bitrates tell which layer is which.
+	stats := map[webrtc_ext.SimulcastLayer]layerStats{
+		first:  {bitrate: 1_500_000},
+		second: {bitrate: 150_000},
+		third:  {bitrate: 500_000},
+	}
+
+	ordered = orderLayers(layout, stats)
+	if !reflect.DeepEqual(ordered, []webrtc_ext.SimulcastLayer{second, third, first}) {
+		t.Fatalf("Unexpected order by bitrate: %v", ordered)
+	}
+
+	resolutions := estimateResolutions(ordered, layout, stats, metadata)
+	if layer := getOptimalLayer(all, ordered, resolutions, 640, 360); layer != third {
+		t.Fatalf("Expected the middle layer by bitrate, got %s", layer)
+	}
+
+	// The observed resolutions take precedence over everything else.
+	stats = map[webrtc_ext.SimulcastLayer]layerStats{
+		first:  {resolution: resolution{320, 180}, bitrate: 1_500_000},
+		second: {resolution: resolution{1280, 720}, bitrate: 150_000},
+		third:  {resolution: resolution{640, 360}, bitrate: 500_000},
+	}
+
+	ordered = orderLayers(layout, stats)
+	resolutions = estimateResolutions(ordered, layout, stats, metadata)
+
+	cases := []struct {
+		desiredWidth, desiredHeight int
+		expectedOptimalLayer        webrtc_ext.SimulcastLayer
+	}{
+		{1920, 1080, second},
+		{1280, 720, second},
+		{800, 600, third},
+		{320, 180, first},
+		{100, 100, first},
+		{0, 0, first},
+	}
+
+	for _, c := range cases {
+		layer := getOptimalLayer(all, ordered, resolutions, c.desiredWidth, c.desiredHeight)
+		if layer != c.expectedOptimalLayer {
+			t.Errorf("Expected optimal layer %s for %dx%d, got %s",
+				c.expectedOptimalLayer, c.desiredWidth, c.desiredHeight, layer)
+		}
+	}
+}
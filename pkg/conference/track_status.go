@@ -0,0 +1,66 @@
+package conference
+
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/conference/publisher"
+	"go.opentelemetry.io/otel/attribute"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Sent over the data channel to the participants when a track that they may be subscribed to
+// stops receiving packets from its publisher while it's not muted, or when it recovers afterwards.
+var FocusCallTrackStatus = event.Type{Type: "m.call.track_status", Class: event.FocusEventType}
+
+type FocusCallTrackStatusEventContent struct {
+	// The owner of the track.
+	UserID   id.UserID   `json:"user_id"`
+	DeviceID id.DeviceID `json:"device_id"`
+	// The track whose status changed.
+	StreamID string `json:"stream_id"`
+	TrackID  string `json:"track_id"`
+	// Either "stalled" or "recovered".
+	Status string `json:"status"`
+}
+
+func (c *Conference) processPublishedTrackStatusMessage(msg participant.TrackStatusMessage) {
+	var status string
+	switch msg.Status {
+	case publisher.StatusStalled:
+		status = "stalled"
+	case publisher.StatusRecovered:
+		status = "recovered"
+	default:
+		return
+	}
+
+	c.newLogger(msg.OwnerID).WithField("track", msg.Info.TrackID).Infof("Published track is %s", status)
+	c.telemetry.AddEvent(
+		"published track status changed",
+		attribute.String("track_id", msg.Info.TrackID),
+		attribute.String("status", status),
+	)
+
+	statusEvent := event.Event{
+		Type: FocusCallTrackStatus,
+		Content: event.Content{
+			Parsed: FocusCallTrackStatusEventContent{
+				UserID:   msg.OwnerID.UserID,
+				DeviceID: msg.OwnerID.DeviceID,
+				StreamID: msg.Info.StreamID,
+				TrackID:  msg.Info.TrackID,
+				Status:   status,
+			},
+		},
+	}
+
+	c.tracker.ForEachParticipant(func(id participant.ID, participant *participant.Participant) {
+		if id == msg.OwnerID {
+			return
+		}
+
+		if err := participant.SendOverDataChannel(statusEvent); err != nil {
+			c.logger.WithError(err).Errorf("Failed to send track status to %s", id)
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package conference
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	published "github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// How often we check the packet loss of the subscribers and adapt their subscriptions.
+const subscriptionAdaptationInterval = 2 * time.Second
+
+// The content of `m.call.track_subscription` with our extensions of the track description. The
+// extensions are optional, so the clients that don't know about them keep working as before.
+type TrackSubscriptionEventContent struct {
+	Subscribe   []TrackSubscriptionDescription `json:"subscribe"`
+	Unsubscribe []event.FocusTrackDescription  `json:"unsubscribe"`
+	// Replaces the subscription rules of the participant if set, an empty list removes all rules.
+	Rules *[]SubscriptionRuleDescription `json:"rules,omitempty"`
+}
+
+// Subscribes the participant to all tracks that match the rule, including the tracks published later,
+// e.g. all audio tracks, all tracks of a given user or all screen sharing streams. The fields that are
+// not set match any track. The first rule that matches a track defines the requirements.
+type SubscriptionRuleDescription struct {
+	UserID   id.UserID                          `json:"user_id,omitempty"`
+	DeviceID id.DeviceID                        `json:"device_id,omitempty"`
+	Kind     string                             `json:"kind,omitempty"`
+	Purpose  event.CallSDPStreamMetadataPurpose `json:"purpose,omitempty"`
+
+	Width        int     `json:"width,omitempty"`
+	Height       int     `json:"height,omitempty"`
+	MaxFrameRate float64 `json:"max_frame_rate,omitempty"`
+	Priority     int     `json:"priority,omitempty"`
+}
+
+func (d SubscriptionRuleDescription) rule() (participant.SubscriptionRule, error) {
+	var kind webrtc.RTPCodecType
+	if d.Kind != "" {
+		if kind = webrtc.NewRTPCodecType(d.Kind); kind == 0 {
+			return participant.SubscriptionRule{}, fmt.Errorf("unknown track kind: %s", d.Kind)
+		}
+	}
+
+	return participant.SubscriptionRule{
+		UserID:   d.UserID,
+		DeviceID: d.DeviceID,
+		Kind:     kind,
+		Purpose:  d.Purpose,
+		Requirements: published.SubscriptionRequirements{
+			Width:        d.Width,
+			Height:       d.Height,
+			MaxFrameRate: d.MaxFrameRate,
+			Priority:     d.Priority,
+		},
+	}, nil
+}
+
+type TrackSubscriptionDescription struct {
+	event.FocusTrackDescription
+	// The maximum frame rate that the subscriber needs, e.g. for a thumbnail. Zero if not limited.
+	MaxFrameRate float64 `json:"max_frame_rate,omitempty"`
+	// Priority of the subscription relative to the other subscriptions of the subscriber, e.g. the
+	// pinned screen share has a higher priority than the thumbnails. The higher, the more important.
+	// The subscriptions with the lowest priority are the first to get degraded when the bandwidth is short.
+	Priority int `json:"priority,omitempty"`
+}
+
+func (d TrackSubscriptionDescription) requirements() published.SubscriptionRequirements {
+	return published.SubscriptionRequirements{
+		Width:        d.Width,
+		Height:       d.Height,
+		MaxFrameRate: d.MaxFrameRate,
+		Priority:     d.Priority,
+	}
+}
+
+// Sent over the data channel in response to each track of the subscription request and whenever the
+// SFU switches a subscription to a different layer on its own (e.g. when the publisher stalls).
+var FocusCallTrackSubscriptionResult = event.Type{
+	Type:  "m.call.track_subscription_result",
+	Class: event.FocusEventType,
+}
+
+type FocusCallTrackSubscriptionResultEventContent struct {
+	StreamID string `json:"stream_id"`
+	TrackID  string `json:"track_id"`
+	// One of the `SubscriptionResult*` values.
+	Result string `json:"result"`
+	// Why the request failed or the layer changed, one of the `SubscriptionReason*` values.
+	Reason string `json:"reason,omitempty"`
+	// The layer that the subscriber gets now, the RID is empty for non-simulcast tracks.
+	RID    string `json:"rid,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+const (
+	SubscriptionResultSubscribed   = "subscribed"
+	SubscriptionResultUnsubscribed = "unsubscribed"
+	SubscriptionResultFailed       = "failed"
+	SubscriptionResultLayerChanged = "layer_changed"
+)
+
+const (
+	SubscriptionReasonTrackNotFound       = "track_not_found"
+	SubscriptionReasonTrackClosed         = "track_closed"
+	SubscriptionReasonInternalError       = "internal_error"
+	SubscriptionReasonLimitExceeded       = "limit_exceeded"
+	SubscriptionReasonServerBusy          = "server_busy"
+	SubscriptionReasonRequested           = "requested"
+	SubscriptionReasonPublisherStalled    = "publisher_stalled"
+	SubscriptionReasonPublisherRecovered  = "publisher_recovered"
+	SubscriptionReasonPublisherStopped    = "publisher_stopped"
+	SubscriptionReasonCongestion          = "congestion"
+	SubscriptionReasonCongestionRecovered = "congestion_recovered"
+	SubscriptionReasonServerLoad          = "server_load"
+)
+
+func (c *Conference) processSubscriptionLayerMessage(msg participant.SubscriptionLayerMessage) {
+	p := c.getParticipant(msg.SubscriberID)
+	if p == nil {
+		return
+	}
+
+	var reason string
+	switch msg.Reason {
+	case published.LayerChangeRequested:
+		reason = SubscriptionReasonRequested
+	case published.LayerChangePublisherStalled:
+		reason = SubscriptionReasonPublisherStalled
+	case published.LayerChangePublisherRecovered:
+		reason = SubscriptionReasonPublisherRecovered
+	case published.LayerChangePublisherStopped:
+		reason = SubscriptionReasonPublisherStopped
+	case published.LayerChangeCongestion:
+		reason = SubscriptionReasonCongestion
+	case published.LayerChangeCongestionRecovered:
+		reason = SubscriptionReasonCongestionRecovered
+	case published.LayerChangeServerLoad:
+		reason = SubscriptionReasonServerLoad
+	}
+
+	p.Logger.WithField("track", msg.Info.TrackID).WithField("rid", msg.Layer.RID).Debugf("Layer changed: %s", reason)
+	c.sendSubscriptionResult(p, msg.Info, SubscriptionResultLayerChanged, reason, msg.Layer)
+}
+
+// Informs the subscriber that the subscription to a given track failed.
+func (c *Conference) sendSubscriptionFailure(p *participant.Participant, info webrtc_ext.TrackInfo, err error) {
+	reason := SubscriptionReasonInternalError
+	switch {
+	case errors.Is(err, participant.ErrTrackNotFound):
+		reason = SubscriptionReasonTrackNotFound
+	case errors.Is(err, published.ErrTrackClosed):
+		reason = SubscriptionReasonTrackClosed
+	case errors.Is(err, participant.ErrTooManySubscriptions):
+		reason = SubscriptionReasonLimitExceeded
+	case errors.Is(err, bandwidth.ErrBudgetExceeded):
+		reason = SubscriptionReasonServerBusy
+	}
+
+	c.sendSubscriptionResult(p, info, SubscriptionResultFailed, reason, published.SubscriptionLayer{})
+}
+
+func (c *Conference) sendSubscriptionResult(
+	p *participant.Participant,
+	info webrtc_ext.TrackInfo,
+	result string,
+	reason string,
+	layer published.SubscriptionLayer,
+) {
+	// A subscription without an active publisher gets no packets until one of the publishers recovers.
+	if result != SubscriptionResultFailed && result != SubscriptionResultUnsubscribed && !layer.Active {
+		reason = SubscriptionReasonPublisherStalled
+	}
+
+	resultEvent := event.Event{
+		Type: FocusCallTrackSubscriptionResult,
+		Content: event.Content{
+			Parsed: FocusCallTrackSubscriptionResultEventContent{
+				StreamID: info.StreamID,
+				TrackID:  info.TrackID,
+				Result:   result,
+				Reason:   reason,
+				RID:      layer.RID,
+				Width:    layer.Width,
+				Height:   layer.Height,
+			},
+		},
+	}
+
+	if err := p.SendOverDataChannel(resultEvent); err != nil {
+		p.Logger.WithError(err).Debug("Failed to send track subscription result")
+	}
+
+	c.notifySubscription(p.ID, info, result, reason, layer)
+}
+
+func (c *Conference) processSubscriptionRules(p *participant.Participant, descriptions []SubscriptionRuleDescription) {
+	rules := make([]participant.SubscriptionRule, 0, len(descriptions))
+	for _, description := range descriptions {
+		rule, err := description.rule()
+		if err != nil {
+			p.Logger.WithError(err).Warn("Ignoring invalid subscription rule")
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	p.Logger.Infof("Setting %d subscription rules", len(rules))
+	subscriptions, err := c.tracker.SetSubscriptionRules(p.ID, rules)
+	if err != nil {
+		p.Logger.WithError(err).Error("Failed to set subscription rules")
+		return
+	}
+
+	c.applySubscriptionRules(subscriptions)
+}
+
+// Subscribes the participants to the tracks that their rules match. The rules are subject to the same
+// limits as the explicit requests and the subscribers get the results of the subscriptions.
+func (c *Conference) applySubscriptionRules(subscriptions []participant.RuleSubscription) {
+	for _, subscription := range subscriptions {
+		p := c.getParticipant(subscription.SubscriberID)
+		if p == nil {
+			continue
+		}
+
+		trackID := subscription.Info.TrackID
+		if err := c.tracker.Subscribe(p.ID, trackID, subscription.Requirements); err != nil {
+			// The rules are matched again on every change of the metadata, so the failure is remembered.
+			c.tracker.RecordRuleSubscriptionFailure(p.ID, trackID, err)
+			p.Logger.WithError(err).Warnf("Failed to subscribe to track %s by rule", trackID)
+			c.sendSubscriptionFailure(p, subscription.Info, err)
+			continue
+		}
+
+		p.Logger.Infof("Subscribed to track %s by rule", trackID)
+		layer, _ := c.tracker.GetSubscriptionLayer(p.ID, trackID)
+		c.sendSubscriptionResult(p, subscription.Info, SubscriptionResultSubscribed, "", layer)
+	}
+}
@@ -0,0 +1,74 @@
+package conference
+
+import (
+	"github.com/matrix-org/waterfall/pkg/audit"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	published "github.com/matrix-org/waterfall/pkg/conference/track"
+	"github.com/matrix-org/waterfall/pkg/webhook"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// The participant joined the conference again from the same device with a new session.
+	CallHangupSessionReplaced event.CallHangupReason = "session_replaced"
+	// The participant selected an answer of another party.
+	CallHangupAnsweredElsewhere event.CallHangupReason = "answered_elsewhere"
+)
+
+// Informs the webhook (if any) about a state transition of the conference and records it in the audit log.
+func (c *Conference) notify(ev webhook.Event) {
+	ev.ConferenceID = c.id
+	ev.RoomID = c.powerLevels.roomID.String()
+	c.webhooks.Notify(ev)
+	c.audit.Record(audit.KindState, string(ev.Type), ev.UserID, ev.DeviceID, ev)
+}
+
+// Informs the webhook about an event related to a given participant.
+func (c *Conference) notifyParticipant(eventType webhook.EventType, id participant.ID, reason string) {
+	c.notify(webhook.Event{
+		Type:     eventType,
+		UserID:   id.UserID.String(),
+		DeviceID: id.DeviceID.String(),
+		Reason:   reason,
+	})
+}
+
+// Informs the webhook about an event related to a given published track.
+func (c *Conference) notifyTrack(eventType webhook.EventType, owner participant.ID, info webrtc_ext.TrackInfo) {
+	c.notify(webhook.Event{
+		Type:  eventType,
+		Track: webhookTrack(owner, info),
+	})
+}
+
+// Informs the webhook about the change of the subscription of a given subscriber.
+func (c *Conference) notifySubscription(
+	subscriber participant.ID,
+	info webrtc_ext.TrackInfo,
+	result string,
+	reason string,
+	layer published.SubscriptionLayer,
+) {
+	// The owner is not known if the track does not exist (anymore).
+	owner, _, _ := c.tracker.GetPublishedTrackInfo(info.TrackID)
+
+	c.notify(webhook.Event{
+		Type:         webhook.SubscriptionChanged,
+		UserID:       subscriber.UserID.String(),
+		DeviceID:     subscriber.DeviceID.String(),
+		Reason:       reason,
+		Track:        webhookTrack(owner, info),
+		Subscription: &webhook.Subscription{Result: result, RID: layer.RID},
+	})
+}
+
+func webhookTrack(owner participant.ID, info webrtc_ext.TrackInfo) *webhook.Track {
+	return &webhook.Track{
+		ID:            info.TrackID,
+		StreamID:      info.StreamID,
+		Kind:          info.Kind.String(),
+		OwnerUserID:   owner.UserID.String(),
+		OwnerDeviceID: owner.DeviceID.String(),
+	}
+}
@@ -5,9 +5,15 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/matrix-org/waterfall/pkg/admin"
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
 	"github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/matrix-org/waterfall/pkg/routing"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/telemetry"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -19,12 +25,24 @@ type Config struct {
 	Matrix signaling.Config `yaml:"matrix"`
 	// Conference (call) configuration.
 	Conference conference.Config `yaml:"conference"`
+	// Authorization of the participants.
+	Authorization routing.AuthorizationConfig `yaml:"authorization"`
+	// Admin API configuration.
+	Admin admin.Config `yaml:"admin"`
+	// Prometheus metrics configuration.
+	Metrics metrics.Config `yaml:"metrics"`
+	// Egress bandwidth budget of the SFU.
+	Egress bandwidth.Config `yaml:"egress"`
+	// Webhook that is notified about the lifecycle of the conferences.
+	Webhook webhook.Config `yaml:"webhook"`
 	// Starting from which level to log stuff.
 	LogLevel string `yaml:"log"`
 	// WebRTC configuration.
 	WebRTC webrtc_ext.Config `yaml:"webrtc"`
 	// Telemetry configuration.
 	Telemetry telemetry.Config `yaml:"telemetry"`
+	// How long to wait for the conferences to end on shutdown (in seconds).
+	ShutdownTimeout int `yaml:"shutdownTimeout"`
 }
 
 // Tries to load a config from the `CONFIG` environment variable.
@@ -112,5 +130,24 @@ func validateConfig(config Config) error {
 		return fmt.Errorf("heartbeat.interval must be between 5s and 30s")
 	}
 
+	if config.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdownTimeout must not be negative")
+	}
+
+	if config.Egress.Budget < 0 {
+		return fmt.Errorf("egress.budget must not be negative")
+	}
+	if config.Egress.SteeringThreshold < 0 || config.Egress.SteeringThreshold > 1 {
+		return fmt.Errorf("egress.steeringThreshold must be between 0 and 1")
+	}
+
+	if config.Webhook.URL != "" && config.Webhook.Secret == "" {
+		return fmt.Errorf("you must set webhook.secret to use the webhook")
+	}
+
+	if role, err := participant.ParseRole(config.Conference.Roles.Default); err != nil || role.CanModerate() {
+		return fmt.Errorf("conference.roles.default must be either publisher or viewer")
+	}
+
 	return nil
 }
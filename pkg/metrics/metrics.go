@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The label value that is used for the values that the metric does not expect. This way the
+// number of the time series of a metric stays bounded whatever the callers pass.
+const OtherLabelValue = "other"
+
+// A metric with a single label that may only have one of the predefined values.
+type vec[T prometheus.Metric] struct {
+	values map[string]T
+}
+
+// Returns the metric for a given label value. Unexpected values are mapped to `OtherLabelValue`.
+func (v *vec[T]) With(value string) T {
+	if metric, found := v.values[value]; found {
+		return metric
+	}
+
+	return v.values[OtherLabelValue]
+}
+
+type CounterVec = vec[prometheus.Counter]
+
+type GaugeVec = vec[prometheus.Gauge]
+
+// Creates a counter with a label that may have one of the given values and registers it in a given registry.
+func NewCounterVec(r prometheus.Registerer, name, help, label string, values ...string) *CounterVec {
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, []string{label})
+	r.MustRegister(metric)
+
+	return newVec(values, func(value string) prometheus.Counter { return metric.WithLabelValues(value) })
+}
+
+// Creates a gauge with a label that may have one of the given values and registers it in a given registry.
+func NewGaugeVec(r prometheus.Registerer, name, help, label string, values ...string) *GaugeVec {
+	metric := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, []string{label})
+	r.MustRegister(metric)
+
+	return newVec(values, func(value string) prometheus.Gauge { return metric.WithLabelValues(value) })
+}
+
+// The metrics of all values are created upfront, so that they are exposed before they change.
+func newVec[T prometheus.Metric](values []string, with func(string) T) *vec[T] {
+	metric := &vec[T]{values: make(map[string]T)}
+	for _, value := range append(values, OtherLabelValue) {
+		metric.values[value] = with(value)
+	}
+
+	return metric
+}
@@ -0,0 +1,35 @@
+package metrics //nolint:testpackage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestVecBoundsLabelValues(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := NewGaugeVec(registry, "test_gauge", "A gauge.", "kind", "audio")
+	counter := NewCounterVec(registry, "test_total", "A counter.", "kind", "audio", "video")
+
+	gauge.With("audio").Inc()
+	gauge.With("audio").Inc()
+	gauge.With("audio").Dec()
+	counter.With("video").Add(3)
+	counter.With("conference-1234").Inc()
+
+	expected := `# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge{kind="audio"} 1
+test_gauge{kind="other"} 0
+# HELP test_total A counter.
+# TYPE test_total counter
+test_total{kind="audio"} 0
+test_total{kind="other"} 1
+test_total{kind="video"} 3
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
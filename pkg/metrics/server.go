@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// Configuration of the metrics endpoint.
+type Config struct {
+	// The address to serve `/metrics` on, e.g. "127.0.0.1:9090". Disabled if not set.
+	Address string `yaml:"address"`
+}
+
+// Timeouts of the requests, so that the slow clients can't hold the connections forever.
+const (
+	readHeaderTimeout = 10 * time.Second
+	writeTimeout      = 30 * time.Second
+)
+
+// Starts serving the default registry on `/metrics` and the capacity of the SFU (if given)
+// on `/capacity` in the background. Fails if the address can't be bound.
+func StartServer(config Config, capacity http.Handler) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Default, promhttp.HandlerOpts{}))
+	if capacity != nil {
+		mux.Handle("/capacity", capacity)
+	}
+
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", config.Address, err)
+	}
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: readHeaderTimeout, WriteTimeout: writeTimeout}
+
+	go func() {
+		logrus.WithField("address", config.Address).Info("starting metrics endpoint")
+		if err := server.Serve(listener); err != nil {
+			logrus.WithError(err).Error("metrics endpoint stopped")
+		}
+	}()
+
+	return nil
+}
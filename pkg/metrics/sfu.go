@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// The registry of the metrics of the SFU.
+var Default = prometheus.NewRegistry()
+
+// The labels are bounded on purpose: no conference, user or track identifiers, so that the
+// number of the time series does not depend on the number of the calls.
+var (
+	Conferences = promauto.With(Default).NewGauge(prometheus.GaugeOpts{
+		Name: "waterfall_conferences", Help: "Number of running conferences.",
+	})
+	Participants = promauto.With(Default).NewGauge(prometheus.GaugeOpts{
+		Name: "waterfall_participants", Help: "Number of participants in all conferences.",
+	})
+	PublishedTracks = NewGaugeVec(Default,
+		"waterfall_published_tracks", "Number of published tracks.", "kind", "audio", "video")
+	Subscriptions = NewGaugeVec(Default,
+		"waterfall_subscriptions", "Number of subscriptions to the published tracks.", "kind", "audio", "video")
+
+	ForwardedPackets = NewCounterVec(Default,
+		"waterfall_forwarded_packets_total", "RTP packets forwarded to the subscribers.", "kind", "audio", "video")
+	ForwardedBytes = NewCounterVec(Default,
+		"waterfall_forwarded_bytes_total", "RTP bytes forwarded to the subscribers.", "kind", "audio", "video")
+
+	WorkerDrops = NewCounterVec(Default,
+		"waterfall_worker_drops_total", "Tasks dropped because the worker was too busy.",
+		"worker", "audio_subscription", "video_subscription", "matrix", "webhook", "audit", "rtx")
+	KeyFrameRequests = NewCounterVec(Default,
+		"waterfall_keyframe_requests_total", "Key frame requests sent to the publishers.", "type", "pli", "fir")
+	LayerSwitches = NewCounterVec(Default,
+		"waterfall_layer_switches_total", "Simulcast layer switches of the subscriptions.", "reason",
+		"requested", "publisher_stalled", "publisher_recovered", "publisher_stopped",
+		"congestion", "congestion_recovered", "server_load")
+	PublisherStalls = NewCounterVec(Default,
+		"waterfall_publisher_stalls_total", "Publishers that stopped sending packets.", "kind", "audio", "video")
+	AdmissionRejections = NewCounterVec(Default,
+		"waterfall_admission_rejections_total", "Requests rejected because a limit was reached.", "limit",
+		"conferences", "participants", "published_tracks", "subscriptions", "egress_bandwidth")
+	ToDeviceFailures = promauto.With(Default).NewCounter(prometheus.CounterOpts{
+		Name: "waterfall_to_device_failures_total", Help: "To-device messages that could not be sent.",
+	})
+	WebhookFailures = promauto.With(Default).NewCounter(prometheus.CounterOpts{
+		Name: "waterfall_webhook_failures_total", Help: "Webhook events that could not be delivered.",
+	})
+)
+
+// Returns the number of bytes forwarded to the subscribers so far (all kinds).
+func ForwardedBytesTotal() uint64 {
+	return counterValue(ForwardedBytes.With("audio")) + counterValue(ForwardedBytes.With("video"))
+}
+
+func counterValue(counter prometheus.Counter) uint64 {
+	var metric dto.Metric
+	if err := counter.Write(&metric); err != nil {
+		return 0
+	}
+
+	return uint64(metric.GetCounter().GetValue())
+}
@@ -1,6 +1,7 @@
 package peer
 
 import (
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/webrtc/v3"
 	"maunium.net/go/mautrix/event"
 )
@@ -18,6 +19,8 @@ type LeftTheCall struct {
 type NewTrackPublished struct {
 	// Remote track that has been published.
 	RemoteTrack *webrtc.TrackRemote
+	// Simulcast layers of the track as negotiated in the SDP, empty if the track has no simulcast.
+	Simulcast webrtc_ext.SimulcastLayout
 }
 
 type NewICECandidate struct {
@@ -3,8 +3,10 @@ package peer
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/matrix-org/waterfall/pkg/channel"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/peer/state"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/rtcp"
@@ -30,18 +32,26 @@ var (
 type Peer[ID comparable] struct {
 	logger         *logrus.Entry
 	peerConnection *webrtc.PeerConnection
+	rtx            *webrtc_ext.RTXInterceptor
 	sink           *channel.SinkWithSender[ID, MessageContent]
 	state          *state.PeerState
+	// False for the receive-only peers, whose media is rejected in the negotiation.
+	receiveMedia bool
 }
 
 // Instantiates a new peer with a given SDP offer and returns a peer and the SDP answer if everything is ok.
+// The peer that must not send any media (`receiveMedia` is false) gets the answers and offers without
+// the directions in which it would send it. `onNackDropped` is called for each NACK of the remote peer
+// that is dropped since too many of them are pending (optional).
 func NewPeer[ID comparable](
 	connectionFactory *webrtc_ext.PeerConnectionFactory,
 	sdpOffer string,
+	receiveMedia bool,
+	onNackDropped func(),
 	sink *channel.SinkWithSender[ID, MessageContent],
 	logger *logrus.Entry,
 ) (*Peer[ID], *webrtc.SessionDescription, error) {
-	peerConnection, err := connectionFactory.CreatePeerConnection()
+	peerConnection, rtx, err := connectionFactory.CreatePeerConnection(onNackDropped)
 	if err != nil {
 		logger.WithError(err).Error("failed to create peer connection")
 		return nil, nil, ErrCantCreatePeerConnection
@@ -50,8 +60,10 @@ func NewPeer[ID comparable](
 	peer := &Peer[ID]{
 		logger:         logger,
 		peerConnection: peerConnection,
+		rtx:            rtx,
 		sink:           sink,
 		state:          state.NewPeerState(),
+		receiveMedia:   receiveMedia,
 	}
 
 	peerConnection.OnTrack(peer.onRtpTrackReceived)
@@ -82,14 +94,49 @@ func (p *Peer[ID]) Terminate() {
 	p.sink.Seal()
 }
 
-// Request a key frame from the peer connection.
-func (p *Peer[ID]) RequestKeyFrame(track *webrtc.TrackRemote) error {
-	rtcps := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}}
-	return p.peerConnection.WriteRTCP(rtcps)
+// Request a key frame from the peer connection. The FIR sequence number is only used for FIRs.
+func (p *Peer[ID]) RequestKeyFrame(
+	track *webrtc.TrackRemote,
+	packetType webrtc_ext.RTCPPacketType,
+	firSequenceNumber uint8,
+) error {
+	ssrc := uint32(track.SSRC())
+
+	var packet rtcp.Packet
+	switch packetType {
+	case webrtc_ext.PictureLossIndicator:
+		packet = &rtcp.PictureLossIndication{MediaSSRC: ssrc}
+	case webrtc_ext.FullIntraRequest:
+		// The media source SSRC must be 0, the target is in the FCI entry (RFC 5104, section 4.3.1).
+		packet = &rtcp.FullIntraRequest{
+			MediaSSRC: 0,
+			FIR:       []rtcp.FIREntry{{SSRC: ssrc, SequenceNumber: firSequenceNumber}},
+		}
+	default:
+		return fmt.Errorf("unsupported key frame request type: %d", packetType)
+	}
+
+	if err := p.peerConnection.WriteRTCP([]rtcp.Packet{packet}); err != nil {
+		return err
+	}
+
+	metrics.KeyFrameRequests.With(packetType.String()).Inc()
+	return nil
+}
+
+// Returns the latest round-trip time measured on the selected ICE candidate pair, if any.
+func (p *Peer[ID]) RoundTripTime() (time.Duration, bool) {
+	for _, stats := range p.peerConnection.GetStats() {
+		if pair, ok := stats.(webrtc.ICECandidatePairStats); ok && pair.Nominated && pair.CurrentRoundTripTime > 0 {
+			return time.Duration(pair.CurrentRoundTripTime * float64(time.Second)), true
+		}
+	}
+
+	return 0, false
 }
 
 // Implementation of the `SubscriptionController` interface.
-func (p *Peer[ID]) AddTrack(track *webrtc.TrackLocalStaticRTP) (*webrtc.RTPSender, error) {
+func (p *Peer[ID]) AddTrack(track webrtc.TrackLocal) (*webrtc.RTPSender, error) {
 	return p.peerConnection.AddTrack(track)
 }
 
@@ -127,6 +174,9 @@ func (p *Peer[ID]) ProcessNewRemoteCandidates(candidates []webrtc.ICECandidateIn
 
 // Processes the SDP answer received from the remote peer.
 func (p *Peer[ID]) ProcessSDPAnswer(sdpAnswer string) error {
+	p.updateRTX(sdpAnswer)
+	p.updateSimulcastLayouts(sdpAnswer)
+
 	err := p.peerConnection.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeAnswer,
 		SDP:  sdpAnswer,
@@ -141,6 +191,9 @@ func (p *Peer[ID]) ProcessSDPAnswer(sdpAnswer string) error {
 
 // Applies the sdp offer received from the remote peer and generates an SDP answer.
 func (p *Peer[ID]) ProcessSDPOffer(sdpOffer string) (*webrtc.SessionDescription, error) {
+	p.updateRTX(sdpOffer)
+	p.updateSimulcastLayouts(sdpOffer)
+
 	err := p.peerConnection.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
 		SDP:  sdpOffer,
@@ -161,5 +214,37 @@ func (p *Peer[ID]) ProcessSDPOffer(sdpOffer string) (*webrtc.SessionDescription,
 		return nil, ErrCantSetLocalDescription
 	}
 
+	answer.SDP = p.localDescription(answer.SDP)
 	return &answer, nil
 }
+
+// Prepares the local description for sending it to the remote peer.
+func (p *Peer[ID]) localDescription(description string) string {
+	description = p.rtx.SignalRepairStreams(description)
+	if !p.receiveMedia {
+		description = webrtc_ext.WithoutIncomingMedia(description)
+	}
+
+	return description
+}
+
+// Remembers the simulcast layers of the tracks that the remote peer sends, so that we know how to
+// order the layers once the tracks arrive.
+func (p *Peer[ID]) updateSimulcastLayouts(sdp string) {
+	layouts, err := webrtc_ext.ParseSimulcastLayouts(sdp)
+	if err != nil {
+		p.logger.WithError(err).Warn("failed to parse remote description for simulcast layers")
+		return
+	}
+
+	p.state.SetSimulcastLayouts(layouts)
+}
+
+// Informs the RTX interceptor about the RTX payload types and SSRCs of the remote peer.
+// Must be called before the remote description is applied, so that the interceptor knows
+// about the repair streams by the time Pion starts reading them.
+func (p *Peer[ID]) updateRTX(sdp string) {
+	if err := p.rtx.UpdateRemoteDescription(sdp); err != nil {
+		p.logger.WithError(err).Warn("failed to parse remote description for RTX")
+	}
+}
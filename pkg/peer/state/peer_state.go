@@ -3,12 +3,15 @@ package state
 import (
 	"sync"
 
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/webrtc/v3"
 )
 
 type PeerState struct {
 	mutex       sync.Mutex
 	dataChannel *webrtc.DataChannel
+	// Simulcast layouts of the tracks that the remote peer sends, indexed by the track ID.
+	simulcastLayouts map[string]webrtc_ext.SimulcastLayout
 }
 
 func NewPeerState() *PeerState {
@@ -28,3 +31,21 @@ func (p *PeerState) GetDataChannel() *webrtc.DataChannel {
 
 	return p.dataChannel
 }
+
+func (p *PeerState) SetSimulcastLayouts(layouts map[string]webrtc_ext.SimulcastLayout) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.simulcastLayouts = layouts
+}
+
+// Returns the simulcast layout of a given track, the layout is empty if the track has no simulcast.
+func (p *PeerState) GetSimulcastLayout(trackID string) webrtc_ext.SimulcastLayout {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	layout := p.simulcastLayouts[trackID]
+	// The layout is modified by its user, so we don't want to share the slice.
+	layout.RIDs = append([]webrtc_ext.SimulcastRID(nil), layout.RIDs...)
+	return layout
+}
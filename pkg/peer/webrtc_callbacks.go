@@ -9,7 +9,7 @@ import (
 // we call this function each time a new track is received.
 func (p *Peer[ID]) onRtpTrackReceived(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 	p.logger.WithField("track", remoteTrack).Debug("RTP track received")
-	p.sink.Send(NewTrackPublished{remoteTrack})
+	p.sink.Send(NewTrackPublished{remoteTrack, p.state.GetSimulcastLayout(remoteTrack.ID())})
 }
 
 // A callback that is called once we receive an ICE candidate for this peer connection.
@@ -38,6 +38,7 @@ func (p *Peer[ID]) onNegotiationNeeded() {
 		return
 	}
 
+	offer.SDP = p.localDescription(offer.SDP)
 	p.sink.Send(RenegotiationRequired{Offer: &offer})
 }
 
@@ -0,0 +1,62 @@
+package routing
+
+import (
+	"errors"
+	"sort"
+
+	conf "github.com/matrix-org/waterfall/pkg/conference"
+)
+
+var ErrConferenceNotFound = errors.New("conference not found")
+
+// Returns the IDs of the running conferences. Safe to call from any go-routine.
+func (r *Router) ConferenceIDs() []string {
+	result := make(chan []string)
+	r.requests <- func(r *Router) {
+		ids := []string{}
+		for id, conference := range r.conferenceSinks {
+			if !conference.isDone() {
+				ids = append(ids, id)
+			}
+		}
+
+		sort.Strings(ids)
+		result <- ids
+	}
+
+	return <-result
+}
+
+// Executes the request within the main loop of a given conference and waits until it's done.
+// Safe to call from any go-routine.
+func (r *Router) WithConference(conferenceID string, request conf.AdminRequest) error {
+	result := make(chan *conferenceStage)
+	r.requests <- func(r *Router) {
+		result <- r.conferenceSinks[conferenceID]
+	}
+
+	conference := <-result
+	if conference == nil {
+		return ErrConferenceNotFound
+	}
+
+	// We don't block the main loop of the Router while waiting for the conference.
+	processed := make(chan struct{})
+	select {
+	case conference.admin <- func(c *conf.Conference) { request(c); close(processed) }:
+	case <-conference.done:
+		return ErrConferenceNotFound
+	}
+
+	<-processed
+	return nil
+}
+
+func (c *conferenceStage) isDone() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,121 @@
+package routing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Configuration of the authorization of the participants.
+type AuthorizationConfig struct {
+	// Only the users who joined the room of the call and have a call member event for the device
+	// are allowed to join the conference. The invites must contain the `room_id` then.
+	RequireCallMembership bool `yaml:"requireCallMembership"`
+	// How long the positive decisions are cached (in seconds, 300 if not set). The negative decisions
+	// are cached for a shorter time, so that the users who have just joined could retry soon.
+	CacheTTL int `yaml:"cacheTtl"`
+}
+
+var (
+	errMissingRoomID = errors.New("invite does not contain room_id")
+	errRoomMismatch  = errors.New("conference belongs to another room")
+)
+
+// The hangup reason that the participants get if they are not allowed to join the conference.
+const CallHangupUnauthorized event.CallHangupReason = "unauthorized"
+
+const (
+	defaultAuthorizationTTL  = 5 * time.Minute
+	negativeAuthorizationTTL = 10 * time.Second
+)
+
+type authorizationKey struct {
+	roomID       id.RoomID
+	conferenceID string
+	userID       id.UserID
+	deviceID     id.DeviceID
+	sessionID    id.SessionID
+}
+
+type authorizationDecision struct {
+	err     error
+	expires time.Time
+}
+
+// Caches the authorization decisions, the decisions are invalidated when the state of the room
+// changes in a way that may affect them (memberships or calls).
+type authorizationCache struct {
+	ttl       time.Duration
+	decisions map[authorizationKey]authorizationDecision
+	// When the expired decisions are removed next time.
+	nextSweep time.Time
+}
+
+func newAuthorizationCache(config AuthorizationConfig) *authorizationCache {
+	ttl := defaultAuthorizationTTL
+	if config.CacheTTL > 0 {
+		ttl = time.Duration(config.CacheTTL) * time.Second
+	}
+
+	return &authorizationCache{ttl: ttl, decisions: make(map[authorizationKey]authorizationDecision)}
+}
+
+// Returns the cached decision if it has not expired yet.
+func (c *authorizationCache) lookup(key authorizationKey) (authorizationDecision, bool) {
+	decision, found := c.decisions[key]
+	if found && !time.Now().Before(decision.expires) {
+		delete(c.decisions, key)
+		return authorizationDecision{}, false
+	}
+
+	return decision, found
+}
+
+// Caches a new decision. The expired decisions are removed from time to time, so that the decisions
+// about the users who never come back don't pile up.
+func (c *authorizationCache) store(key authorizationKey, err error) {
+	now := time.Now()
+	if now.After(c.nextSweep) {
+		for key, decision := range c.decisions {
+			if !now.Before(decision.expires) {
+				delete(c.decisions, key)
+			}
+		}
+
+		c.nextSweep = now.Add(c.ttl)
+	}
+
+	ttl := c.ttl
+	if err != nil {
+		ttl = negativeAuthorizationTTL
+	}
+
+	c.decisions[key] = authorizationDecision{err: err, expires: now.Add(ttl)}
+}
+
+// Drops the decisions that a given state event may affect.
+func (c *authorizationCache) invalidate(evt *event.Event) {
+	if evt.StateKey == nil {
+		return
+	}
+
+	for key := range c.decisions {
+		if key.roomID != evt.RoomID {
+			continue
+		}
+
+		switch evt.Type.Type {
+		case event.StateMember.Type, signaling.StateCallMember.Type:
+			if key.userID == id.UserID(*evt.StateKey) {
+				delete(c.decisions, key)
+			}
+		case signaling.StateCall.Type:
+			if key.conferenceID == *evt.StateKey {
+				delete(c.decisions, key)
+			}
+		}
+	}
+}
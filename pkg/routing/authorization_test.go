@@ -0,0 +1,80 @@
+package routing //nolint:testpackage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestAuthorizationCache(t *testing.T) {
+	cache := newAuthorizationCache(AuthorizationConfig{})
+	key := authorizationKey{roomID: "!room", conferenceID: "conf", userID: "@alice:server", deviceID: "DEVICE"}
+
+	expectDecision := func(err error) {
+		t.Helper()
+
+		decision, found := cache.lookup(key)
+		if !found || !errors.Is(decision.err, err) {
+			t.Fatalf("unexpected decision: %v (found: %v)", decision.err, found)
+		}
+	}
+
+	expectNoDecision := func() {
+		t.Helper()
+
+		if decision, found := cache.lookup(key); found {
+			t.Fatalf("unexpected decision: %v", decision.err)
+		}
+	}
+
+	// The decision is cached.
+	expectNoDecision()
+	cache.store(key, nil)
+	expectDecision(nil)
+
+	// The change of the membership of another user does not affect the decision.
+	stateKey := "@bob:server"
+	cache.invalidate(&event.Event{RoomID: "!room", Type: event.StateMember, StateKey: &stateKey})
+	expectDecision(nil)
+
+	// The change of the call membership of the user invalidates the decision.
+	stateKey = "@alice:server"
+	cache.invalidate(&event.Event{RoomID: "!room", Type: signaling.StateCallMember, StateKey: &stateKey})
+	expectNoDecision()
+
+	cache.store(key, signaling.ErrNotCallMember)
+	expectDecision(signaling.ErrNotCallMember)
+
+	// The call is replaced.
+	stateKey = "conf"
+	cache.invalidate(&event.Event{RoomID: "!room", Type: signaling.StateCall, StateKey: &stateKey})
+	expectNoDecision()
+}
+
+func TestAuthorizationCacheExpiry(t *testing.T) {
+	cache := newAuthorizationCache(AuthorizationConfig{})
+	expired := authorizationKey{roomID: "!room", conferenceID: "conf", userID: "@alice:server", deviceID: "ALICE"}
+	stale := authorizationKey{roomID: "!room", conferenceID: "conf", userID: "@bob:server", deviceID: "BOB"}
+	fresh := authorizationKey{roomID: "!room", conferenceID: "conf", userID: "@carol:server", deviceID: "CAROL"}
+
+	cache.decisions[expired] = authorizationDecision{expires: time.Now().Add(-time.Second)}
+	cache.decisions[stale] = authorizationDecision{expires: time.Now().Add(-time.Second)}
+
+	// The expired decision is removed when it's looked up.
+	if _, found := cache.lookup(expired); found {
+		t.Fatal("the decision has expired")
+	}
+
+	if _, found := cache.decisions[expired]; found {
+		t.Fatal("the expired decision must be removed on lookup")
+	}
+
+	// The decisions that are never looked up again are removed when a new decision is stored.
+	cache.store(fresh, nil)
+	if _, found := cache.decisions[stale]; found || len(cache.decisions) != 1 {
+		t.Fatalf("the expired decisions must be removed: %+v", cache.decisions)
+	}
+}
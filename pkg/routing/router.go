@@ -17,9 +17,14 @@ limitations under the License.
 package routing
 
 import (
+	"fmt"
+
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
 	conf "github.com/matrix-org/waterfall/pkg/conference"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/metrics"
 	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webhook"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/event"
@@ -34,8 +39,23 @@ type Router struct {
 	conferenceSinks map[string]*conferenceStage
 	// Configuration for the calls.
 	config conf.Config
+	// Configuration of the authorization and the cached decisions.
+	authorization  AuthorizationConfig
+	authorizations *authorizationCache
+	// Events of the senders whose invites are being authorized, they are handled once the decision is made.
+	pendingEvents map[pendingSender][]*event.Event
 	// Channel for reading incoming Matrix SDK To-Device events and distributing them to the conferences.
 	matrixEvents <-chan *event.Event
+	// Requests of the admin API executed by the main loop of the Router.
+	requests chan func(*Router)
+	// Closed once the main loop of the Router ends, the requests are not executed then.
+	done chan struct{}
+	// Set once the SFU is shutting down, no new participants are accepted then.
+	draining bool
+	// Egress bandwidth budget shared by all conferences.
+	budget *bandwidth.Budget
+	// Webhook that is notified about the lifecycle of the conferences (nil if not configured).
+	webhooks *webhook.Notifier
 	// Channel for handling conference ended events.
 	// Peer connection factory that can be used to create pre-configured peer connections.
 	connectionFactory *webrtc_ext.PeerConnectionFactory
@@ -47,26 +67,55 @@ func StartRouter(
 	connectionFactory *webrtc_ext.PeerConnectionFactory,
 	matrixEvents <-chan *event.Event,
 	config conf.Config,
-) {
+	authorization AuthorizationConfig,
+	budget *bandwidth.Budget,
+	webhooks *webhook.Notifier,
+) *Router {
 	router := &Router{
 		matrix:            matrix,
 		conferenceSinks:   make(map[string]*conferenceStage),
 		config:            config,
+		authorization:     authorization,
+		authorizations:    newAuthorizationCache(authorization),
+		pendingEvents:     make(map[pendingSender][]*event.Event),
 		matrixEvents:      matrixEvents,
+		requests:          make(chan func(*Router)),
+		done:              make(chan struct{}),
+		budget:            budget,
+		webhooks:          webhooks,
 		connectionFactory: connectionFactory,
 	}
 
 	// Start the main loop of the Router.
 	go func() {
-		for msg := range router.matrixEvents {
-			// To-Device message received from the remote peer.
-			router.handleMatrixEvent(msg)
+		defer close(router.done)
+
+		for {
+			select {
+			case msg, ok := <-router.matrixEvents:
+				if !ok {
+					return
+				}
+
+				// To-Device message received from the remote peer.
+				router.handleMatrixEvent(msg)
+			case request := <-router.requests:
+				request(router)
+			}
 		}
 	}()
+
+	return router
 }
 
 // Handles incoming To-Device events that the SFU receives from clients.
 func (r *Router) handleMatrixEvent(evt *event.Event) {
+	// The only state events that we get are those that affect the authorization.
+	if evt.Type.Class == event.StateEventType {
+		r.authorizations.invalidate(evt)
+		return
+	}
+
 	var (
 		conferenceID string
 		callID       string
@@ -98,30 +147,83 @@ func (r *Router) handleMatrixEvent(evt *event.Event) {
 		"device_id": deviceID,
 	})
 
+	// Keep the order of the events of the sender whose invite is being authorized.
+	pending := pendingSender{conferenceID, userID, id.DeviceID(deviceID)}
+	if events, found := r.pendingEvents[pending]; found {
+		r.pendingEvents[pending] = append(events, evt)
+		return
+	}
+
+	// Only the members of the call may join it, whether the conference exists or not.
+	if evt.Type.Type == event.ToDeviceCallInvite.Type {
+		invite := evt.Content.AsCallInvite()
+		sender := participant.ID{UserID: userID, DeviceID: id.DeviceID(deviceID), CallID: callID}
+
+		if r.draining {
+			logger.Info("rejecting invite since the SFU is shutting down")
+			r.rejectInvite(conferenceID, sender, invite.SenderSessionID, conf.CallHangupShutdown)
+			return
+		}
+
+		decided, err := r.authorize(evt, conferenceID, sender, invite.SenderSessionID)
+		if !decided {
+			logger.Debug("checking the call membership of the sender")
+			return
+		}
+
+		if err != nil {
+			logger.WithError(err).Warn("rejecting unauthorized invite")
+			r.rejectInvite(conferenceID, sender, invite.SenderSessionID, CallHangupUnauthorized)
+			return
+		}
+	}
+
 	conference := r.conferenceSinks[conferenceID]
 
 	// Only ToDeviceCallInvite events are allowed to create a new conference, others
 	// are expected to operate on an existing conference that is running on the SFU.
 	if conference == nil && evt.Type.Type == event.ToDeviceCallInvite.Type {
+		if r.config.Limits.MaxConferences != 0 && r.runningConferences() >= r.config.Limits.MaxConferences {
+			logger.Warnf("rejecting invite since the limit of %d conferences is reached", r.config.Limits.MaxConferences)
+			metrics.AdmissionRejections.With("conferences").Inc()
+
+			invite := evt.Content.AsCallInvite()
+			sender := participant.ID{UserID: userID, DeviceID: id.DeviceID(deviceID), CallID: callID}
+			r.rejectInvite(conferenceID, sender, invite.SenderSessionID, conf.CallHangupServerFull)
+			return
+		}
+
 		logger.Infof("creating new conference %s", conferenceID)
 
+		// The room is only known if the membership of the sender was checked in it, otherwise the sender
+		// could name any room and get the roles from its power levels.
+		var roomID string
+		if r.authorization.RequireCallMembership {
+			roomID, _ = evt.Content.Raw["room_id"].(string)
+		}
+
 		matrixEvents := make(chan conf.MatrixMessage)
+		adminRequests := make(chan conf.AdminRequest)
 
 		conferenceDone, err := conf.StartConference(
 			conferenceID,
+			id.RoomID(roomID),
 			r.config,
 			r.connectionFactory,
 			r.matrix.CreateForConference(conferenceID),
 			matrixEvents,
+			adminRequests,
 			userID,
 			evt.Content.AsCallInvite(),
+			r.budget,
+			r.webhooks,
 		)
 		if err != nil {
 			logger.WithError(err).Errorf("failed to start conference %s", conferenceID)
 			return
 		}
 
-		r.conferenceSinks[conferenceID] = &conferenceStage{matrixEvents, conferenceDone}
+		r.conferenceSinks[conferenceID] = &conferenceStage{id.RoomID(roomID), matrixEvents, adminRequests, conferenceDone}
 		return
 	}
 
@@ -132,7 +234,7 @@ func (r *Router) handleMatrixEvent(evt *event.Event) {
 	}
 
 	// Sender of the To-Device message.
-	sender := participant.ID{userID, id.DeviceID(deviceID), callID}
+	sender := participant.ID{UserID: userID, DeviceID: id.DeviceID(deviceID), CallID: callID}
 
 	var content conf.MessageContent
 	switch evt.Type.Type {
@@ -169,7 +271,113 @@ func (r *Router) handleMatrixEvent(evt *event.Event) {
 	}
 }
 
+// Returns the number of conferences that have not ended yet.
+func (r *Router) runningConferences() int {
+	running := 0
+	for _, conference := range r.conferenceSinks {
+		if !conference.isDone() {
+			running++
+		}
+	}
+
+	return running
+}
+
+// Checks that the sender of the invite is allowed to join the conference. Returns false if the decision
+// is not known yet: the membership is then checked in the background, so that the slow homeserver doesn't
+// hold up the other conferences, and the invite is handled again once the decision is made.
+func (r *Router) authorize(
+	evt *event.Event,
+	conferenceID string,
+	sender participant.ID,
+	sessionID id.SessionID,
+) (bool, error) {
+	if !r.authorization.RequireCallMembership {
+		return true, nil
+	}
+
+	roomID, _ := evt.Content.Raw["room_id"].(string)
+	if roomID == "" {
+		return true, errMissingRoomID
+	}
+
+	// The membership is checked in the room from the invite, so the room must be the one of the conference,
+	// otherwise the members of any room could join the conference by naming their own room.
+	if conference := r.conferenceSinks[conferenceID]; conference != nil && !conference.isDone() {
+		if conference.roomID != id.RoomID(roomID) {
+			return true, fmt.Errorf("%w: %s", errRoomMismatch, roomID)
+		}
+	}
+
+	key := authorizationKey{id.RoomID(roomID), conferenceID, sender.UserID, sender.DeviceID, sessionID}
+	if decision, found := r.authorizations.lookup(key); found {
+		return true, decision.err
+	}
+
+	pending := pendingSender{conferenceID, sender.UserID, sender.DeviceID}
+	r.pendingEvents[pending] = []*event.Event{evt}
+
+	go func() {
+		err := r.matrix.CheckCallMembership(key.roomID, conferenceID, sender.UserID, sender.DeviceID, sessionID)
+		select {
+		case r.requests <- func(r *Router) {
+			r.authorizations.store(key, err)
+			r.handlePendingEvents(pending)
+		}:
+		case <-r.done:
+		}
+	}()
+
+	return false, nil
+}
+
+// Handles the events that arrived while the invite of the sender was being authorized.
+func (r *Router) handlePendingEvents(pending pendingSender) {
+	events := r.pendingEvents[pending]
+	delete(r.pendingEvents, pending)
+
+	for i, evt := range events {
+		// Another invite of the sender may need to be authorized first.
+		if queued, found := r.pendingEvents[pending]; found {
+			r.pendingEvents[pending] = append(queued, events[i:]...)
+			return
+		}
+
+		r.handleMatrixEvent(evt)
+	}
+}
+
+// Informs the sender of the invite that it's not allowed to join the conference.
+func (r *Router) rejectInvite(
+	conferenceID string,
+	sender participant.ID,
+	sessionID id.SessionID,
+	reason event.CallHangupReason,
+) {
+	recipient := signaling.MatrixRecipient{
+		UserID:          sender.UserID,
+		DeviceID:        sender.DeviceID,
+		CallID:          sender.CallID,
+		RemoteSessionID: sessionID,
+	}
+
+	message := signaling.MatrixMessage{Recipient: recipient, Message: signaling.Hangup{Reason: reason}}
+	if err := r.matrix.CreateForConference(conferenceID).SendMessage(message); err != nil {
+		logrus.WithError(err).Error("failed to reject the invite")
+	}
+}
+
+// The sender of the events whose invite is being authorized.
+type pendingSender struct {
+	conferenceID string
+	userID       id.UserID
+	deviceID     id.DeviceID
+}
+
 type conferenceStage struct {
-	sink chan<- conf.MatrixMessage
-	done <-chan struct{}
+	// The room of the call, empty if the membership is not checked.
+	roomID id.RoomID
+	sink   chan<- conf.MatrixMessage
+	admin  chan<- conf.AdminRequest
+	done   <-chan struct{}
 }
@@ -0,0 +1,47 @@
+package routing
+
+import (
+	"context"
+
+	conf "github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/sirupsen/logrus"
+)
+
+// Drains the SFU: stops accepting new invites, hangs up all participants of all conferences and
+// waits until the conferences end (i.e. until their hangups are sent) or until the context is done.
+// Safe to call from any go-routine.
+func (r *Router) Shutdown(ctx context.Context) error {
+	result := make(chan []*conferenceStage)
+	r.requests <- func(r *Router) {
+		r.draining = true
+
+		conferences := []*conferenceStage{}
+		for _, conference := range r.conferenceSinks {
+			conferences = append(conferences, conference)
+		}
+
+		result <- conferences
+	}
+
+	conferences := <-result
+	logrus.WithField("conferences", len(conferences)).Info("shutting down, ending all conferences")
+
+	for _, conference := range conferences {
+		go func(conference *conferenceStage) {
+			select {
+			case conference.admin <- func(c *conf.Conference) { c.End(conf.CallHangupShutdown) }:
+			case <-conference.done:
+			}
+		}(conference)
+	}
+
+	for _, conference := range conferences {
+		select {
+		case <-conference.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
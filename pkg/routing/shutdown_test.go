@@ -0,0 +1,91 @@
+package routing //nolint:testpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/bandwidth"
+	conf "github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/event"
+)
+
+// The to-device messages that the fake homeserver got, keyed by the recipient's device.
+type toDeviceMessages struct {
+	Messages map[string]map[string]struct {
+		CallID string                 `json:"call_id"`
+		Reason event.CallHangupReason `json:"reason"`
+	} `json:"messages"`
+}
+
+func TestShutdownRejectsNewInvites(t *testing.T) {
+	hangups := make(chan toDeviceMessages, 1)
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/account/whoami"):
+			_, _ = w.Write([]byte(`{"user_id": "@sfu:example.org", "device_id": "SFU"}`))
+		case strings.Contains(r.URL.Path, "/sendToDevice/"+event.ToDeviceCallHangup.Type+"/"):
+			var messages toDeviceMessages
+			if err := json.NewDecoder(r.Body).Decode(&messages); err != nil {
+				t.Errorf("failed to decode the hangup: %v", err)
+			}
+
+			hangups <- messages
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer homeserver.Close()
+
+	matrix := signaling.NewMatrixClient(signaling.Config{
+		UserID:        "@sfu:example.org",
+		HomeserverURL: homeserver.URL,
+		AccessToken:   "token",
+	})
+
+	events := make(chan *event.Event)
+	defer close(events)
+
+	budget := bandwidth.NewBudget(bandwidth.Config{}, func() uint64 { return 0 })
+	router := StartRouter(matrix, nil, events, conf.Config{}, AuthorizationConfig{}, budget, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := router.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+
+	// The invite that arrives after the shutdown started is rejected instead of starting a conference.
+	events <- &event.Event{
+		Sender: "@alice:example.org",
+		Type:   event.ToDeviceCallInvite,
+		Content: event.Content{
+			Raw: map[string]interface{}{"conf_id": "conference", "call_id": "call", "device_id": "ALICE"},
+			Parsed: &event.CallInviteEventContent{
+				BaseCallEventContent: event.BaseCallEventContent{CallID: "call", SenderSessionID: "session"},
+			},
+		},
+	}
+
+	select {
+	case messages := <-hangups:
+		hangup := messages.Messages["@alice:example.org"]["ALICE"]
+		if hangup.Reason != conf.CallHangupShutdown || hangup.CallID != "call" {
+			t.Errorf("unexpected hangup: %+v", hangup)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the invite was not rejected")
+	}
+
+	if ids := router.ConferenceIDs(); len(ids) != 0 {
+		t.Errorf("expected no conferences, got %v", ids)
+	}
+}
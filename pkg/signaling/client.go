@@ -45,6 +45,16 @@ func (m *MatrixClient) RunSync(callback func(*event.Event)) error {
 
 	syncer.ParseEventContent = true
 	syncer.OnEvent(func(_ mautrix.EventSource, evt *event.Event) {
+		// The changes of the memberships invalidate the cached authorization decisions.
+		if evt.Type.Class == event.StateEventType {
+			switch evt.Type.Type {
+			case event.StateMember.Type, StateCall.Type, StateCallMember.Type:
+				callback(evt)
+			}
+
+			return
+		}
+
 		// We only care about to-device events but also receive m.presence and
 		// m.push_rules events; we can simply ignore those.
 		if evt.Type.Class != event.ToDeviceEventType {
@@ -30,6 +30,7 @@ const LocalSessionID = "sfu"
 type MatrixSignaler interface {
 	SendMessage(MatrixMessage) error
 	DeviceID() id.DeviceID
+	GetPowerLevels(roomID id.RoomID) (*event.PowerLevelsEventContent, error)
 }
 
 // Defines the data that identifies a receiver of Matrix's to-device message.
@@ -93,6 +94,16 @@ func (m *MatrixForConference) DeviceID() id.DeviceID {
 	return m.client.DeviceID
 }
 
+// Fetches the power levels of a given room, the SFU user must be able to see the room state.
+func (m *MatrixForConference) GetPowerLevels(roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	var powerLevels event.PowerLevelsEventContent
+	if err := m.client.StateEvent(roomID, event.StatePowerLevels, "", &powerLevels); err != nil {
+		return nil, fmt.Errorf("failed to get power levels of %s: %w", roomID, err)
+	}
+
+	return &powerLevels, nil
+}
+
 func (m *MatrixForConference) sendSdpAnswer(
 	recipient MatrixRecipient,
 	streamMetadata event.CallSDPStreamMetadata,
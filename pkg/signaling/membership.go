@@ -0,0 +1,91 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// State events of the group calls (MSC3401).
+var (
+	// The call itself, the state key is the conference ID.
+	StateCall = event.Type{Type: "org.matrix.msc3401.call", Class: event.StateEventType}
+	// The membership of a user in the calls of the room, the state key is the user ID.
+	StateCallMember = event.Type{Type: "org.matrix.msc3401.call.member", Class: event.StateEventType}
+)
+
+type CallMemberEventContent struct {
+	Calls []CallMemberCall `json:"m.calls"`
+}
+
+type CallMemberCall struct {
+	CallID  string             `json:"m.call_id"`
+	Devices []CallMemberDevice `json:"m.devices"`
+}
+
+type CallMemberDevice struct {
+	DeviceID  id.DeviceID  `json:"device_id"`
+	SessionID id.SessionID `json:"session_id"`
+	// The time (in milliseconds since the epoch) when the membership expires, zero if it does not.
+	ExpiresTS int64 `json:"expires_ts,omitempty"`
+}
+
+var (
+	ErrCallNotFound   = errors.New("call does not exist in the room")
+	ErrNotJoined      = errors.New("user is not joined to the room")
+	ErrNotCallMember  = errors.New("device is not a member of the call")
+	ErrMembershipGone = errors.New("call membership expired")
+)
+
+// Checks that the conference is a call in a given room, that the user has joined the room and that the
+// user's device (and session, if known) is a member of the call. The SFU user must be able to see the
+// room state, i.e. it must be joined to the room or the room must be world readable.
+func (m *MatrixClient) CheckCallMembership(
+	roomID id.RoomID,
+	conferenceID string,
+	userID id.UserID,
+	deviceID id.DeviceID,
+	sessionID id.SessionID,
+) error {
+	var call map[string]interface{}
+	if err := m.client.StateEvent(roomID, StateCall, conferenceID, &call); err != nil {
+		return fmt.Errorf("%w: %s (%v)", ErrCallNotFound, conferenceID, err)
+	}
+
+	var member event.MemberEventContent
+	if err := m.client.StateEvent(roomID, event.StateMember, userID.String(), &member); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotJoined, err)
+	}
+
+	if member.Membership != event.MembershipJoin {
+		return fmt.Errorf("%w: membership is %s", ErrNotJoined, member.Membership)
+	}
+
+	var callMember CallMemberEventContent
+	if err := m.client.StateEvent(roomID, StateCallMember, userID.String(), &callMember); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotCallMember, err)
+	}
+
+	for _, call := range callMember.Calls {
+		if call.CallID != conferenceID {
+			continue
+		}
+
+		for _, device := range call.Devices {
+			if device.DeviceID != deviceID || (device.SessionID != "" && sessionID != "" && device.SessionID != sessionID) {
+				continue
+			}
+
+			if device.ExpiresTS != 0 && time.UnixMilli(device.ExpiresTS).Before(time.Now()) {
+				return ErrMembershipGone
+			}
+
+			return nil
+		}
+	}
+
+	return ErrNotCallMember
+}
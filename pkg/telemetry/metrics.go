@@ -0,0 +1,128 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+	metricsdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// How often the metrics are exported.
+const metricsExportInterval = 30 * time.Second
+
+// The instruments are created from the global meter provider, so they can be used before the
+// telemetry is set up (the values are dropped until then) and when it's not set up at all.
+var meter = global.Meter("github.com/matrix-org/waterfall")
+
+// The instruments that the SFU records. Use them with `Telemetry`, so that the values get the
+// attributes of the conference that records them.
+var (
+	ForwardedPackets = int64Counter("waterfall.forwarded.packets",
+		"RTP packets forwarded to the subscribers.", "{packet}")
+	ForwardedBytes = int64Counter("waterfall.forwarded.bytes",
+		"RTP bytes forwarded to the subscribers.", "By")
+	QueueDepth = int64Histogram("waterfall.subscription.queue_depth",
+		"Packets waiting to be forwarded to the subscribers.", "{packet}")
+	RoundTripTime = float64Histogram("waterfall.peer.rtt",
+		"Round-trip time between the SFU and the participants.", "s")
+	PacketLoss = float64Histogram("waterfall.subscription.packet_loss",
+		"Fraction of the packets lost on the way to the subscribers.", "1")
+)
+
+// A simple helper that configures the OpenTelemetry metrics for the SFU. The metrics are only
+// exported over OTLP, so the OTLP host must be set.
+func SetupMetrics(config Config) (*metricsdk.MeterProvider, error) {
+	res, err := NewResource(config.Package, config.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exp, err := NewOTLPMetricExporter(config.OTLP)
+	if err != nil {
+		return nil, err
+	}
+
+	mp := NewMeterProvider(exp, res)
+
+	// Set the meter provider as the global meter provider, the instruments above start exporting now.
+	global.SetMeterProvider(mp)
+
+	return mp, nil
+}
+
+// Creates a meter provider, the metrics counterpart of the trace provider. It periodically
+// collects the values of all instruments and writes them to the exporter.
+func NewMeterProvider(exp metricsdk.Exporter, res *resource.Resource) *metricsdk.MeterProvider {
+	return metricsdk.NewMeterProvider(
+		metricsdk.WithReader(metricsdk.NewPeriodicReader(exp, metricsdk.WithInterval(metricsExportInterval))),
+		metricsdk.WithResource(res),
+	)
+}
+
+// Creates a new OTLP metric exporter, it exports to the same collector as the trace exporter.
+func NewOTLPMetricExporter(config OTLP) (metricsdk.Exporter, error) {
+	if err := validateOTLPHost(config.Host); err != nil {
+		return nil, err
+	}
+
+	host := strings.TrimPrefix(config.Host, "https://")
+	options := []otlpmetrichttp.Option{
+		otlpmetrichttp.WithEndpoint(host),
+		otlpmetrichttp.WithTemporalitySelector(deltaTemporality),
+	}
+
+	if !config.Secure && host == config.Host {
+		options = append(options, otlpmetrichttp.WithInsecure())
+	}
+
+	return otlpmetrichttp.New(context.Background(), options...)
+}
+
+// The cumulative series would be kept and exported long after the conferences end, the delta series are
+// dropped once they are exported. Only the up-down counters must stay cumulative.
+func deltaTemporality(kind metricsdk.InstrumentKind) metricdata.Temporality {
+	switch kind {
+	case metricsdk.InstrumentKindUpDownCounter, metricsdk.InstrumentKindObservableUpDownCounter:
+		return metricdata.CumulativeTemporality
+	default:
+		return metricdata.DeltaTemporality
+	}
+}
+
+func int64Counter(name, description, unit string) instrument.Int64Counter {
+	counter, err := meter.Int64Counter(name, instrument.WithDescription(description), instrument.WithUnit(unit))
+	if err != nil {
+		otel.Handle(err)
+		counter, _ = metric.NewNoopMeter().Int64Counter(name)
+	}
+
+	return counter
+}
+
+func int64Histogram(name, description, unit string) instrument.Int64Histogram {
+	histogram, err := meter.Int64Histogram(name, instrument.WithDescription(description), instrument.WithUnit(unit))
+	if err != nil {
+		otel.Handle(err)
+		histogram, _ = metric.NewNoopMeter().Int64Histogram(name)
+	}
+
+	return histogram
+}
+
+func float64Histogram(name, description, unit string) instrument.Float64Histogram {
+	histogram, err := meter.Float64Histogram(name, instrument.WithDescription(description), instrument.WithUnit(unit))
+	if err != nil {
+		otel.Handle(err)
+		histogram, _ = metric.NewNoopMeter().Float64Histogram(name)
+	}
+
+	return histogram
+}
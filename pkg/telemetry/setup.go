@@ -96,13 +96,8 @@ func NewOTLPExporter(config OTLP) (*otlptrace.Exporter, error) {
 	// you pass the option to the constructor. So we have to check it manually. Otherwise
 	// it'll fail once we start sending traces which is too late it's not something that
 	// we can detect since the error is not returned, but **logged** in stdout.
-	switch {
-	case config.Host == "":
-		return nil, fmt.Errorf("OTLP host is not set")
-	case strings.HasPrefix(config.Host, "http://"):
-		return nil, fmt.Errorf("OTLP host must not contain the protocol")
-	case strings.HasSuffix(config.Host, "/"):
-		return nil, fmt.Errorf("OTLP host must not contain the path or trailing slashes")
+	if err := validateOTLPHost(config.Host); err != nil {
+		return nil, err
 	}
 
 	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Host)}
@@ -112,3 +107,16 @@ func NewOTLPExporter(config OTLP) (*otlptrace.Exporter, error) {
 
 	return otlptrace.New(context.Background(), otlptracehttp.NewClient(options...))
 }
+
+func validateOTLPHost(host string) error {
+	switch {
+	case host == "":
+		return fmt.Errorf("OTLP host is not set")
+	case strings.HasPrefix(host, "http://"):
+		return fmt.Errorf("OTLP host must not contain the protocol")
+	case strings.HasSuffix(host, "/"):
+		return fmt.Errorf("OTLP host must not contain the path or trailing slashes")
+	}
+
+	return nil
+}
@@ -6,15 +6,23 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric/instrument"
 	"go.opentelemetry.io/otel/trace"
 )
 
 type Telemetry struct {
 	span    trace.Span
 	context context.Context //nolint:containedctx
+	// The attributes of the root telemetry (i.e. of the conference), they're attached to the recorded
+	// metrics. The attributes of the children are not, each participant or track would be a new series.
+	metricAttributes []attribute.KeyValue
 }
 
 func NewTelemetry(ctx context.Context, name string, attributes ...attribute.KeyValue) *Telemetry {
+	return newTelemetry(ctx, name, attributes, attributes)
+}
+
+func newTelemetry(ctx context.Context, name string, metricAttributes, attributes []attribute.KeyValue) *Telemetry {
 	// If the name is an empty string then provider uses default name.
 	// If the telemetry has been set up with the set up with `SetupTelemetry` function,
 	// then it will be set to the name of the resources that has been passed to the function.
@@ -23,13 +31,14 @@ func NewTelemetry(ctx context.Context, name string, attributes ...attribute.KeyV
 	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attributes...))
 
 	return &Telemetry{
-		span:    span,
-		context: ctx,
+		span:             span,
+		context:          ctx,
+		metricAttributes: metricAttributes,
 	}
 }
 
 func (t *Telemetry) CreateChild(name string, attributes ...attribute.KeyValue) *Telemetry {
-	return NewTelemetry(t.context, name, attributes...)
+	return newTelemetry(t.context, name, t.metricAttributes, attributes)
 }
 
 func (t *Telemetry) AddEvent(text string, attributes ...attribute.KeyValue) {
@@ -50,6 +59,32 @@ func (t *Telemetry) End() {
 	t.span.End()
 }
 
+// Adds a value to a counter (see the instruments in `metrics.go`). The attributes must only take a few
+// distinct values, e.g. the kind of the track, but not its ID.
+func (t *Telemetry) AddCount(counter instrument.Int64Counter, value int64, attributes ...attribute.KeyValue) {
+	counter.Add(t.context, value, concatAttributes(t.metricAttributes, attributes)...)
+}
+
+// Records a value of a histogram (see the instruments in `metrics.go`).
+func (t *Telemetry) RecordInt(histogram instrument.Int64Histogram, value int64, attributes ...attribute.KeyValue) {
+	histogram.Record(t.context, value, concatAttributes(t.metricAttributes, attributes)...)
+}
+
+// Records a value of a histogram (see the instruments in `metrics.go`).
+func (t *Telemetry) RecordFloat(
+	histogram instrument.Float64Histogram,
+	value float64,
+	attributes ...attribute.KeyValue,
+) {
+	histogram.Record(t.context, value, concatAttributes(t.metricAttributes, attributes)...)
+}
+
+// Concatenates the attributes without modifying the slices, since they're shared between telemetries.
+func concatAttributes(first, second []attribute.KeyValue) []attribute.KeyValue {
+	result := make([]attribute.KeyValue, 0, len(first)+len(second))
+	return append(append(result, first...), second...)
+}
+
 type ChildBuilder struct {
 	parent     *Telemetry
 	attributes []attribute.KeyValue
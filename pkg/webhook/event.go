@@ -0,0 +1,50 @@
+package webhook
+
+// Type of the event that the webhook is notified about.
+type EventType string
+
+const (
+	ConferenceCreated   EventType = "conference.created"
+	ConferenceEnded     EventType = "conference.ended"
+	ParticipantJoined   EventType = "participant.joined"
+	ParticipantLeft     EventType = "participant.left"
+	TrackPublished      EventType = "track.published"
+	TrackRemoved        EventType = "track.removed"
+	SubscriptionChanged EventType = "subscription.changed"
+)
+
+// The body of a webhook request.
+type Event struct {
+	Type EventType `json:"type"`
+	// Unix time in milliseconds when the event happened.
+	Timestamp    int64  `json:"timestamp"`
+	ConferenceID string `json:"conference_id"`
+	// The room of the conference, if known.
+	RoomID string `json:"room_id,omitempty"`
+	// The participant that the event is about (the subscriber for the subscription changes).
+	UserID   string `json:"user_id,omitempty"`
+	DeviceID string `json:"device_id,omitempty"`
+	// Why the participant left or why the subscription changed.
+	Reason       string        `json:"reason,omitempty"`
+	Track        *Track        `json:"track,omitempty"`
+	Subscription *Subscription `json:"subscription,omitempty"`
+}
+
+// The published track that the event is about.
+type Track struct {
+	ID       string `json:"id"`
+	StreamID string `json:"stream_id"`
+	// Either "audio" or "video".
+	Kind string `json:"kind"`
+	// The participant that published the track.
+	OwnerUserID   string `json:"owner_user_id,omitempty"`
+	OwnerDeviceID string `json:"owner_device_id,omitempty"`
+}
+
+// The change of a subscription.
+type Subscription struct {
+	// One of "subscribed", "unsubscribed", "failed" or "layer_changed".
+	Result string `json:"result"`
+	// RID of the simulcast layer that the subscriber gets now, if any.
+	RID string `json:"rid,omitempty"`
+}
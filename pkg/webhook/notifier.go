@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/metrics"
+	"github.com/matrix-org/waterfall/pkg/worker"
+	"github.com/sirupsen/logrus"
+)
+
+// The header that carries the signature of the request body: "sha256=" followed by the hex-encoded
+// HMAC-SHA256 of the body, keyed with the configured secret.
+const SignatureHeader = "X-Waterfall-Signature"
+
+const (
+	// The number of events waiting to be delivered. Further events are dropped while the queue is full.
+	queueSize = 256
+	// How many times the delivery of a single event is attempted.
+	maxAttempts = 4
+	// The delay before the first retry, doubled with each next one.
+	initialRetryDelay = time.Second
+	// Timeout of a single request.
+	requestTimeout = 5 * time.Second
+	// How long to wait for the queued events to be delivered when the notifier stops.
+	flushTimeout = 5 * time.Second
+)
+
+var errRetryable = errors.New("retryable webhook failure")
+
+// Configuration of the webhook.
+type Config struct {
+	// The URL that the events are POSTed to. Disabled if not set.
+	URL string `yaml:"url"`
+	// The secret that the requests are signed with, see `SignatureHeader`.
+	Secret string `yaml:"secret"`
+	// The types of the events to send, all of them if not set.
+	Events []EventType `yaml:"events"`
+}
+
+// Delivers the events to the webhook in the background, so that a slow receiver never blocks the
+// conferences. Safe to use from any go-routine. A nil notifier ignores all events.
+type Notifier struct {
+	config     Config
+	client     *http.Client
+	worker     *worker.Worker[delivery]
+	retryDelay time.Duration
+}
+
+// A single attempt to deliver an event.
+type delivery struct {
+	event   Event
+	body    []byte
+	attempt int
+}
+
+// Creates a new notifier or returns nil if the webhook is not configured.
+func NewNotifier(config Config) *Notifier {
+	if config.URL == "" {
+		return nil
+	}
+
+	notifier := &Notifier{
+		config:     config,
+		client:     &http.Client{Timeout: requestTimeout},
+		retryDelay: initialRetryDelay,
+	}
+
+	notifier.worker = worker.StartWorker(worker.Config[delivery]{
+		ChannelSize: queueSize,
+		Timeout:     time.Hour,
+		OnTimeout:   func() {},
+		OnTask:      notifier.deliver,
+	})
+
+	return notifier
+}
+
+// Queues an event for the delivery. Never blocks, the event is dropped if the queue is full.
+func (n *Notifier) Notify(event Event) {
+	if n == nil || !n.wants(event.Type) {
+		return
+	}
+
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().UnixMilli()
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logrus.WithError(err).Error("failed to marshal webhook event")
+		return
+	}
+
+	n.queue(delivery{event: event, body: body, attempt: 1})
+}
+
+// Queues a delivery attempt, the attempt is dropped if the queue is full or the notifier is stopped.
+func (n *Notifier) queue(attempt delivery) {
+	if err := n.worker.Send(attempt); err != nil {
+		if errors.Is(err, worker.ErrWorkerTooBusy) {
+			metrics.WorkerDrops.With("webhook").Inc()
+		}
+
+		logrus.WithError(err).WithField("type", attempt.event.Type).Warn("dropping webhook event")
+	}
+}
+
+// Stops accepting new events and waits (for a bounded time) until the queued ones are delivered. The
+// retries that are not due yet are dropped.
+func (n *Notifier) Stop() {
+	if n == nil {
+		return
+	}
+
+	n.worker.Stop()
+
+	select {
+	case <-n.worker.Done():
+	case <-time.After(flushTimeout):
+		logrus.Warn("timed out while delivering the remaining webhook events")
+	}
+}
+
+// Returns true if the webhook is interested in the events of a given type.
+func (n *Notifier) wants(eventType EventType) bool {
+	if len(n.config.Events) == 0 {
+		return true
+	}
+
+	for _, wanted := range n.config.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Attempts to deliver an event. If the receiver is not available, the next attempt is queued again after
+// an exponential backoff, so that the events behind it are not held up in the meantime.
+func (n *Notifier) deliver(attempt delivery) {
+	err := n.post(attempt.body)
+	if err == nil {
+		return
+	}
+
+	logger := logrus.WithError(err).WithField("type", attempt.event.Type).WithField("attempt", attempt.attempt)
+	if !errors.Is(err, errRetryable) || attempt.attempt == maxAttempts {
+		metrics.WebhookFailures.Inc()
+		logger.Error("failed to deliver webhook event")
+		return
+	}
+
+	logger.Warn("failed to deliver webhook event, retrying")
+	delay := n.retryDelay << (attempt.attempt - 1)
+	attempt.attempt++
+	time.AfterFunc(delay, func() { n.queue(attempt) })
+}
+
+func (n *Notifier) post(body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, n.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(SignatureHeader, Sign(n.config.Secret, body))
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errRetryable, err)
+	}
+	defer response.Body.Close()
+
+	// The body is drained, so that the connection can be reused for the next event.
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return nil
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		return fmt.Errorf("%w: status %d", errRetryable, response.StatusCode)
+	default:
+		return fmt.Errorf("webhook rejected the event: status %d", response.StatusCode)
+	}
+}
+
+// Returns the signature of a given body in the format of the `SignatureHeader`.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
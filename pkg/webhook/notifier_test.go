@@ -0,0 +1,108 @@
+package webhook //nolint:testpackage
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNotifierSignsAndRetries(t *testing.T) {
+	const secret = "secret"
+
+	var attempts atomic.Int32
+	received := make(chan Event, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if signature := r.Header.Get(SignatureHeader); signature != Sign(secret, body) {
+			t.Errorf("unexpected signature: %s", signature)
+		}
+
+		// The first attempt fails, the receiver is "overloaded".
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("failed to unmarshal event: %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(Config{URL: server.URL, Secret: secret})
+	notifier.retryDelay = time.Millisecond
+	notifier.Notify(Event{Type: ConferenceCreated, ConferenceID: "conf"})
+
+	select {
+	case event := <-received:
+		if event.Type != ConferenceCreated || event.ConferenceID != "conf" || event.Timestamp == 0 {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the event was not delivered")
+	}
+
+	notifier.Stop()
+	if attempts.Load() != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestNotifierFiltersEvents(t *testing.T) {
+	notifier := &Notifier{config: Config{Events: []EventType{ParticipantJoined}}}
+	if notifier.wants(TrackPublished) || !notifier.wants(ParticipantJoined) {
+		t.Error("unexpected event filter")
+	}
+
+	// The notifier that is not configured ignores everything.
+	var disabled *Notifier
+	disabled.Notify(Event{Type: ConferenceCreated})
+	disabled.Stop()
+}
+
+func TestNotifierRetriesWithoutBlockingTheQueue(t *testing.T) {
+	received := make(chan EventType, 2)
+	var failed atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to unmarshal event: %v", err)
+		}
+
+		// The first attempt to deliver the first event fails.
+		if event.Type == ConferenceCreated && !failed.Swap(true) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		received <- event.Type
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(Config{URL: server.URL})
+	notifier.retryDelay = 200 * time.Millisecond
+	notifier.Notify(Event{Type: ConferenceCreated})
+	notifier.Notify(Event{Type: ConferenceEnded})
+
+	// The second event is delivered while the retry of the first one is pending.
+	for _, expected := range []EventType{ConferenceEnded, ConferenceCreated} {
+		select {
+		case eventType := <-received:
+			if eventType != expected {
+				t.Errorf("expected %s, got %s", expected, eventType)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s was not delivered", expected)
+		}
+	}
+
+	notifier.Stop()
+}
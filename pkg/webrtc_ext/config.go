@@ -1,9 +1,13 @@
 package webrtc_ext
 
+import "github.com/pion/transport/vnet"
+
 // Configuration of the WebRTC API for the SFU.
 type Config struct {
 	// Enable simulcast extension.
 	EnableSimulcast bool `yaml:"simulcast"`
 	// Pulibc IP address of the SFU.
 	PublicIPs []string `yaml:"ipAddresses"`
+	// Virtual network that the peer connections use instead of the real one (only for the tests).
+	VirtualNetwork *vnet.Net `yaml:"-"`
 }
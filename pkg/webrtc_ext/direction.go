@@ -0,0 +1,30 @@
+package webrtc_ext
+
+import "github.com/pion/sdp/v3"
+
+// Removes the directions in which the remote peer would send media to us from a local description, so
+// that the receive-only peers (e.g. viewers) don't send any media even if they offer to.
+func WithoutIncomingMedia(description string) string {
+	parsed := sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(description)); err != nil {
+		return description
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		for i, attribute := range media.Attributes {
+			switch attribute.Key {
+			case "sendrecv":
+				media.Attributes[i] = sdp.NewPropertyAttribute("sendonly")
+			case "recvonly":
+				media.Attributes[i] = sdp.NewPropertyAttribute("inactive")
+			}
+		}
+	}
+
+	marshaled, err := parsed.Marshal()
+	if err != nil {
+		return description
+	}
+
+	return string(marshaled)
+}
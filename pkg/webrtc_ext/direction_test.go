@@ -0,0 +1,36 @@
+package webrtc_ext //nolint:testpackage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWithoutIncomingMedia(t *testing.T) {
+	description := strings.Join([]string{
+		"v=0",
+		"o=- 1 1 IN IP4 0.0.0.0",
+		"s=-",
+		"t=0 0",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:0",
+		"a=sendrecv",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:1",
+		"a=recvonly",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:2",
+		"a=sendonly",
+		"",
+	}, "\r\n")
+
+	result := WithoutIncomingMedia(description)
+	for _, expected := range []string{"a=mid:0\r\na=sendonly", "a=mid:1\r\na=inactive", "a=mid:2\r\na=sendonly"} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected %q in:\n%s", expected, result)
+		}
+	}
+
+	if strings.Contains(result, "sendrecv") || strings.Contains(result, "recvonly") {
+		t.Errorf("the description still receives media:\n%s", result)
+	}
+}
@@ -8,19 +8,38 @@ import (
 
 // Peer connection factory is used to construct new (pre-configured) peer connections.
 type PeerConnectionFactory struct {
-	api *webrtc.API
+	config Config
 }
 
 func NewPeerConnectionFactory(config Config) (*PeerConnectionFactory, error) {
-	api, err := createWebRTCAPI(config)
-	if err != nil {
+	// Make sure that the configuration is valid before we accept any calls.
+	rtx := newRTXInterceptor(nil)
+	defer rtx.Close()
+
+	if _, err := createWebRTCAPI(config, rtx); err != nil {
 		return nil, fmt.Errorf("failed to create WebRTC API: %w", err)
 	}
 
-	return &PeerConnectionFactory{api}, nil
+	return &PeerConnectionFactory{config}, nil
 }
 
 // Creates a peer connection with a specifically configured API (with simulcast etc).
-func (f *PeerConnectionFactory) CreatePeerConnection() (*webrtc.PeerConnection, error) {
-	return f.api.NewPeerConnection(webrtc.Configuration{})
+// Returns the peer connection along with its RTX interceptor. The interceptor calls `onNackDropped`
+// (if set) for each NACK of the remote peer that it drops since too many of them are pending.
+func (f *PeerConnectionFactory) CreatePeerConnection(
+	onNackDropped func(),
+) (*webrtc.PeerConnection, *RTXInterceptor, error) {
+	rtx := newRTXInterceptor(onNackDropped)
+
+	api, err := createWebRTCAPI(f.config, rtx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create WebRTC API: %w", err)
+	}
+
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return peerConnection, rtx, nil
 }
@@ -0,0 +1,572 @@
+package webrtc_ext
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/worker"
+	"github.com/pion/interceptor"
+	"github.com/pion/randutil"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+)
+
+const (
+	rtpStreamIDURI         = "urn:ietf:params:rtp-hdrext:sdes:rtp-stream-id"
+	repairedRTPStreamIDURI = "urn:ietf:params:rtp-hdrext:sdes:repaired-rtp-stream-id"
+
+	// The amount of outgoing packets that we keep per stream to be able to answer NACKs.
+	// Must be a power of 2 so that the sequence number wrap around is handled correctly.
+	rtxSendBufferSize = 1024
+	// The amount of repaired packets that may wait to be delivered to the media stream.
+	rtxPendingPackets = 64
+	// The amount of NACKs that may wait to be answered, the rest is dropped (the remote peer repeats them).
+	rtxPendingNacks = 128
+)
+
+// Interceptor that implements RTX (RFC 4588) for a single peer connection.
+//
+// Pion reads the repair streams of the remote tracks, but silently drops the packets,
+// so we unwrap them here and inject them into the corresponding media stream, as if
+// the original packet has been received. This means that everything that reads the
+// media stream (NACK generator, our publishers, etc) sees the retransmissions in the
+// primary sequence space.
+//
+// In the opposite direction the interceptor replaces the default NACK responder and
+// answers NACKs with RTX packets if the remote peer negotiated RTX for the codec and we
+// signaled the repair stream, or with plain retransmissions on the media SSRC otherwise.
+//
+// The interceptor relies on the remote session description to learn the RTX payload
+// types and SSRCs, so `UpdateRemoteDescription()` must be called before the description
+// is applied to the peer connection. Pion does not signal the repair streams of the local
+// tracks, so the local descriptions must pass `SignalRepairStreams()` before they are sent.
+type RTXInterceptor struct {
+	interceptor.NoOp
+
+	mutex sync.Mutex
+	// RTX payload type to the payload type of the associated media codec (`apt`).
+	rtxToMedia map[uint8]uint8
+	// Media payload type to the payload type of the associated RTX codec.
+	mediaToRTX map[uint8]uint8
+	// RTX SSRC to the media SSRC (from `a=ssrc-group:FID`).
+	repairedSSRCs map[uint32]uint32
+	// RID of the simulcast layer to the media SSRC (learned from the incoming packets).
+	ridToSSRC map[string]uint32
+	// Remote streams (media streams only) by their SSRC.
+	remoteStreams map[uint32]*rtxRemoteStream
+	// Local streams that support NACKs by their SSRC.
+	localStreams map[uint32]*rtxLocalStream
+	// Media SSRC of the local streams to the SSRC of the repair stream that we signaled for them.
+	localRepairSSRCs map[uint32]uint32
+	// Answers NACKs outside of the RTCP reader, so that the reader is never blocked.
+	nacks *worker.Worker[*rtcp.TransportLayerNack]
+	// Called for each NACK that is dropped since too many of them are pending (optional).
+	onNackDropped func()
+}
+
+type rtxRemoteStream struct {
+	// Repaired packets that are waiting to be returned by the media stream reader.
+	pending chan []byte
+	// RID of the stream (if any), once it's known.
+	rid string
+}
+
+type rtxLocalStream struct {
+	writer      interceptor.RTPWriter
+	payloadType uint8
+	buffer      *rtxSendBuffer
+
+	// Sequence number of the repair stream, the SSRC is the one from `localRepairSSRCs`.
+	sequenceNumber uint16
+}
+
+// Factory that creates an interceptor for a single peer connection.
+type rtxInterceptorFactory struct {
+	interceptor *RTXInterceptor
+}
+
+func (f *rtxInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return f.interceptor, nil
+}
+
+func newRTXInterceptor(onNackDropped func()) *RTXInterceptor {
+	rtx := &RTXInterceptor{
+		onNackDropped:    onNackDropped,
+		rtxToMedia:       make(map[uint8]uint8),
+		mediaToRTX:       make(map[uint8]uint8),
+		repairedSSRCs:    make(map[uint32]uint32),
+		ridToSSRC:        make(map[string]uint32),
+		remoteStreams:    make(map[uint32]*rtxRemoteStream),
+		localStreams:     make(map[uint32]*rtxLocalStream),
+		localRepairSSRCs: make(map[uint32]uint32),
+	}
+
+	rtx.nacks = worker.StartWorker(worker.Config[*rtcp.TransportLayerNack]{
+		ChannelSize: rtxPendingNacks,
+		Timeout:     time.Hour,
+		OnTimeout:   func() {},
+		OnTask:      rtx.retransmit,
+	})
+
+	return rtx
+}
+
+// Implementation of the `interceptor.Interceptor`. Called once the peer connection is closed.
+func (r *RTXInterceptor) Close() error {
+	r.nacks.Stop()
+	return nil
+}
+
+// Adds the repair streams of the local tracks (`a=ssrc-group:FID`) to the local session description,
+// so that the remote peer accepts our RTX packets. The repair SSRCs stay the same across renegotiations.
+// Returns the description unchanged if it can't be parsed.
+func (r *RTXInterceptor) SignalRepairStreams(description string) string {
+	parsed := sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(description)); err != nil {
+		return description
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, media := range parsed.MediaDescriptions {
+		hasRTX := false
+		grouped := make(map[uint32]struct{})
+		ssrcAttributes := make(map[uint32][]string)
+		var ssrcs []uint32
+
+		for _, attribute := range media.Attributes {
+			switch attribute.Key {
+			case "rtpmap":
+				if _, rest, ok := splitAttribute(attribute.Value); ok && strings.HasPrefix(strings.ToLower(rest), "rtx/") {
+					hasRTX = true
+				}
+			case "ssrc-group":
+				for _, field := range strings.Fields(attribute.Value) {
+					if ssrc, err := strconv.ParseUint(field, 10, 32); err == nil {
+						grouped[uint32(ssrc)] = struct{}{}
+					}
+				}
+			case "ssrc":
+				value, rest, ok := strings.Cut(attribute.Value, " ")
+				ssrc, err := strconv.ParseUint(value, 10, 32)
+				if !ok || err != nil {
+					continue
+				}
+
+				if _, known := ssrcAttributes[uint32(ssrc)]; !known {
+					ssrcs = append(ssrcs, uint32(ssrc))
+				}
+				ssrcAttributes[uint32(ssrc)] = append(ssrcAttributes[uint32(ssrc)], rest)
+			}
+		}
+
+		if !hasRTX {
+			continue
+		}
+
+		for _, ssrc := range ssrcs {
+			if _, ok := grouped[ssrc]; ok {
+				continue
+			}
+
+			repairSSRC, ok := r.localRepairSSRCs[ssrc]
+			if !ok {
+				repairSSRC = randutil.NewMathRandomGenerator().Uint32()
+				r.localRepairSSRCs[ssrc] = repairSSRC
+			}
+
+			media.WithValueAttribute("ssrc-group", fmt.Sprintf("FID %d %d", ssrc, repairSSRC))
+			for _, rest := range ssrcAttributes[ssrc] {
+				media.WithValueAttribute("ssrc", fmt.Sprintf("%d %s", repairSSRC, rest))
+			}
+		}
+	}
+
+	signaled, err := parsed.Marshal()
+	if err != nil {
+		return description
+	}
+
+	return string(signaled)
+}
+
+// Learns RTX payload types and repair SSRCs from the remote session description.
+func (r *RTXInterceptor) UpdateRemoteDescription(description string) error {
+	parsed := sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(description)); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, media := range parsed.MediaDescriptions {
+		rtxPayloadTypes := make(map[uint8]struct{})
+
+		for _, attribute := range media.Attributes {
+			switch attribute.Key {
+			case "rtpmap":
+				// a=rtpmap:97 rtx/90000
+				if payloadType, rest, ok := splitAttribute(attribute.Value); ok {
+					if strings.HasPrefix(strings.ToLower(rest), "rtx/") {
+						rtxPayloadTypes[payloadType] = struct{}{}
+					}
+				}
+			case "ssrc-group":
+				// a=ssrc-group:FID <media SSRC> <repair SSRC>
+				fields := strings.Fields(attribute.Value)
+				if len(fields) == 3 && fields[0] == "FID" {
+					media, mediaErr := strconv.ParseUint(fields[1], 10, 32)
+					repair, repairErr := strconv.ParseUint(fields[2], 10, 32)
+					if mediaErr == nil && repairErr == nil {
+						r.repairedSSRCs[uint32(repair)] = uint32(media)
+					}
+				}
+			}
+		}
+
+		// a=fmtp:97 apt=96
+		for _, attribute := range media.Attributes {
+			if attribute.Key != "fmtp" {
+				continue
+			}
+
+			payloadType, parameters, ok := splitAttribute(attribute.Value)
+			if _, isRTX := rtxPayloadTypes[payloadType]; !ok || !isRTX {
+				continue
+			}
+
+			for _, parameter := range strings.Split(parameters, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(parameter), "=")
+				if !found || key != "apt" {
+					continue
+				}
+
+				if apt, err := strconv.ParseUint(value, 10, 8); err == nil {
+					r.rtxToMedia[payloadType] = uint8(apt)
+					r.mediaToRTX[uint8(apt)] = payloadType
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Implementation of the `interceptor.Interceptor`. Injects the unwrapped retransmissions into the media streams.
+func (r *RTXInterceptor) BindRemoteStream(
+	info *interceptor.StreamInfo,
+	reader interceptor.RTPReader,
+) interceptor.RTPReader {
+	ridExtensionID := headerExtensionID(info, rtpStreamIDURI)
+	repairedRIDExtensionID := headerExtensionID(info, repairedRTPStreamIDURI)
+
+	stream := &rtxRemoteStream{pending: make(chan []byte, rtxPendingPackets)}
+
+	r.mutex.Lock()
+	r.remoteStreams[info.SSRC] = stream
+	r.mutex.Unlock()
+
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		// Deliver repaired packets first (if any). Note that a repaired packet is delivered once the
+		// next read of the media stream is issued, i.e. at the latest after the next media packet.
+		select {
+		case repaired := <-stream.pending:
+			return copy(b, repaired), make(interceptor.Attributes), nil
+		default:
+		}
+
+		n, attributes, err := reader.Read(b, a)
+		if err != nil || n < 2 {
+			return n, attributes, err
+		}
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		// Check if it's a packet from the repair stream by its payload type.
+		payloadType := b[1] & 0x7F
+		mediaPayloadType, isRTX := r.rtxToMedia[payloadType]
+		if !isRTX {
+			r.learnRID(info.SSRC, stream, b[:n], ridExtensionID)
+			return n, attributes, err
+		}
+
+		r.repair(info.SSRC, b[:n], mediaPayloadType, repairedRIDExtensionID)
+
+		// Pion drops the packets from the repair streams anyway.
+		return n, attributes, err
+	})
+}
+
+// Implementation of the `interceptor.Interceptor`.
+func (r *RTXInterceptor) UnbindRemoteStream(info *interceptor.StreamInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.remoteStreams, info.SSRC)
+	for rid, ssrc := range r.ridToSSRC {
+		if ssrc == info.SSRC {
+			delete(r.ridToSSRC, rid)
+		}
+	}
+}
+
+// Implementation of the `interceptor.Interceptor`. Buffers outgoing packets to be able to answer NACKs.
+func (r *RTXInterceptor) BindLocalStream(
+	info *interceptor.StreamInfo,
+	writer interceptor.RTPWriter,
+) interceptor.RTPWriter {
+	if !supportsNack(info) {
+		return writer
+	}
+
+	stream := &rtxLocalStream{
+		writer:         writer,
+		payloadType:    info.PayloadType,
+		buffer:         &rtxSendBuffer{},
+		sequenceNumber: uint16(randutil.NewMathRandomGenerator().Uint32()),
+	}
+
+	r.mutex.Lock()
+	r.localStreams[info.SSRC] = stream
+	r.mutex.Unlock()
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, a interceptor.Attributes) (int, error) {
+		stream.buffer.add(header, payload)
+		return writer.Write(header, payload, a)
+	})
+}
+
+// Implementation of the `interceptor.Interceptor`.
+func (r *RTXInterceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.localStreams, info.SSRC)
+}
+
+// Implementation of the `interceptor.Interceptor`. Answers NACKs from the remote peer.
+func (r *RTXInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attributes, err := reader.Read(b, a)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if attributes == nil {
+			attributes = make(interceptor.Attributes)
+		}
+
+		packets, err := attributes.GetRTCPPackets(b[:n])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		for _, packet := range packets {
+			if nack, ok := packet.(*rtcp.TransportLayerNack); ok {
+				if err := r.nacks.Send(nack); errors.Is(err, worker.ErrWorkerTooBusy) && r.onNackDropped != nil {
+					r.onNackDropped()
+				}
+			}
+		}
+
+		return n, attributes, nil
+	})
+}
+
+// Learns which media SSRC corresponds to which RID. Must be called with the mutex held.
+func (r *RTXInterceptor) learnRID(ssrc uint32, stream *rtxRemoteStream, packet []byte, ridExtensionID uint8) {
+	if ridExtensionID == 0 || stream.rid != "" {
+		return
+	}
+
+	header := rtp.Header{}
+	if _, err := header.Unmarshal(packet); err != nil {
+		return
+	}
+
+	if rid := header.GetExtension(ridExtensionID); len(rid) != 0 {
+		stream.rid = string(rid)
+		r.ridToSSRC[stream.rid] = ssrc
+	}
+}
+
+// Unwraps a packet from the repair stream and queues it for the media stream.
+// Must be called with the mutex held.
+func (r *RTXInterceptor) repair(
+	repairSSRC uint32,
+	buffer []byte,
+	mediaPayloadType uint8,
+	repairedRIDExtensionID uint8,
+) {
+	packet := rtp.Packet{}
+	if err := packet.Unmarshal(buffer); err != nil {
+		return
+	}
+
+	// Find the media stream that this repair stream belongs to.
+	mediaSSRC, found := r.repairedSSRCs[repairSSRC]
+	if !found && repairedRIDExtensionID != 0 {
+		mediaSSRC, found = r.ridToSSRC[string(packet.GetExtension(repairedRIDExtensionID))]
+	}
+
+	stream := r.remoteStreams[mediaSSRC]
+	if !found || stream == nil {
+		return
+	}
+
+	// Padding-only packets are used for the bandwidth probing, there is nothing to repair.
+	if !unwrapRTX(&packet, mediaSSRC, mediaPayloadType) {
+		return
+	}
+
+	repaired, err := packet.Marshal()
+	if err != nil {
+		return
+	}
+
+	select {
+	case stream.pending <- repaired:
+	default:
+		// The media stream is not being read fast enough, drop the retransmission.
+	}
+}
+
+// Answers the NACK with RTX (if negotiated and signaled) or plain retransmissions.
+func (r *RTXInterceptor) retransmit(nack *rtcp.TransportLayerNack) {
+	r.mutex.Lock()
+	stream := r.localStreams[nack.MediaSSRC]
+	var rtxPayloadType uint8
+	var useRTX bool
+	repairSSRC, signaled := r.localRepairSSRCs[nack.MediaSSRC]
+	if stream != nil {
+		rtxPayloadType, useRTX = r.mediaToRTX[stream.payloadType]
+		useRTX = useRTX && signaled
+	}
+	r.mutex.Unlock()
+
+	if stream == nil {
+		return
+	}
+
+	for i := range nack.Nacks {
+		nack.Nacks[i].Range(func(sequenceNumber uint16) bool {
+			packet := stream.buffer.get(sequenceNumber)
+			if packet == nil {
+				return true
+			}
+
+			if useRTX {
+				r.mutex.Lock()
+				packet = wrapRTX(packet, repairSSRC, rtxPayloadType, stream.sequenceNumber)
+				stream.sequenceNumber++
+				r.mutex.Unlock()
+			}
+
+			// Errors are not critical here, the remote peer will NACK the packet again if needed.
+			_, _ = stream.writer.Write(&packet.Header, packet.Payload, interceptor.Attributes{})
+
+			return true
+		})
+	}
+}
+
+// Wraps a media packet into an RTX packet (RFC 4588, section 4).
+func wrapRTX(packet *rtp.Packet, ssrc uint32, payloadType uint8, sequenceNumber uint16) *rtp.Packet {
+	payload := make([]byte, 2+len(packet.Payload))
+	binary.BigEndian.PutUint16(payload, packet.SequenceNumber)
+	copy(payload[2:], packet.Payload)
+
+	wrapped := &rtp.Packet{Header: packet.Header.Clone(), Payload: payload}
+	wrapped.SSRC = ssrc
+	wrapped.PayloadType = payloadType
+	wrapped.SequenceNumber = sequenceNumber
+	wrapped.Padding = false
+
+	return wrapped
+}
+
+// Unwraps an RTX packet into the original media packet (RFC 4588, section 4).
+// Returns `false` if the packet does not contain a retransmission.
+func unwrapRTX(packet *rtp.Packet, ssrc uint32, payloadType uint8) bool {
+	if len(packet.Payload) < 2 {
+		return false
+	}
+
+	packet.SequenceNumber = binary.BigEndian.Uint16(packet.Payload[:2])
+	packet.Payload = packet.Payload[2:]
+	packet.SSRC = ssrc
+	packet.PayloadType = payloadType
+	packet.Padding = false
+	packet.PaddingSize = 0
+
+	return true
+}
+
+// A ring buffer of the latest outgoing packets of a local stream.
+type rtxSendBuffer struct {
+	mutex   sync.Mutex
+	packets [rtxSendBufferSize]*rtp.Packet
+}
+
+func (b *rtxSendBuffer) add(header *rtp.Header, payload []byte) {
+	packet := &rtp.Packet{Header: header.Clone(), Payload: append([]byte(nil), payload...)}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.packets[header.SequenceNumber%rtxSendBufferSize] = packet
+}
+
+func (b *rtxSendBuffer) get(sequenceNumber uint16) *rtp.Packet {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if packet := b.packets[sequenceNumber%rtxSendBufferSize]; packet != nil && packet.SequenceNumber == sequenceNumber {
+		return packet
+	}
+
+	return nil
+}
+
+// Splits attributes like `97 rtx/90000` into the payload type and the rest.
+func splitAttribute(value string) (uint8, string, bool) {
+	payloadType, rest, found := strings.Cut(value, " ")
+	if !found {
+		return 0, "", false
+	}
+
+	parsed, err := strconv.ParseUint(payloadType, 10, 8)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return uint8(parsed), rest, true
+}
+
+func headerExtensionID(info *interceptor.StreamInfo, uri string) uint8 {
+	for _, extension := range info.RTPHeaderExtensions {
+		if extension.URI == uri {
+			return uint8(extension.ID)
+		}
+	}
+
+	return 0
+}
+
+func supportsNack(info *interceptor.StreamInfo) bool {
+	for _, feedback := range info.RTCPFeedback {
+		if feedback.Type == "nack" && feedback.Parameter == "" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,114 @@
+package webrtc_ext //nolint:testpackage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestRTXWrapUnwrap(t *testing.T) {
+	original := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 65535, Timestamp: 1234, SSRC: 1111},
+		Payload: []byte{1, 2, 3, 4},
+	}
+
+	wrapped := wrapRTX(original, 2222, 97, 10)
+	if wrapped.SSRC != 2222 || wrapped.PayloadType != 97 || wrapped.SequenceNumber != 10 {
+		t.Fatalf("unexpected RTX header: %+v", wrapped.Header)
+	}
+
+	if !bytes.Equal(wrapped.Payload, []byte{0xff, 0xff, 1, 2, 3, 4}) {
+		t.Fatalf("unexpected RTX payload: %v", wrapped.Payload)
+	}
+
+	if !unwrapRTX(wrapped, 1111, 96) {
+		t.Fatal("failed to unwrap RTX packet")
+	}
+
+	if wrapped.SSRC != 1111 || wrapped.PayloadType != 96 || wrapped.SequenceNumber != 65535 {
+		t.Fatalf("unexpected unwrapped header: %+v", wrapped.Header)
+	}
+
+	if !bytes.Equal(wrapped.Payload, original.Payload) || wrapped.Timestamp != original.Timestamp {
+		t.Fatal("unwrapped packet does not match the original one")
+	}
+
+	// Padding-only packets (bandwidth probing) can't be unwrapped.
+	if unwrapRTX(&rtp.Packet{Payload: []byte{1}}, 1111, 96) {
+		t.Fatal("expected padding-only packet to be rejected")
+	}
+}
+
+func TestRTXUpdateRemoteDescription(t *testing.T) {
+	description := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"a=rtpmap:97 rtx/90000\r\n" +
+		"a=fmtp:97 apt=96\r\n" +
+		"a=ssrc-group:FID 1111 2222\r\n"
+
+	rtx := newRTXInterceptor(nil)
+	defer rtx.Close()
+
+	if err := rtx.UpdateRemoteDescription(description); err != nil {
+		t.Fatalf("failed to parse description: %v", err)
+	}
+
+	if rtx.rtxToMedia[97] != 96 || rtx.mediaToRTX[96] != 97 {
+		t.Fatalf("unexpected RTX payload types: %v", rtx.rtxToMedia)
+	}
+
+	if rtx.repairedSSRCs[2222] != 1111 {
+		t.Fatalf("unexpected repaired SSRCs: %v", rtx.repairedSSRCs)
+	}
+}
+
+func TestRTXSignalRepairStreams(t *testing.T) {
+	description := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=-\r\n" +
+		"t=0 0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n" +
+		"a=rtpmap:96 VP8/90000\r\n" +
+		"a=rtpmap:97 rtx/90000\r\n" +
+		"a=fmtp:97 apt=96\r\n" +
+		"a=ssrc:1111 cname:stream\r\n" +
+		"a=ssrc:1111 msid:stream track\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"a=ssrc:3333 cname:stream\r\n"
+
+	rtx := newRTXInterceptor(nil)
+	defer rtx.Close()
+
+	signaled := rtx.SignalRepairStreams(description)
+	repairSSRC, ok := rtx.localRepairSSRCs[1111]
+	if !ok || len(rtx.localRepairSSRCs) != 1 {
+		t.Fatalf("expected a repair stream for the video only: %v", rtx.localRepairSSRCs)
+	}
+
+	for _, line := range []string{
+		fmt.Sprintf("a=ssrc-group:FID 1111 %d\r\n", repairSSRC),
+		fmt.Sprintf("a=ssrc:%d cname:stream\r\n", repairSSRC),
+		fmt.Sprintf("a=ssrc:%d msid:stream track\r\n", repairSSRC),
+	} {
+		if !strings.Contains(signaled, line) {
+			t.Errorf("missing %q in %s", line, signaled)
+		}
+	}
+
+	// The renegotiation keeps the repair stream and does not signal it twice.
+	if again := rtx.SignalRepairStreams(signaled); again != signaled {
+		t.Errorf("the repair stream is signaled again: %s", again)
+	}
+
+	if rtx.SignalRepairStreams(description) != signaled {
+		t.Error("the repair SSRC changed")
+	}
+}
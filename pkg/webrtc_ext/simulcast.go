@@ -0,0 +1,246 @@
+package webrtc_ext
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+// A simulcast layer of a track. The layers are numbered from the lowest quality to the highest
+// one according to the `SimulcastLayout` of the track, so `SimulcastLayerLow` is always the lowest
+// layer, but `SimulcastLayerHigh` is only the highest one if the track has exactly 3 layers.
+type SimulcastLayer int
+
+const (
+	SimulcastLayerNone SimulcastLayer = iota
+	SimulcastLayerLow
+	SimulcastLayerMedium
+	SimulcastLayerHigh
+)
+
+func (s SimulcastLayer) String() string {
+	switch s {
+	case SimulcastLayerNone:
+		return ""
+	case SimulcastLayerLow:
+		return "low"
+	case SimulcastLayerMedium:
+		return "medium"
+	case SimulcastLayerHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("layer-%d", int(s))
+	}
+}
+
+// A single RTP stream of a simulcast track as negotiated in the SDP (RFC 8851, RFC 8853).
+type SimulcastRID struct {
+	// The RID of the stream.
+	ID string
+	// Restrictions from the `a=rid` attribute, zero if not set.
+	MaxWidth, MaxHeight, MaxBitrate int
+	// The factor by which the resolution of the stream is scaled down relative to the full
+	// resolution of the track if it's known (e.g. from the well-known RID names), zero otherwise.
+	ScaleDownBy int
+}
+
+// The simulcast layers of a track ordered from the lowest to the highest quality.
+type SimulcastLayout struct {
+	RIDs []SimulcastRID
+	// Set if the order is derived from the restrictions or the well-known RID names. Otherwise
+	// the order is the one from the SDP and it may be re-evaluated once the streams are observed.
+	Ordered bool
+}
+
+// Well-known RID names (quarter, half and full resolution) that we've been using so far.
+var wellKnownRIDs = map[string]SimulcastRID{
+	"q": {ID: "q", ScaleDownBy: 4},
+	"h": {ID: "h", ScaleDownBy: 2},
+	"f": {ID: "f", ScaleDownBy: 1},
+}
+
+// Returns the layer of a given RID. The RIDs that we don't know are added as the highest layer,
+// the empty RID (no simulcast) is always `SimulcastLayerNone`.
+func (l *SimulcastLayout) Layer(rid string) SimulcastLayer {
+	if rid == "" {
+		return SimulcastLayerNone
+	}
+
+	for i, known := range l.RIDs {
+		if known.ID == rid {
+			return SimulcastLayer(i + 1)
+		}
+	}
+
+	unknown := SimulcastRID{ID: rid}
+	if wellKnown, found := wellKnownRIDs[rid]; found {
+		unknown = wellKnown
+	}
+
+	l.RIDs = append(l.RIDs, unknown)
+	l.Ordered = false
+
+	return SimulcastLayer(len(l.RIDs))
+}
+
+// Returns the description of the RTP stream of a given layer, if any.
+func (l *SimulcastLayout) RID(layer SimulcastLayer) (SimulcastRID, bool) {
+	if layer <= SimulcastLayerNone || int(layer) > len(l.RIDs) {
+		return SimulcastRID{}, false
+	}
+
+	return l.RIDs[layer-1], true
+}
+
+// Parses the simulcast layouts of all tracks that the remote peer sends from its session description.
+// The result is indexed by the track ID (`a=msid`), tracks without simulcast are not included.
+func ParseSimulcastLayouts(description string) (map[string]SimulcastLayout, error) {
+	parsed := sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(description)); err != nil {
+		return nil, err
+	}
+
+	layouts := make(map[string]SimulcastLayout)
+
+	for _, media := range parsed.MediaDescriptions {
+		var (
+			trackID   string
+			order     []string
+			rids      = make(map[string]SimulcastRID)
+			ridsOrder []string
+		)
+
+		for _, attribute := range media.Attributes {
+			switch attribute.Key {
+			case "msid":
+				// a=msid:<stream id> <track id>
+				if fields := strings.Fields(attribute.Value); len(fields) == 2 {
+					trackID = fields[1]
+				}
+			case "simulcast":
+				// a=simulcast:send q;h;~f (the alternatives are separated by comma, we take the first one)
+				direction, streams, found := strings.Cut(attribute.Value, " ")
+				if !found || direction != "send" {
+					continue
+				}
+
+				for _, stream := range strings.Split(streams, ";") {
+					alternative, _, _ := strings.Cut(stream, ",")
+					order = append(order, strings.TrimPrefix(strings.TrimSpace(alternative), "~"))
+				}
+			case "rid":
+				// a=rid:q send max-width=320;max-height=180
+				if rid, ok := parseRID(attribute.Value); ok {
+					rids[rid.ID] = rid
+					ridsOrder = append(ridsOrder, rid.ID)
+				}
+			}
+		}
+
+		// If there is no `a=simulcast`, we take the order of `a=rid` attributes.
+		if len(order) == 0 {
+			order = ridsOrder
+		}
+
+		if trackID == "" || len(order) == 0 {
+			continue
+		}
+
+		layout := SimulcastLayout{}
+		for _, id := range order {
+			rid, found := rids[id]
+			if !found {
+				rid = SimulcastRID{ID: id}
+			}
+
+			if wellKnown, found := wellKnownRIDs[id]; found {
+				rid.ScaleDownBy = wellKnown.ScaleDownBy
+			}
+
+			layout.RIDs = append(layout.RIDs, rid)
+		}
+
+		layout.sort()
+		layouts[trackID] = layout
+	}
+
+	return layouts, nil
+}
+
+// Parses the value of the `a=rid` attribute if it describes a stream that the remote peer sends.
+func parseRID(value string) (SimulcastRID, bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 || fields[1] != "send" {
+		return SimulcastRID{}, false
+	}
+
+	rid := SimulcastRID{ID: fields[0]}
+	if len(fields) < 3 {
+		return rid, true
+	}
+
+	for _, restriction := range strings.Split(fields[2], ";") {
+		key, value, found := strings.Cut(restriction, "=")
+		if !found {
+			continue
+		}
+
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+
+		switch key {
+		case "max-width":
+			rid.MaxWidth = parsed
+		case "max-height":
+			rid.MaxHeight = parsed
+		case "max-br":
+			rid.MaxBitrate = parsed
+		}
+	}
+
+	return rid, true
+}
+
+// Sorts the layers from the lowest to the highest quality using the restrictions if all layers
+// have them or the scale factors of the well-known RIDs. Otherwise, the order is kept intact.
+func (l *SimulcastLayout) sort() {
+	keys := []func(SimulcastRID) int{
+		func(rid SimulcastRID) int { return rid.MaxWidth * rid.MaxHeight },
+		func(rid SimulcastRID) int { return rid.MaxWidth + rid.MaxHeight },
+		func(rid SimulcastRID) int { return rid.MaxBitrate },
+		func(rid SimulcastRID) int {
+			if rid.ScaleDownBy == 0 {
+				return 0
+			}
+			// The higher the scale factor, the lower the quality.
+			return -rid.ScaleDownBy
+		},
+	}
+
+	for _, key := range keys {
+		if l.sortBy(key) {
+			l.Ordered = true
+			return
+		}
+	}
+}
+
+// Sorts the layers by a given key if all of them have it (i.e. it's not zero).
+func (l *SimulcastLayout) sortBy(key func(SimulcastRID) int) bool {
+	for _, rid := range l.RIDs {
+		if key(rid) == 0 {
+			return false
+		}
+	}
+
+	sort.SliceStable(l.RIDs, func(i, j int) bool {
+		return key(l.RIDs[i]) < key(l.RIDs[j])
+	})
+
+	return true
+}
@@ -0,0 +1,90 @@
+package webrtc_ext //nolint:testpackage
+
+import (
+	"reflect"
+	"testing"
+)
+
+const simulcastSDP = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:0\r\n" +
+	"a=msid:stream restricted\r\n" +
+	"a=rid:a send max-width=1280;max-height=720\r\n" +
+	"a=rid:b send max-width=320;max-height=180\r\n" +
+	"a=rid:c send max-width=640;max-height=360\r\n" +
+	"a=simulcast:send a;b;c\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:1\r\n" +
+	"a=msid:stream wellknown\r\n" +
+	"a=rid:f send\r\n" +
+	"a=rid:q send\r\n" +
+	"a=rid:h send\r\n" +
+	"a=simulcast:send f;~q;h,x\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:2\r\n" +
+	"a=msid:stream unknown\r\n" +
+	"a=rid:2 send\r\n" +
+	"a=rid:1 send\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:3\r\n" +
+	"a=msid:stream plain\r\n"
+
+func TestParseSimulcastLayouts(t *testing.T) {
+	layouts, err := ParseSimulcastLayouts(simulcastSDP)
+	if err != nil {
+		t.Fatalf("failed to parse SDP: %v", err)
+	}
+
+	expected := map[string]SimulcastLayout{
+		// Ordered by the restrictions.
+		"restricted": {RIDs: []SimulcastRID{
+			{ID: "b", MaxWidth: 320, MaxHeight: 180},
+			{ID: "c", MaxWidth: 640, MaxHeight: 360},
+			{ID: "a", MaxWidth: 1280, MaxHeight: 720},
+		}, Ordered: true},
+		// Ordered by the well-known names, paused streams and alternatives are handled.
+		"wellknown": {RIDs: []SimulcastRID{
+			{ID: "q", ScaleDownBy: 4},
+			{ID: "h", ScaleDownBy: 2},
+			{ID: "f", ScaleDownBy: 1},
+		}, Ordered: true},
+		// Nothing is known, so the order of the SDP is kept.
+		"unknown": {RIDs: []SimulcastRID{{ID: "2"}, {ID: "1"}}, Ordered: false},
+	}
+
+	if !reflect.DeepEqual(layouts, expected) {
+		t.Fatalf("unexpected layouts: %+v", layouts)
+	}
+}
+
+func TestSimulcastLayoutLayer(t *testing.T) {
+	layout := SimulcastLayout{RIDs: []SimulcastRID{{ID: "q"}, {ID: "h"}}, Ordered: true}
+
+	if layout.Layer("") != SimulcastLayerNone {
+		t.Fatal("expected no layer for an empty RID")
+	}
+
+	if layout.Layer("h") != SimulcastLayerMedium {
+		t.Fatal("expected the medium layer for the second RID")
+	}
+
+	// Unknown RIDs are added as the highest layer and the layout is not considered ordered anymore.
+	if layout.Layer("f") != SimulcastLayerHigh || layout.Ordered {
+		t.Fatalf("unexpected layout after adding an unknown RID: %+v", layout)
+	}
+
+	if rid, found := layout.RID(SimulcastLayerHigh); !found || rid != (SimulcastRID{ID: "f", ScaleDownBy: 1}) {
+		t.Fatalf("unexpected RID of the high layer: %+v", rid)
+	}
+
+	if _, found := layout.RID(SimulcastLayer(4)); found {
+		t.Fatal("expected no RID for a layer outside of the layout")
+	}
+}
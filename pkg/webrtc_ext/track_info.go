@@ -4,58 +4,31 @@ import (
 	"github.com/pion/webrtc/v3"
 )
 
-type RTCPPacketType int
+// MIME type of the redundant audio (RFC 2198), Pion does not define it.
+const MimeTypeRED = "audio/red"
 
+// Payload types of the audio codecs that the SFU registers. The publishers may negotiate different ones,
+// so the published audio packets are marked with these (see `publisher.RemoteTrack`).
 const (
-	PictureLossIndicator RTCPPacketType = iota + 1
-	FullIntraRequest
+	PayloadTypeOpus webrtc.PayloadType = 111
+	PayloadTypeRED  webrtc.PayloadType = 63
 )
 
-type SimulcastLayer int
+type RTCPPacketType int
 
 const (
-	SimulcastLayerNone SimulcastLayer = iota
-	SimulcastLayerLow
-	SimulcastLayerMedium
-	SimulcastLayerHigh
+	PictureLossIndicator RTCPPacketType = iota + 1
+	FullIntraRequest
 )
 
-func RIDToSimulcastLayer(rid string) SimulcastLayer {
-	switch rid {
-	case "q": // quarter
-		return SimulcastLayerLow
-	case "h": // half
-		return SimulcastLayerMedium
-	case "f": // full
-		return SimulcastLayerHigh
-	default:
-		return SimulcastLayerNone
-	}
-}
-
-func SimulcastLayerToRID(layer SimulcastLayer) string {
-	switch layer {
-	case SimulcastLayerLow:
-		return "q"
-	case SimulcastLayerMedium:
-		return "h"
-	case SimulcastLayerHigh:
-		return "f"
-	default:
-		return ""
-	}
-}
-
-func (s SimulcastLayer) String() string {
-	switch s {
-	case SimulcastLayerLow:
-		return "low"
-	case SimulcastLayerMedium:
-		return "medium"
-	case SimulcastLayerHigh:
-		return "high"
+func (t RTCPPacketType) String() string {
+	switch t {
+	case PictureLossIndicator:
+		return "pli"
+	case FullIntraRequest:
+		return "fir"
 	default:
-		return ""
+		return "unknown"
 	}
 }
 
@@ -4,16 +4,45 @@ import (
 	"fmt"
 
 	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/nack"
 	"github.com/pion/webrtc/v3"
 )
 
 // Creates Pion's WebRTC API that has all required extensions configured (such as simulcast).
-func createWebRTCAPI(config Config) (*webrtc.API, error) {
+// The API must not be shared between peer connections since the RTX interceptor is per connection.
+func createWebRTCAPI(config Config, rtx *RTXInterceptor) (*webrtc.API, error) {
 	mediaEngine := &webrtc.MediaEngine{}
 	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
 		return nil, fmt.Errorf("failed to register default codecs: %w", err)
 	}
 
+	// Opus is among the default codecs, but we need to know its payload type for RED.
+	opus := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeOpus,
+			ClockRate:   48000,
+			Channels:    2,
+			SDPFmtpLine: "minptime=10;useinbandfec=1",
+		},
+		PayloadType: PayloadTypeOpus,
+	}
+	if err := mediaEngine.RegisterCodec(opus, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, fmt.Errorf("failed to register Opus codec: %w", err)
+	}
+
+	// Register redundant audio (RED) for Opus. The payload types are the ones that browsers use.
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    MimeTypeRED,
+			ClockRate:   48000,
+			Channels:    2,
+			SDPFmtpLine: fmt.Sprintf("%d/%d", opus.PayloadType, opus.PayloadType),
+		},
+		PayloadType: PayloadTypeRED,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, fmt.Errorf("failed to register RED codec: %w", err)
+	}
+
 	// Enable extension headers needed for simulcast (if enabled).
 	if config.EnableSimulcast {
 		for _, extension := range []string{
@@ -36,14 +65,36 @@ func createWebRTCAPI(config Config) (*webrtc.API, error) {
 		settingsEngine.SetNAT1To1IPs(config.PublicIPs, webrtc.ICECandidateTypeHost)
 	}
 
+	if config.VirtualNetwork != nil {
+		settingsEngine.SetVNet(config.VirtualNetwork)
+	}
+
 	// Create a InterceptorRegistry. This is the user configurable RTP/RTCP
 	// Pipeline. This provides NACKs, RTCP Reports and other features. If
 	// `webrtc.NewPeerConnection` is used, then it is enabled by default. If
 	// it's managed manually, one must create an InterceptorRegistry for each
 	// PeerConnection.
 	interceptor := &interceptor.Registry{}
-	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptor); err != nil {
-		return nil, fmt.Errorf("failed to set default interceptors: %w", err)
+
+	// RTX goes first, so that the other interceptors (e.g. NACK generator) see the unwrapped
+	// retransmissions as regular packets. It also replaces Pion's default NACK responder.
+	interceptor.Add(&rtxInterceptorFactory{rtx})
+
+	generator, err := nack.NewGeneratorInterceptor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NACK generator: %w", err)
+	}
+
+	interceptor.Add(generator)
+	mediaEngine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	mediaEngine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+
+	if err := webrtc.ConfigureRTCPReports(interceptor); err != nil {
+		return nil, fmt.Errorf("failed to configure RTCP reports: %w", err)
+	}
+
+	if err := webrtc.ConfigureTWCCSender(mediaEngine, interceptor); err != nil {
+		return nil, fmt.Errorf("failed to configure TWCC: %w", err)
 	}
 
 	// Finally, construct the API with the configured media and settings engines.
@@ -30,6 +30,8 @@ type Worker[T any] struct {
 	channel chan<- T
 	mutex   sync.Mutex
 	closed  bool
+	// Closed once the worker handled all remaining tasks after being stopped.
+	done <-chan struct{}
 }
 
 // Stop the channel unless already closed.
@@ -43,6 +45,12 @@ func (c *Worker[T]) Stop() {
 	}
 }
 
+// Returns a channel that is closed once the worker is stopped and all tasks that
+// had been sent before `Stop` are handled.
+func (c *Worker[T]) Done() <-chan struct{} {
+	return c.done
+}
+
 // Send a task to the worker. Returns `true` if the task
 // has been sent, `false` if the channel is already closed.
 func (c *Worker[T]) Send(task T) error {
@@ -65,6 +73,11 @@ func (c *Worker[T]) Send(task T) error {
 	return ErrWorkerClosed
 }
 
+// Returns the number of tasks that are waiting to be handled by the worker.
+func (c *Worker[T]) QueueLength() int {
+	return len(c.channel)
+}
+
 // Starts a worker that periodically (specified by the configuration) executes a `c.OnTimeout` closure if
 // no tasks have been received on a channel for a `c.Timeout`. The worker will stop once the channel is closed,
 // i.e. once the user calls `Stop` explicitly.
@@ -72,8 +85,11 @@ func StartWorker[T any](c Config[T]) *Worker[T] {
 	// The channel that will be used to inform the worker about the reception of a task.
 	// The worker will be stopped once the channel is closed.
 	incoming := make(chan T, c.ChannelSize)
+	done := make(chan struct{})
 
 	go func() {
+		defer close(done)
+
 		for {
 			select {
 			case task, ok := <-incoming:
@@ -87,5 +103,5 @@ func StartWorker[T any](c Config[T]) *Worker[T] {
 		}
 	}()
 
-	return &Worker[T]{incoming, sync.Mutex{}, false}
+	return &Worker[T]{incoming, sync.Mutex{}, false, done}
 }
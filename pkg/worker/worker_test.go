@@ -22,3 +22,30 @@ func BenchmarkWorker(b *testing.B) {
 
 	w.Stop()
 }
+
+func TestWorkerHandlesQueuedTasksAfterStop(t *testing.T) {
+	handled := 0
+	workerConfig := worker.Config[struct{}]{
+		ChannelSize: 4,
+		Timeout:     time.Hour,
+		OnTimeout:   func() {},
+		OnTask: func(struct{}) {
+			time.Sleep(time.Millisecond)
+			handled++
+		},
+	}
+	w := worker.StartWorker(workerConfig)
+
+	for n := 0; n < 4; n++ {
+		if err := w.Send(struct{}{}); err != nil {
+			t.Fatalf("failed to send a task: %v", err)
+		}
+	}
+
+	w.Stop()
+	<-w.Done()
+
+	if handled != 4 {
+		t.Fatalf("expected 4 handled tasks, got %d", handled)
+	}
+}